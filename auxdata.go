@@ -0,0 +1,289 @@
+package tbcload
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AuxDataType is the type character of AuxData in tbc file
+type AuxDataType byte
+
+// Aux data types. TclPro only writes ForeachAuxData,
+// JumpTableAuxData and DictUpdateAuxData are for Tcl 8.5 and later,
+// which are written in format of this package only, see AuxDataExtension
+const (
+	ForeachAuxData    AuxDataType = 'F' //CMP_FOREACH_INFO, foreach_start4/foreach_step4
+	JumpTableAuxData  AuxDataType = 'J' //jumpTable, compiled switch
+	DictUpdateAuxData AuxDataType = 'D' //dictUpdateStart/dictUpdateEnd
+)
+
+// AuxData is one item of aux data array,
+// the field matching Type is set
+type AuxData struct {
+	Type       AuxDataType
+	Foreach    *ForeachInfo
+	JumpTable  *JumpTableInfo
+	DictUpdate *DictUpdateInfo
+}
+
+// ForeachInfo is aux data of foreach, written as
+//
+//	F
+//	numLists firstValueTemp loopCtTemp
+//	for-loop {numVars; varIndexes}
+type ForeachInfo struct {
+	FirstValueTemp int     //local index of temp var holding first value list
+	LoopCtTemp     int     //local index of temp var holding loop counter
+	VarLists       [][]int //local indexes of loop variables, one slice for each value list
+
+	indexPerLine bool //varIndexes were written one per line
+}
+
+// JumpTableInfo is aux data of jumpTable. TclPro defines no format of it,
+// this package extension, which real tbcload can not read, is
+//
+//	J
+//	numEntries
+//	for-loop {keyLength; ascii85 key; offset}
+type JumpTableInfo struct {
+	Entries []JumpTableEntry
+}
+
+// JumpTableEntry is one case of JumpTableInfo
+type JumpTableEntry struct {
+	Key    string
+	Offset int //relative to pc of jumpTable instruction
+}
+
+// DictUpdateInfo is aux data of dict update. TclPro defines no format of it,
+// this package extension, which real tbcload can not read, is
+//
+//	D
+//	length
+//	varIndexes
+type DictUpdateInfo struct {
+	VarIndexes []int //local indexes of variables updated
+}
+
+// ErrUnsupportedAuxDataType means aux data type is not correct
+var ErrUnsupportedAuxDataType = errors.New("aux data type is not supported")
+
+// auxDataTypes is types of aux data, for resync in Tolerant mode
+var auxDataTypes = string([]AuxDataType{ForeachAuxData, JumpTableAuxData, DictUpdateAuxData})
+
+func (p *Parser) parseAuxDataArray() (items []*AuxData, err error) {
+	var num int64
+	var aux *AuxData
+	if num, err = p.parseIntLine(); err != nil {
+		return
+	}
+	for index := 0; index < int(num); index++ {
+		p.section = fmt.Sprintf("aux data %d", index)
+		if aux, err = p.parseAuxData(); err != nil {
+			if p.recover(err) != nil {
+				return
+			}
+			//placeholder keeps index of following aux data, e.g. operand of foreach_start4
+			aux = &AuxData{}
+			var more bool
+			if more, err = p.resync(auxDataTypes, err); err != nil || !more {
+				return append(items, aux), err
+			}
+		}
+		items = append(items, aux)
+	}
+	return
+}
+
+func (p *Parser) parseAuxData() (aux *AuxData, err error) {
+	var auxType byte
+	if auxType, err = p.parseObjectType(); err != nil {
+		return
+	}
+	aux = &AuxData{Type: AuxDataType(auxType)}
+	switch aux.Type {
+	case ForeachAuxData:
+		aux.Foreach, err = p.parseForeachInfo()
+	case JumpTableAuxData:
+		aux.JumpTable, err = p.parseJumpTableInfo()
+	case DictUpdateAuxData:
+		aux.DictUpdate, err = p.parseDictUpdateInfo()
+	default:
+		err = fmt.Errorf("%w: '%c'", ErrUnsupportedAuxDataType, auxType)
+	}
+	return
+}
+
+func (p *Parser) parseForeachInfo() (info *ForeachInfo, err error) {
+	var ints []int64
+	var numLists, numVars int64
+	//1. numLists firstValueTemp loopCtTemp
+	if ints, err = p.parseIntList(); err != nil {
+		return
+	}
+	if len(ints) != 3 {
+		return nil, fmt.Errorf("foreach info has %d fields, expected 3", len(ints))
+	}
+	numLists = ints[0]
+	info = &ForeachInfo{FirstValueTemp: int(ints[1]), LoopCtTemp: int(ints[2])}
+	//2. for-loop {numVars; varIndexes}
+	for index := 0; index < int(numLists); index++ {
+		if numVars, err = p.parseIntLine(); err != nil {
+			return
+		}
+		var vars []int
+		for len(vars) < int(numVars) {
+			if ints, err = p.parseIntList(); err != nil {
+				return
+			}
+			if len(ints) == 0 || len(vars)+len(ints) > int(numVars) {
+				return nil, fmt.Errorf("foreach list %d has %d variables, expected %d", index, len(vars)+len(ints), numVars)
+			}
+			if len(ints) < int(numVars) {
+				info.indexPerLine = true
+			}
+			for _, i := range ints {
+				vars = append(vars, int(i))
+			}
+		}
+		info.VarLists = append(info.VarLists, vars)
+	}
+	return
+}
+
+func (p *Parser) parseJumpTableInfo() (info *JumpTableInfo, err error) {
+	var num, offset int64
+	var key string
+	if num, err = p.parseIntLine(); err != nil {
+		return
+	}
+	info = &JumpTableInfo{}
+	for index := 0; index < int(num); index++ {
+		if key, err = p.parseXStringObject(); err != nil {
+			return
+		}
+		if offset, err = p.parseIntLine(); err != nil {
+			return
+		}
+		info.Entries = append(info.Entries, JumpTableEntry{Key: key, Offset: int(offset)})
+	}
+	return
+}
+
+func (p *Parser) parseDictUpdateInfo() (info *DictUpdateInfo, err error) {
+	var num int64
+	var ints []int64
+	if num, err = p.parseIntLine(); err != nil {
+		return
+	}
+	if ints, err = p.parseIntList(); err != nil {
+		return
+	}
+	if len(ints) != int(num) {
+		return nil, fmt.Errorf("dict update info has %d variables, expected %d", len(ints), num)
+	}
+	info = &DictUpdateInfo{}
+	for _, i := range ints {
+		info.VarIndexes = append(info.VarIndexes, int(i))
+	}
+	return
+}
+
+// ErrAuxDataExtension means aux data can only be written in extension format,
+// which is not enabled by Writer.AuxDataExtension
+var ErrAuxDataExtension = errors.New("aux data is not supported by tbcload")
+
+func (w *Writer) writeAuxData(aux *AuxData) {
+	if aux.Type != ForeachAuxData && !w.AuxDataExtension && w.err == nil {
+		w.err = fmt.Errorf("%w: '%c' is extension of this package", ErrAuxDataExtension, aux.Type)
+	}
+	w.writeLine(string(rune(aux.Type)))
+	switch aux.Type {
+	case ForeachAuxData:
+		info := aux.Foreach
+		w.writeInts(len(info.VarLists), info.FirstValueTemp, info.LoopCtTemp)
+		for _, vars := range info.VarLists {
+			w.writeInts(len(vars))
+			if info.indexPerLine {
+				for _, i := range vars {
+					w.writeInts(i)
+				}
+			} else {
+				w.writeInts(vars...)
+			}
+		}
+	case JumpTableAuxData:
+		w.writeInts(len(aux.JumpTable.Entries))
+		for _, entry := range aux.JumpTable.Entries {
+			w.writeBytes([]byte(w.header.toTclString(entry.Key)))
+			w.writeInts(entry.Offset)
+		}
+	case DictUpdateAuxData:
+		w.writeInts(len(aux.DictUpdate.VarIndexes))
+		w.writeInts(aux.DictUpdate.VarIndexes...)
+	}
+}
+
+// String return as "foreach,firstValueTemp=1,loopCtTemp=2,vars={%0} {%3 %4}"
+func (aux *AuxData) String() string {
+	switch aux.Type {
+	case ForeachAuxData:
+		info := aux.Foreach
+		return fmt.Sprintf("foreach,firstValueTemp=%d,loopCtTemp=%d,vars=%s", info.FirstValueTemp, info.LoopCtTemp, formatForeachVars(info, nil))
+	case JumpTableAuxData:
+		return "jumptable," + formatJumpTable(aux.JumpTable, 0, false)
+	case DictUpdateAuxData:
+		return "dictupdate,vars=" + formatVarIndexes(aux.DictUpdate.VarIndexes, nil)
+	}
+	return fmt.Sprintf("unknown '%c'", aux.Type)
+}
+
+// summary return one line description for annotation of instruction at pc,
+// variables are shown by name of locals
+func (aux *AuxData) summary(pc int, locals []*CompiledLocal) string {
+	switch aux.Type {
+	case ForeachAuxData:
+		return "foreach " + formatForeachVars(aux.Foreach, locals)
+	case JumpTableAuxData:
+		return "jumptable " + formatJumpTable(aux.JumpTable, pc, true)
+	case DictUpdateAuxData:
+		return "dictupdate " + formatVarIndexes(aux.DictUpdate.VarIndexes, locals)
+	}
+	return aux.String()
+}
+
+func formatForeachVars(info *ForeachInfo, locals []*CompiledLocal) string {
+	var lists []string
+	for _, vars := range info.VarLists {
+		lists = append(lists, "{"+formatVarIndexes(vars, locals)+"}")
+	}
+	return strings.Join(lists, " ")
+}
+
+// formatVarIndexes return names of local variables, or %index if not found
+func formatVarIndexes(indexes []int, locals []*CompiledLocal) string {
+	var names []string
+	for _, i := range indexes {
+		if local := findLocal(locals, i); local != nil {
+			names = append(names, local.Name)
+		} else {
+			names = append(names, "%"+strconv.Itoa(i))
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// formatJumpTable return as `"a"->+12 "b"->+20`, or `"a"->pc 12` if absolute
+func formatJumpTable(info *JumpTableInfo, pc int, absolute bool) string {
+	var entries []string
+	for _, entry := range info.Entries {
+		if absolute {
+			entries = append(entries, fmt.Sprintf("%s->pc %d", strconv.Quote(entry.Key), pc+entry.Offset))
+		} else {
+			entries = append(entries, fmt.Sprintf("%s->%+d", strconv.Quote(entry.Key), entry.Offset))
+		}
+	}
+	return strings.Join(entries, " ")
+}
@@ -0,0 +1,89 @@
+package tbcload
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseAuxData(t *testing.T) {
+	for _, v := range []struct {
+		text string
+		str  string
+	}{
+		{"F\n1 0 1\n1\n2\n", "foreach,firstValueTemp=0,loopCtTemp=1,vars={%2}"},
+		{"F\n2 5 7\n2\n2 3\n1\n4\n", "foreach,firstValueTemp=5,loopCtTemp=7,vars={%2 %3} {%4}"},
+		{"F\n1 0 1\n2\n2\n3\n", "foreach,firstValueTemp=0,loopCtTemp=1,vars={%2 %3}"},
+		{"D\n3\n0 2 4\n", "dictupdate,vars=%0 %2 %4"},
+		{"J\n2\n1\n-v\n12\n1\n.v\n20\n", `jumptable,"a"->+12 "b"->+20`},
+	} {
+		p := NewParser(strings.NewReader(v.text), nil)
+		p.header = defaultHeader
+		aux, err := p.parseAuxData()
+		if err != nil {
+			t.Fatalf("%q: %s", v.text, err)
+		}
+		if aux.String() != v.str {
+			t.Errorf("%q: expected %s, got %s", v.text, v.str, aux)
+		}
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.header = defaultHeader
+		w.writeAuxData(aux)
+		w.w.Flush()
+		if buf.String() != v.text {
+			t.Errorf("%q: wrong format %q", v.text, buf.String())
+		}
+	}
+	for _, text := range []string{"X\n", "F\n1 0\n", "F\n1 0 1\n1\n2 3\n", "D\n2\n0\n"} {
+		p := NewParser(strings.NewReader(text), nil)
+		if _, err := p.parseAuxData(); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+}
+
+func TestParseAuxDataTolerant(t *testing.T) {
+	//foreach info of aux data 0 is damaged
+	p := NewParser(strings.NewReader("3\nF\n1 0\nD\n1\n0\nF\n1 0 1\n1\n2\n"), nil)
+	p.header, p.Tolerant = defaultHeader, true
+	items, err := p.parseAuxDataArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Diagnostics) != 1 {
+		t.Errorf("expected 1 diagnostic, got %v", p.Diagnostics)
+	}
+	var types []string
+	for _, aux := range items {
+		types = append(types, aux.String())
+	}
+	expected := "unknown '\x00',dictupdate,vars=%0,foreach,firstValueTemp=0,loopCtTemp=1,vars={%2}"
+	if s := strings.Join(types, ","); s != expected {
+		t.Errorf("expected aux data %s, got %s", expected, s)
+	}
+}
+
+func TestDumpAuxData(t *testing.T) {
+	fs, err := os.Open("testdata/foreach.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	p := NewParser(fs, &out)
+	p.Detail = true
+	if err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"\t(10)foreach_start4 0\t# foreach {a b} {c}\n",
+		"\t(2)storeScalar1 5\t# temp %5\n",
+		"[aux-00]foreach,firstValueTemp=5,loopCtTemp=7,vars={%2 %3} {%4}\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected %q in output:\n%s", s, out.String())
+		}
+	}
+}
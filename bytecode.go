@@ -26,7 +26,8 @@ const (
 )
 const INT_MIN = 0x8000
 
-var tclOpTable = []InstructionDesc{
+// tcl86OpTable is opcode table of Tcl 8.6, older tables are derived from it.
+var tcl86OpTable = []InstructionDesc{
 	/* Name	      Bytes stackEffect #Opnds  Operand types */
 	{"done", 1, -1, 0, [2]byte{OPERAND_NONE}},
 	/* Finish ByteCode execution and return stktop (top stack item) */
@@ -173,10 +174,6 @@ var tclOpTable = []InstructionDesc{
 	{"foreach_start4", 5, 0, 1, [2]byte{OPERAND_AUX4}},
 	/* Initialize execution of a foreach loop. Operand is aux data index
 	 * of the ForeachInfo structure for the foreach command. */
-
-	//for 8.2 this is wrong,
-	//we try,try,try.......
-	//{"foreach_step4", 2, +1, 1, [2]byte{OPERAND_INT1}},
 	{"foreach_step4", 5, +1, 1, [2]byte{OPERAND_AUX4}},
 
 	/* "Step" or begin next iteration of foreach loop. Push 0 if to
@@ -647,6 +644,56 @@ var tclOpTable = []InstructionDesc{
 	/* Read clock out to the stack. Operand is which clock to read
 	 * 0=clicks, 1=microseconds, 2=milliseconds, 3=seconds.
 	 * Stack: ... => ... time */
+}
+
+// number of opcodes of each Tcl version
+const (
+	numOpcodes81 = 73  //8.0-8.3, last is pushReturnCode
+	numOpcodes84 = 98  //last is lsetFlat
+	numOpcodes85 = 134 //last is returnCodeBranch
+)
+
+// tcl84OpTable is opcode table of Tcl 8.4
+var tcl84OpTable = deriveOpTable(numOpcodes84, map[int]string{
+	5:  "concat1",
+	80: "listindex",
+	81: "listlength",
+})
+
+// tcl81OpTable is opcode table of Tcl 8.1-8.3
+var tcl81OpTable = deriveOpTable(numOpcodes81, map[int]string{
+	5: "concat1",
+})
+
+// tcl80OpTable is opcode table of Tcl 8.0,
+// numbering and sizes did not change until 8.4
+var tcl80OpTable = tcl81OpTable
+
+// tcl85OpTable is opcode table of Tcl 8.5
+var tcl85OpTable = deriveOpTable(numOpcodes85, map[int]string{
+	5: "concat1",
+})
+
+// deriveOpTable copy first num opcodes of tcl86OpTable, renamed as names
+func deriveOpTable(num int, names map[int]string) []InstructionDesc {
+	table := append([]InstructionDesc(nil), tcl86OpTable[:num]...)
+	for index, name := range names {
+		table[index].name = name
+	}
+	return table
+}
 
-	{"", 0, 0, 0, [2]byte{OPERAND_NONE}},
+// opTableFor return opcode table of Tcl version(major*10+minor, e.g. 83)
+func opTableFor(version int) []InstructionDesc {
+	switch {
+	case version <= 80:
+		return tcl80OpTable
+	case version <= 83:
+		return tcl81OpTable
+	case version == 84:
+		return tcl84OpTable
+	case version == 85:
+		return tcl85OpTable
+	}
+	return tcl86OpTable
 }
@@ -0,0 +1,40 @@
+package tbcload
+
+import "testing"
+
+func TestOpTableFor(t *testing.T) {
+	for _, v := range []struct {
+		version int
+		num     int
+		opcode  int
+		name    string
+	}{
+		{80, 73, 5, "concat1"},
+		{83, 73, 72, "pushReturnCode"},
+		{84, 98, 80, "listindex"},
+		{85, 134, 121, "jumpTable"},
+		{85, 134, 5, "concat1"},
+		{86, 190, 5, "strcat"},
+	} {
+		table := opTableFor(v.version)
+		if len(table) != v.num {
+			t.Errorf("Tcl %d: expected %d opcodes, got %d", v.version, v.num, len(table))
+		}
+		if table[v.opcode].name != v.name {
+			t.Errorf("Tcl %d: expected opcode %d as %s, got %s", v.version, v.opcode, v.name, table[v.opcode].name)
+		}
+	}
+	if tcl86OpTable[5].name != "strcat" {
+		t.Errorf("deriving tables should not modify tcl86OpTable")
+	}
+}
+
+func TestParesOneOpVersion(t *testing.T) {
+	//streq is not an opcode of Tcl 8.3
+	if _, _, err := paresOneOp(opTableFor(83), []byte{73}); err == nil {
+		t.Errorf("expected error for opcode 73 of Tcl 8.3")
+	}
+	if s, n, err := paresOneOp(opTableFor(84), []byte{73}); err != nil || s != "streq" || n != 1 {
+		t.Errorf("expected streq of Tcl 8.4, got %s,%d,%v", s, n, err)
+	}
+}
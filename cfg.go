@@ -0,0 +1,270 @@
+package tbcload
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EdgeKind is kind of control flow between basic blocks
+type EdgeKind int
+
+// Edge kinds of CFG
+const (
+	FallthroughEdge EdgeKind = iota //to next block
+	JumpEdge                        //unconditional jump
+	TrueEdge                        //jumpTrue taken, or jumpFalse not taken
+	FalseEdge                       //jumpFalse taken, or jumpTrue not taken
+	BreakEdge                       //break to loop exception range
+	ContinueEdge                    //continue to loop exception range
+	CatchEdge                       //error in catch exception range
+	JumpTableEdge                   //case of jumpTable
+)
+
+var edgeKindNames = [...]string{"fallthrough", "jump", "true", "false", "break", "continue", "catch", "jumptable"}
+
+func (k EdgeKind) String() string {
+	if int(k) < len(edgeKindNames) {
+		return edgeKindNames[k]
+	}
+	return fmt.Sprintf("edge(%d)", int(k))
+}
+
+// Edge is control flow from block From to block To, by index of CFG.Blocks
+type Edge struct {
+	From, To int
+	Kind     EdgeKind
+	Label    string //key of JumpTableEdge
+}
+
+// BasicBlock is instructions executed in sequence,
+// only the first is target of jump, only the last may jump
+type BasicBlock struct {
+	Index        int
+	Start, End   int //pc range [Start,End)
+	Instructions []Instruction
+}
+
+// CFG is control flow graph of ByteCode
+type CFG struct {
+	Blocks []*BasicBlock
+	Edges  []Edge
+
+	bc     *ByteCode
+	locals []*CompiledLocal
+}
+
+// NewCFG build control flow graph of bc, by opcode table of tclVersion, e.g. "8.3".
+// locals are compiled locals of procedure, to annotate instructions
+func NewCFG(bc *ByteCode, locals []*CompiledLocal, tclVersion string) (*CFG, error) {
+	v := parseTclVersion(tclVersion)
+	if v < minTclVersion || v > maxTclVersion {
+		return nil, fmt.Errorf("%w: Tcl %s", ErrUnsupportedVersion, tclVersion)
+	}
+	return newCFG(opTableFor(v), bc, locals)
+}
+
+func newCFG(opTable []InstructionDesc, bc *ByteCode, locals []*CompiledLocal) (g *CFG, err error) {
+	var code []Instruction
+	var locs []CommandLocation
+	if code, err = disassemble(opTable, bc.Code); err != nil {
+		return
+	}
+	if locs, err = bc.CommandLocations(); err != nil {
+		return
+	}
+	g = &CFG{bc: bc, locals: locals}
+
+	//1. leaders: jump targets, instructions after jumps, command and exception range boundaries
+	leaders := map[int]bool{0: true}
+	for _, loc := range locs {
+		leaders[loc.CodeOffset] = true
+	}
+	for _, r := range bc.ExceptionRanges {
+		leaders[r.CodeOffset], leaders[r.End()] = true, true
+		for _, pc := range []int{r.BreakOffset, r.ContinueOffset, r.CatchOffset} {
+			if pc >= 0 {
+				leaders[pc] = true
+			}
+		}
+	}
+	for index := range code {
+		ins := &code[index]
+		for _, pc := range g.targets(ins) {
+			leaders[pc] = true
+		}
+		if endsBlock(ins) {
+			leaders[ins.PC+ins.Size] = true
+		}
+	}
+
+	//2. split blocks
+	blockAt := map[int]int{}
+	for index := range code {
+		ins := code[index]
+		if leaders[ins.PC] || len(g.Blocks) == 0 {
+			blockAt[ins.PC] = len(g.Blocks)
+			g.Blocks = append(g.Blocks, &BasicBlock{Index: len(g.Blocks), Start: ins.PC})
+		}
+		b := g.Blocks[len(g.Blocks)-1]
+		b.Instructions = append(b.Instructions, ins)
+		b.End = ins.PC + ins.Size
+	}
+
+	//3. edges
+	for _, b := range g.Blocks {
+		g.addEdges(b, blockAt)
+	}
+	return
+}
+
+// endsBlock return true if instruction after ins begins new block
+func endsBlock(ins *Instruction) bool {
+	switch ins.Name {
+	case "done", "break", "continue", "jumpTable", "returnImm", "returnStk", "syntax", "tailcall":
+		return true
+	}
+	return isJump(ins) || isCondJump(ins)
+}
+
+// targets return pc of jump destinations of ins
+func (g *CFG) targets(ins *Instruction) (res []int) {
+	if isJump(ins) || isCondJump(ins) {
+		return []int{ins.PC + ins.Operands[0]}
+	}
+	if ins.Name == "jumpTable" {
+		for _, entry := range g.jumpTableEntries(ins) {
+			res = append(res, ins.PC+entry.Offset)
+		}
+	}
+	return
+}
+
+// jumpTableEntries return entries of aux data of jumpTable ins,
+// nil if operand is not index of jump table aux data
+func (g *CFG) jumpTableEntries(ins *Instruction) []JumpTableEntry {
+	if ins.Operands[0] < len(g.bc.AuxData) {
+		if aux := g.bc.AuxData[ins.Operands[0]]; aux.Type == JumpTableAuxData && aux.JumpTable != nil {
+			return aux.JumpTable.Entries
+		}
+	}
+	return nil
+}
+
+func (g *CFG) addEdges(b *BasicBlock, blockAt map[int]int) {
+	add := func(pc int, kind EdgeKind, label string) {
+		if to, ok := blockAt[pc]; ok {
+			g.Edges = append(g.Edges, Edge{From: b.Index, To: to, Kind: kind, Label: label})
+		}
+	}
+	last := &b.Instructions[len(b.Instructions)-1]
+	switch {
+	case isJump(last):
+		add(g.targets(last)[0], JumpEdge, "")
+	case isCondJump(last):
+		taken, notTaken := TrueEdge, FalseEdge
+		if strings.HasPrefix(last.Name, "jumpFalse") {
+			taken, notTaken = FalseEdge, TrueEdge
+		}
+		add(g.targets(last)[0], taken, "")
+		add(b.End, notTaken, "")
+	case last.Name == "jumpTable":
+		for _, entry := range g.jumpTableEntries(last) {
+			add(last.PC+entry.Offset, JumpTableEdge, entry.Key)
+		}
+		add(b.End, FallthroughEdge, "")
+	case last.Name == "break" || last.Name == "continue":
+		if r := g.innerRange(last.PC, LoopExceptionRange); r != nil {
+			if last.Name == "break" {
+				add(r.BreakOffset, BreakEdge, "")
+			} else {
+				add(r.ContinueOffset, ContinueEdge, "")
+			}
+		}
+	case endsBlock(last):
+		//done, return
+	default:
+		add(b.End, FallthroughEdge, "")
+	}
+	if r := g.innerRange(b.Start, CatchExceptionRange); r != nil {
+		add(r.CatchOffset, CatchEdge, "")
+	}
+}
+
+// innerRange return the innermost exception range of type t, which includes pc
+func (g *CFG) innerRange(pc int, t ExceptionRangeType) (res *ExceptionRange) {
+	for _, r := range g.bc.ExceptionRanges {
+		if r.Type == t && r.CodeOffset <= pc && pc < r.End() && (res == nil || r.NestingLevel > res.NestingLevel) {
+			res = r
+		}
+	}
+	return
+}
+
+// Succs return edges from block b
+func (g *CFG) Succs(b int) (res []Edge) {
+	for _, e := range g.Edges {
+		if e.From == b {
+			res = append(res, e)
+		}
+	}
+	return
+}
+
+// Preds return edges to block b
+func (g *CFG) Preds(b int) (res []Edge) {
+	for _, e := range g.Edges {
+		if e.To == b {
+			res = append(res, e)
+		}
+	}
+	return
+}
+
+// WriteDot write g in Graphviz DOT language, as digraph named name
+func (g *CFG) WriteDot(w io.Writer, name string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", dotQuote(name))
+	bw.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, b := range g.Blocks {
+		var label strings.Builder
+		fmt.Fprintf(&label, "block %d, pc %d-%d\n", b.Index, b.Start, b.End-1)
+		for index := range b.Instructions {
+			ins := &b.Instructions[index]
+			fmt.Fprintf(&label, "(%d)%s", ins.PC, ins)
+			if note := annotate(ins, g.bc, g.locals); note != "" {
+				label.WriteString("  # " + note)
+			}
+			label.WriteByte('\n')
+		}
+		fmt.Fprintf(bw, "\tb%d [label=%s];\n", b.Index, dotLabel(label.String()))
+	}
+	edges := append([]Edge(nil), g.Edges...)
+	sort.SliceStable(edges, func(i, j int) bool { return edges[i].From < edges[j].From })
+	for _, e := range edges {
+		label := e.Kind.String()
+		if e.Label != "" {
+			label = fmt.Sprintf("%q", e.Label)
+		}
+		fmt.Fprintf(bw, "\tb%d -> b%d [label=%s", e.From, e.To, dotQuote(label))
+		switch e.Kind {
+		case BreakEdge, ContinueEdge, CatchEdge:
+			bw.WriteString(", style=dashed")
+		}
+		bw.WriteString("];\n")
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// dotQuote return s as quoted ID of DOT
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// dotLabel return lines as quoted label of DOT, lines are left aligned
+func dotLabel(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\l`).Replace(s) + `"`
+}
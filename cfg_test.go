@@ -0,0 +1,128 @@
+package tbcload
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readTestFile(t *testing.T, fileName string) *File {
+	fs, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	f, err := ReadFile(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// edgesOf return edges of g as "from->to kind"
+func edgesOf(g *CFG) (res []string) {
+	for _, e := range g.Edges {
+		s := fmt.Sprintf("%d->%d %s", e.From, e.To, e.Kind)
+		if e.Label != "" {
+			s += " " + e.Label
+		}
+		res = append(res, s)
+	}
+	return
+}
+
+func TestCFG(t *testing.T) {
+	f := readTestFile(t, "testdata/catch.tbc")
+	g, err := NewCFG(f.ByteCode, nil, "8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []int
+	for _, b := range g.Blocks {
+		starts = append(starts, b.Start)
+	}
+	if s := fmt.Sprint(starts); s != "[0 6 14 19 21 24 31 35 41 45]" {
+		t.Errorf("unexpected blocks %s", s)
+	}
+	expected := "0->1 fallthrough,1->4 false,1->2 true,2->3 fallthrough,3->1 jump,4->5 fallthrough," +
+		"5->6 fallthrough,6->7 fallthrough,6->8 catch,7->9 jump,8->9 fallthrough"
+	if s := strings.Join(edgesOf(g), ","); s != expected {
+		t.Errorf("expected edges %s, got %s", expected, s)
+	}
+	if preds := g.Preds(9); len(preds) != 2 {
+		t.Errorf("expected 2 predecessors of block 9, got %v", preds)
+	}
+}
+
+func TestCFGJumpTable(t *testing.T) {
+	//switch $x {a - b {puts a} c {puts b}}
+	bc := &ByteCode{Literals: stringLiterals("puts", "a", "b", ""), Code: assemble(tcl86OpTable,
+		"loadScalar1 0", "jumpTable 0", "jump4 27",
+		"push1 0", "push1 1", "invokeStk1 2", "jump4 18",
+		"push1 0", "push1 2", "invokeStk1 2", "jump4 7",
+		"push1 3", "done"),
+		AuxData: []*AuxData{{Type: JumpTableAuxData, JumpTable: &JumpTableInfo{Entries: []JumpTableEntry{{"a", 10}, {"b", 10}, {"c", 21}}}}},
+	}
+	g, err := NewCFG(bc, nil, "8.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "0->2 jumptable a,0->2 jumptable b,0->3 jumptable c,0->1 fallthrough,1->4 jump,2->5 jump,3->5 jump,4->5 fallthrough"
+	if s := strings.Join(edgesOf(g), ","); s != expected {
+		t.Errorf("expected edges %s, got %s", expected, s)
+	}
+}
+
+func TestCFGJumpTableTampered(t *testing.T) {
+	code := assemble(tcl86OpTable, "loadScalar1 0", "jumpTable 1", "push1 0", "done")
+	for _, aux := range [][]*AuxData{nil, {{Type: JumpTableAuxData}, {Type: ForeachAuxData, Foreach: &ForeachInfo{}}}} {
+		g, err := NewCFG(&ByteCode{Literals: stringLiterals(""), Code: code, AuxData: aux}, nil, "8.6")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := strings.Join(edgesOf(g), ","); s != "0->1 fallthrough" {
+			t.Errorf("expected only fallthrough edge, got %s", s)
+		}
+	}
+}
+
+func TestCFGBreak(t *testing.T) {
+	//while 1 {break}
+	bc := &ByteCode{Literals: stringLiterals(""), Code: assemble(tcl80OpTable,
+		"break", "jump1 -1", "push1 0", "done"),
+		ExceptionRanges: []*ExceptionRange{{Type: LoopExceptionRange, CodeOffset: 0, NumCodeBytes: 1, BreakOffset: 3, ContinueOffset: 0, CatchOffset: -1}},
+	}
+	g, err := NewCFG(bc, nil, "8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(edgesOf(g), ","); s != "0->2 break,1->0 jump" {
+		t.Errorf("unexpected edges %s", s)
+	}
+}
+
+func TestWriteDot(t *testing.T) {
+	f := readTestFile(t, "testdata/hello.tbc")
+	procs, err := f.Procs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) != 1 || procs[0].Name != "hello" || procs[0].Args != "name" {
+		t.Fatalf("unexpected procedures %v", procs)
+	}
+	g, err := NewCFG(procs[0].Proc.ByteCode, procs[0].Proc.Locals, "8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err = g.WriteDot(&out, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`digraph "hello" {`, `(0)push1 0  # \"puts\"\l`, `(4)loadScalar1 0  # var \"name\"\l`} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected %s in:\n%s", s, out.String())
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package tbcload
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// CommandLocation is code range of one command
+type CommandLocation struct {
+	CodeOffset int //pc of first instruction
+	CodeLength int //number of code bytes
+}
+
+// cmdLocEscape means the entry is stored as following 4-byte integer
+const cmdLocEscape = 0xFF
+
+// maxCmdLocByte is the largest entry stored as one byte
+const maxCmdLocByte = 127
+
+// CommandLocations decode CodeDelta and CodeLength into command locations
+func (bc *ByteCode) CommandLocations() (locs []CommandLocation, err error) {
+	var deltas, lengths []int
+	if deltas, err = decodeCmdLocBytes(bc.CodeDelta); err != nil {
+		return nil, fmt.Errorf("codeDelta: %w", err)
+	}
+	if lengths, err = decodeCmdLocBytes(bc.CodeLength); err != nil {
+		return nil, fmt.Errorf("codeLength: %w", err)
+	}
+	if len(deltas) != len(lengths) {
+		return nil, fmt.Errorf("codeDelta has %d entries, but codeLength has %d", len(deltas), len(lengths))
+	}
+	offset := 0
+	for index, delta := range deltas {
+		offset += delta
+		locs = append(locs, CommandLocation{CodeOffset: offset, CodeLength: lengths[index]})
+	}
+	return
+}
+
+// SetCommandLocations encode locs into CodeDelta and CodeLength,
+// locs must be ordered by CodeOffset
+func (bc *ByteCode) SetCommandLocations(locs []CommandLocation) {
+	var deltas, lengths []int
+	offset := 0
+	for _, loc := range locs {
+		deltas = append(deltas, loc.CodeOffset-offset)
+		lengths = append(lengths, loc.CodeLength)
+		offset = loc.CodeOffset
+	}
+	bc.CodeDelta = encodeCmdLocBytes(deltas)
+	bc.CodeLength = encodeCmdLocBytes(lengths)
+}
+
+// decodeCmdLocBytes decode entries, each is one byte,
+// or 0xFF followed by a 4-byte big-endian integer
+func decodeCmdLocBytes(src []byte) (res []int, err error) {
+	for len(src) > 0 {
+		if src[0] != cmdLocEscape {
+			res = append(res, int(src[0]))
+			src = src[1:]
+			continue
+		}
+		if len(src) < 5 {
+			return nil, fmt.Errorf("entry %d: 0xFF followed by %d bytes, expected 4", len(res), len(src)-1)
+		}
+		res = append(res, int(int32(binary.BigEndian.Uint32(src[1:5]))))
+		src = src[5:]
+	}
+	return
+}
+
+func encodeCmdLocBytes(entries []int) (res []byte) {
+	for _, i := range entries {
+		if i >= 0 && i <= maxCmdLocByte {
+			res = append(res, byte(i))
+			continue
+		}
+		res = append(res, cmdLocEscape, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(res[len(res)-4:], uint32(i))
+	}
+	return
+}
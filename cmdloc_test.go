@@ -0,0 +1,65 @@
+package tbcload
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandLocations(t *testing.T) {
+	bc := &ByteCode{
+		CodeDelta:  []byte{0, 0xFF, 0, 0, 1, 0x2c, 2},
+		CodeLength: []byte{0xFF, 0, 0, 1, 0x2e, 0xFF, 0, 0, 0, 200, 3},
+	}
+	locs, err := bc.CommandLocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CommandLocation{{0, 302}, {300, 200}, {302, 3}}
+	if !reflect.DeepEqual(locs, expected) {
+		t.Errorf("expected %v, got %v", expected, locs)
+	}
+
+	var out ByteCode
+	out.SetCommandLocations(expected)
+	if !bytes.Equal(out.CodeDelta, bc.CodeDelta) {
+		t.Errorf("wrong codeDelta %x", out.CodeDelta)
+	}
+	if !bytes.Equal(out.CodeLength, []byte{0xFF, 0, 0, 1, 0x2e, 0xFF, 0, 0, 0, 200, 3}) {
+		t.Errorf("wrong codeLength %x", out.CodeLength)
+	}
+}
+
+func TestCommandLocationsTruncated(t *testing.T) {
+	bc := &ByteCode{CodeDelta: []byte{0, 0xFF, 0, 1}, CodeLength: []byte{1, 1}}
+	if _, err := bc.CommandLocations(); err == nil {
+		t.Errorf("expected error for truncated codeDelta")
+	}
+}
+
+func TestDumpLongCommand(t *testing.T) {
+	//command 0: 150 x push1 0, command 1: done
+	code := bytes.Repeat([]byte{1, 0}, 150)
+	code = append(code, 0)
+	bc := &ByteCode{
+		Info:     StructInfo{NumCommands: 2, NumCodeBytes: len(code), NumLitObjects: 1, MaxStackDepth: 150},
+		Code:     code,
+		Literals: []*Literal{{Type: LiteralString, Value: "a"}},
+	}
+	bc.SetCommandLocations([]CommandLocation{{0, 300}, {300, 1}})
+	bc.Info.NumCmdLocBytes = len(bc.CodeDelta) + len(bc.CodeLength)
+
+	var tbc, out bytes.Buffer
+	if err := NewWriter(&tbc).WriteFile(&File{Block: Block{ByteCode: bc}}); err != nil {
+		t.Fatal(err)
+	}
+	p := NewParser(&tbc, &out)
+	p.Detail = true
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\tCommand 1,pc= 300-300\n\t(300)done\n") {
+		t.Errorf("wrong command location in output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,577 @@
+package tbcload
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// flags of CompiledLocal
+const (
+	varArgument  = 0x100 //VAR_ARGUMENT, argument of procedure
+	varTemporary = 0x200 //VAR_TEMPORARY, temp without name
+	varIsArgs    = 0x400 //VAR_IS_ARGS of Tcl 8.4+, "args" as last argument
+)
+
+// maxOperand1 is the largest unsigned operand of 1-byte form, e.g. push1
+const maxOperand1 = 255
+
+// Compiler compile Tcl script into .tbc File, as TclPro procomp.
+// Commands set, incr, append, expr, if, while, for, foreach, catch,
+// break, continue and proc are compiled inline, others are invoked
+type Compiler struct {
+	TclVersion string //target Tcl version, "8.0" if ""
+}
+
+// NewCompiler create Compiler
+func NewCompiler() *Compiler {
+	return &Compiler{}
+}
+
+// Compile compile Tcl script into File, which can be loaded by tbcload
+func (c *Compiler) Compile(script string) (*File, error) {
+	header := defaultHeader
+	if c.TclVersion != "" {
+		header.TclVersion = c.TclVersion
+	}
+	if err := header.check(); err != nil {
+		return nil, err
+	}
+	opTable := opTableFor(header.tclVersion())
+	u := newCompileUnit(opTable, nil)
+	if err := u.compileScript(script); err != nil {
+		return nil, err
+	}
+	u.emit("done")
+	bc, err := u.byteCode(len(script))
+	if err != nil {
+		return nil, err
+	}
+	return &File{Block: Block{Prologue: tbcFilePrologue, Header: header, ByteCode: bc}, Epilogue: tbcFileEpilogue}, nil
+}
+
+// asmInstruction is instruction before assembling, size of jump is decided at last
+type asmInstruction struct {
+	name     string //name of instruction, name without 1/4 for jump
+	operands []int
+	target   int //label of jump, -1 if not jump
+	jump4    bool
+}
+
+// asmRange is exception range by labels
+type asmRange struct {
+	t                                     ExceptionRangeType
+	level                                 int
+	start, end, breakL, continueL, catchL int
+}
+
+// compileUnit compile one ByteCode, top level script or procedure body
+type compileUnit struct {
+	opTable  []InstructionDesc
+	opIndex  map[string]int
+	code     []asmInstruction
+	labels   []int //instruction index of label, -1 if not set
+	literals []*Literal
+	litIndex map[string]int
+	proc     *Procedure //nil for top level, variables are not compiled locals
+	ranges   []*asmRange
+	cmdLocs  [][2]int //start and end labels of commands
+	auxData  []*AuxData
+	depth    int //nesting level of exception ranges
+	maxDepth int
+}
+
+func newCompileUnit(opTable []InstructionDesc, proc *Procedure) *compileUnit {
+	u := &compileUnit{opTable: opTable, opIndex: map[string]int{}, litIndex: map[string]int{}, proc: proc}
+	for index, desc := range opTable {
+		u.opIndex[desc.name] = index
+	}
+	return u
+}
+
+func (u *compileUnit) has(name string) bool {
+	_, ok := u.opIndex[name]
+	return ok
+}
+
+func (u *compileUnit) emit(name string, operands ...int) {
+	u.code = append(u.code, asmInstruction{name: name, operands: operands, target: -1})
+}
+
+// emitIndex emit 1-byte or 4-byte form of instruction name, by index
+func (u *compileUnit) emitIndex(name string, index int, operands ...int) {
+	if index <= maxOperand1 {
+		u.emit(name+"1", append([]int{index}, operands...)...)
+	} else {
+		u.emit(name+"4", append([]int{index}, operands...)...)
+	}
+}
+
+// emitJump emit jump, jumpTrue or jumpFalse to label
+func (u *compileUnit) emitJump(name string, label int) {
+	u.code = append(u.code, asmInstruction{name: name, target: label})
+}
+
+func (u *compileUnit) newLabel() int {
+	u.labels = append(u.labels, -1)
+	return len(u.labels) - 1
+}
+
+// setLabel set label to the next instruction
+func (u *compileUnit) setLabel(label int) {
+	u.labels[label] = len(u.code)
+}
+
+func (u *compileUnit) label() int {
+	l := u.newLabel()
+	u.setLabel(l)
+	return l
+}
+
+// literalType return type of literal s, as written by TclPro
+func literalType(s string) LiteralType {
+	if i, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(i, 10) == s {
+		return LiteralInt
+	}
+	if s == "" {
+		return LiteralXString
+	}
+	for index := 0; index < len(s); index++ {
+		if s[index] <= ' ' || s[index] > '~' {
+			return LiteralXString
+		}
+	}
+	return LiteralString
+}
+
+func (u *compileUnit) push(s string) {
+	index, ok := u.litIndex[s]
+	if !ok {
+		index = len(u.literals)
+		u.literals = append(u.literals, &Literal{Type: literalType(s), Value: s})
+		u.litIndex[s] = index
+	}
+	u.emitIndex("push", index)
+}
+
+// concat emit concatenation of n values on stack
+func (u *compileUnit) concat(n int) {
+	if u.has("concat1") {
+		u.emit("concat1", n)
+	} else {
+		u.emit("strcat", n)
+	}
+}
+
+// local return index of compiled local name, created if not found,
+// return -1 if variables are not compiled locals
+func (u *compileUnit) local(name string) int {
+	if u.proc == nil || name == "" || strings.Contains(name, "::") {
+		return -1
+	}
+	for _, local := range u.proc.Locals {
+		if local.Name == name && local.Flags&varTemporary == 0 {
+			return local.Index
+		}
+	}
+	u.proc.Locals = append(u.proc.Locals, &CompiledLocal{Name: name, Index: len(u.proc.Locals)})
+	return len(u.proc.Locals) - 1
+}
+
+// temp return index of a new temp local
+func (u *compileUnit) temp() int {
+	u.proc.Locals = append(u.proc.Locals, &CompiledLocal{Index: len(u.proc.Locals), Flags: varTemporary})
+	return len(u.proc.Locals) - 1
+}
+
+// beginRange add exception range beginning at next instruction
+func (u *compileUnit) beginRange(t ExceptionRangeType) (int, *asmRange) {
+	r := &asmRange{t: t, level: u.depth, start: u.label(), breakL: -1, continueL: -1, catchL: -1}
+	u.ranges = append(u.ranges, r)
+	if u.depth++; u.depth > u.maxDepth {
+		u.maxDepth = u.depth
+	}
+	return len(u.ranges) - 1, r
+}
+
+func (u *compileUnit) endRange(r *asmRange) {
+	r.end = u.label()
+	u.depth--
+}
+
+// compileScript compile commands of src, value of the last command is left on stack
+func (u *compileUnit) compileScript(src string) error {
+	cmds, err := parseScript(src)
+	if err != nil {
+		return err
+	}
+	if len(cmds) == 0 {
+		u.push("")
+		return nil
+	}
+	for index, cmd := range cmds {
+		if index > 0 {
+			u.emit("pop")
+		}
+		if err = u.compileCommand(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *compileUnit) compileCommand(cmd *tclCommand) (err error) {
+	loc := len(u.cmdLocs)
+	u.cmdLocs = append(u.cmdLocs, [2]int{u.label(), -1})
+	defer func() { u.cmdLocs[loc][1] = u.label() }()
+
+	words := cmd.words
+	name, _ := words[0].literal()
+	ok := false
+	switch name {
+	case "set":
+		ok, err = u.compileSet(words)
+	case "incr":
+		ok, err = u.compileIncr(words)
+	case "append":
+		ok, err = u.compileAppend(words)
+	case "expr":
+		ok, err = u.compileExprCmd(words)
+	case "if":
+		ok, err = u.compileIf(words)
+	case "while":
+		ok, err = u.compileWhile(words)
+	case "for":
+		ok, err = u.compileFor(words)
+	case "foreach":
+		ok, err = u.compileForeach(words)
+	case "catch":
+		ok, err = u.compileCatch(words)
+	case "break", "continue":
+		if ok = len(words) == 1; ok {
+			u.emit(name)
+		}
+	case "proc":
+		ok, err = u.compileProc(words)
+	}
+	if err != nil || ok {
+		return
+	}
+	//invoke command
+	for _, w := range words {
+		if err = u.compileWord(w); err != nil {
+			return
+		}
+	}
+	u.emitIndex("invokeStk", len(words))
+	return nil
+}
+
+// compileWord push value of w
+func (u *compileUnit) compileWord(w *tclWord) error {
+	if s, ok := w.literal(); ok {
+		u.push(s)
+		return nil
+	}
+	n := 0
+	for _, part := range w.parts {
+		switch part.kind {
+		case textPart:
+			u.push(part.text)
+		case varPart:
+			if err := u.loadVar(part.text, part.index); err != nil {
+				return err
+			}
+		case scriptPart:
+			if err := u.compileScript(part.text); err != nil {
+				return err
+			}
+		}
+		if n++; n == maxOperand1 {
+			u.concat(n)
+			n = 1
+		}
+	}
+	if n > 1 {
+		u.concat(n)
+	}
+	return nil
+}
+
+// compiledVar is variable named by word
+type compiledVar struct {
+	name  string
+	index *tclWord //index of array element, nil for scalar
+	word  *tclWord //name word, if name is not known at compile time
+	local int      //index of compiled local, -1 if not any
+}
+
+// compiledVarOf return variable named by w, e.g. a, a(b), a($i)
+func (u *compileUnit) compiledVarOf(w *tclWord) (ref compiledVar) {
+	ref.local = -1
+	first, last := w.parts[0], w.parts[len(w.parts)-1]
+	if s, ok := w.literal(); ok {
+		ref.name = s
+		if open := strings.IndexByte(s, '('); open > 0 && strings.HasSuffix(s, ")") {
+			ref.name, ref.index = s[:open], &tclWord{}
+			ref.index.addText(s[open+1 : len(s)-1])
+		}
+	} else if open := strings.IndexByte(first.text, '('); first.kind == textPart && open > 0 &&
+		last.kind == textPart && strings.HasSuffix(last.text, ")") && len(w.parts) > 1 {
+		//a($i)
+		ref.name, ref.index = first.text[:open], &tclWord{}
+		if s := first.text[open+1:]; s != "" {
+			ref.index.addText(s)
+		}
+		ref.index.parts = append(ref.index.parts, w.parts[1:len(w.parts)-1]...)
+		if s := strings.TrimSuffix(last.text, ")"); s != "" {
+			ref.index.addText(s)
+		}
+	} else {
+		ref.word = w
+		return
+	}
+	ref.local = u.local(ref.name)
+	return
+}
+
+// pushName push name of ref, for instructions of Stk form
+func (u *compileUnit) pushName(ref compiledVar) error {
+	if ref.word != nil {
+		return u.compileWord(ref.word)
+	}
+	u.push(ref.name)
+	return nil
+}
+
+// access emit instruction of ref, value is compiled by value after name/index
+func (u *compileUnit) access(ref compiledVar, scalar, array, stk string, value func() error) (err error) {
+	if ref.local < 0 || ref.word != nil {
+		if err = u.pushName(ref); err != nil {
+			return
+		}
+	}
+	if ref.index != nil && ref.word == nil {
+		if err = u.compileWord(ref.index); err != nil {
+			return
+		}
+	}
+	if value != nil {
+		if err = value(); err != nil {
+			return
+		}
+	}
+	switch {
+	case ref.word != nil:
+		u.emit(stk)
+	case ref.index == nil && ref.local >= 0:
+		u.emitIndex(scalar, ref.local)
+	case ref.index == nil:
+		u.emit(scalar + "Stk")
+	case ref.local >= 0:
+		u.emitIndex(array, ref.local)
+	default:
+		u.emit(array + "Stk")
+	}
+	return
+}
+
+func (u *compileUnit) loadVar(name string, index *tclWord) error {
+	if index == nil {
+		//${a(b)} is element of array
+		w := &tclWord{}
+		w.addText(name)
+		return u.access(u.compiledVarOf(w), "loadScalar", "loadArray", "loadStk", nil)
+	}
+	ref := compiledVar{name: name, index: index, local: u.local(name)}
+	return u.access(ref, "loadScalar", "loadArray", "loadStk", nil)
+}
+
+// compileSet compile set varName ?value?
+func (u *compileUnit) compileSet(words []*tclWord) (bool, error) {
+	if len(words) != 2 && len(words) != 3 {
+		return false, nil
+	}
+	ref := u.compiledVarOf(words[1])
+	if len(words) == 2 {
+		return true, u.access(ref, "loadScalar", "loadArray", "loadStk", nil)
+	}
+	return true, u.access(ref, "storeScalar", "storeArray", "storeStk", func() error {
+		return u.compileWord(words[2])
+	})
+}
+
+// compileIncr compile incr varName ?increment?
+func (u *compileUnit) compileIncr(words []*tclWord) (bool, error) {
+	if len(words) != 2 && len(words) != 3 {
+		return false, nil
+	}
+	ref := u.compiledVarOf(words[1])
+	if ref.local > maxOperand1 {
+		//there are not incr instructions of 4-byte local index
+		ref.local = -1
+	}
+	imm, isImm := int64(1), true
+	if len(words) == 3 {
+		s, ok := words[2].literal()
+		var err error
+		imm, err = strconv.ParseInt(s, 10, 8)
+		isImm = ok && err == nil
+	}
+	if !isImm {
+		return true, u.access(ref, "incrScalar", "incrArray", "incrStk", func() error {
+			return u.compileWord(words[2])
+		})
+	}
+	if err := u.access(ref, "incrScalar", "incrArray", "incrStk", nil); err != nil {
+		return true, err
+	}
+	//Imm form, with increment as operand
+	last := &u.code[len(u.code)-1]
+	last.name += "Imm"
+	last.operands = append(last.operands, int(imm))
+	return true, nil
+}
+
+// compileAppend compile append varName value, if append instructions are supported
+func (u *compileUnit) compileAppend(words []*tclWord) (bool, error) {
+	if len(words) != 3 || !u.has("appendStk") {
+		return false, nil
+	}
+	ref := u.compiledVarOf(words[1])
+	if ref.index == nil && ref.local < 0 && ref.word == nil {
+		//appendStk for scalar
+		ref.word = words[1]
+	}
+	return true, u.access(ref, "appendScalar", "appendArray", "appendStk", func() error {
+		return u.compileWord(words[2])
+	})
+}
+
+// byteCode assemble instructions into ByteCode, numSrcBytes is length of source
+func (u *compileUnit) byteCode(numSrcBytes int) (bc *ByteCode, err error) {
+	//1. pc of instructions, jumps are 1-byte form until the offset does not fit
+	pcs := make([]int, len(u.code)+1)
+	for changed := true; changed; {
+		for index, ins := range u.code {
+			pcs[index+1] = pcs[index] + u.size(ins)
+		}
+		changed = false
+		for index := range u.code {
+			ins := &u.code[index]
+			if ins.target < 0 || ins.jump4 {
+				continue
+			}
+			if offset := pcs[u.labels[ins.target]] - pcs[index]; offset < -128 || offset > 127 {
+				ins.jump4, changed = true, true
+			}
+		}
+	}
+	pcOf := func(label int) int {
+		if label < 0 {
+			return -1
+		}
+		return pcs[u.labels[label]]
+	}
+
+	//2. code
+	bc = &ByteCode{Literals: u.literals, AuxData: u.auxData}
+	for index, ins := range u.code {
+		name, operands := ins.name, ins.operands
+		if ins.target >= 0 {
+			name, operands = name+"1", []int{pcOf(ins.target) - pcs[index]}
+			if ins.jump4 {
+				name = ins.name + "4"
+			}
+		}
+		op, ok := u.opIndex[name]
+		if !ok {
+			return nil, fmt.Errorf("instruction %s is not in opcode table", name)
+		}
+		bc.Code = append(bc.Code, byte(op))
+		desc := &u.opTable[op]
+		for i := 0; i < desc.numOperands; i++ {
+			bc.Code = appendOperand(bc.Code, desc.opTypes[i], operands[i])
+		}
+	}
+
+	//3. command locations and exception ranges
+	var locs []CommandLocation
+	for _, loc := range u.cmdLocs {
+		locs = append(locs, CommandLocation{CodeOffset: pcOf(loc[0]), CodeLength: pcOf(loc[1]) - pcOf(loc[0])})
+	}
+	bc.SetCommandLocations(locs)
+	for _, r := range u.ranges {
+		bc.ExceptionRanges = append(bc.ExceptionRanges, &ExceptionRange{Type: r.t, NestingLevel: r.level,
+			CodeOffset: pcOf(r.start), NumCodeBytes: pcOf(r.end) - pcOf(r.start),
+			BreakOffset: pcOf(r.breakL), ContinueOffset: pcOf(r.continueL), CatchOffset: pcOf(r.catchL)})
+	}
+
+	//4. structure info
+	bc.Info = StructInfo{NumCommands: len(locs), NumSrcBytes: numSrcBytes, NumCodeBytes: len(bc.Code),
+		NumLitObjects: len(bc.Literals), NumExceptRanges: len(bc.ExceptionRanges), NumAuxDataItems: len(bc.AuxData),
+		NumCmdLocBytes: len(bc.CodeDelta) + len(bc.CodeLength), MaxExceptDepth: u.maxDepth}
+	var locals []*CompiledLocal
+	if u.proc != nil {
+		locals = u.proc.Locals
+	}
+	g, err := newCFG(u.opTable, bc, locals)
+	if err != nil {
+		return nil, err
+	}
+	if bc.Info.MaxStackDepth, err = g.StackDepth(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// size return number of bytes of ins
+func (u *compileUnit) size(ins asmInstruction) int {
+	switch {
+	case ins.target >= 0 && ins.jump4:
+		return 5
+	case ins.target >= 0:
+		return 2
+	}
+	if op, ok := u.opIndex[ins.name]; ok {
+		return u.opTable[op].numBytes
+	}
+	return 1
+}
+
+// appendOperand append operand i of type t into code
+func appendOperand(code []byte, t byte, i int) []byte {
+	switch t {
+	case OPERAND_INT1, OPERAND_UINT1, OPERAND_LVT1, OPERAND_OFFSET1, OPERAND_LIT1, OPERAND_SCLS1:
+		return append(code, byte(i))
+	}
+	return binary.BigEndian.AppendUint32(code, uint32(i))
+}
+
+// compileFragment compile script, or expression if isExpr,
+// which is evaluated by evalStk or exprStk in frame of caller
+func compileFragment(opTable []InstructionDesc, src string, isExpr bool) (bc *ByteCode, err error) {
+	u := newCompileUnit(opTable, nil)
+	if isExpr {
+		w := &tclWord{braced: true}
+		w.addText(src)
+		e, ok := u.prepareExpr([]*tclWord{w})
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid expression %q", ErrScriptSyntax, src)
+		}
+		err = u.compileExpr(e)
+	} else {
+		err = u.compileScript(src)
+	}
+	if err != nil {
+		return
+	}
+	u.emit("done")
+	return u.byteCode(len(src))
+}
+
+// Compile compile Tcl script into File, by Compiler of Tcl 8.0
+func Compile(script string) (*File, error) {
+	return NewCompiler().Compile(script)
+}
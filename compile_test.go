@@ -0,0 +1,133 @@
+package tbcload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testCompile compile script, write and read it back
+func testCompile(t *testing.T, c *Compiler, script string) *File {
+	f, err := c.Compile(script)
+	if err != nil {
+		t.Fatalf("%q: %s", script, err)
+	}
+	var buf bytes.Buffer
+	if err = NewWriter(&buf).WriteFile(f); err != nil {
+		t.Fatalf("%q: %s", script, err)
+	}
+	if f, err = ReadFile(&buf); err != nil {
+		t.Fatalf("%q: %s", script, err)
+	}
+	if err = f.VerifyStack(""); err != nil {
+		t.Errorf("%q: %s", script, err)
+	}
+	return f
+}
+
+func TestCompileFixture(t *testing.T) {
+	expected := readTestFile(t, "testdata/hello.tbc")
+	f := testCompile(t, NewCompiler(), "proc hello name {\n    puts \"Hello $name\"\n}\nhello world\n")
+	if !bytes.Equal(f.ByteCode.Code, expected.ByteCode.Code) {
+		t.Errorf("expected code %v, got %v", expected.ByteCode.Code, f.ByteCode.Code)
+	}
+	proc, expectedProc := f.ByteCode.Literals[3].Proc, expected.ByteCode.Literals[3].Proc
+	if proc == nil || !bytes.Equal(proc.ByteCode.Code, expectedProc.ByteCode.Code) {
+		t.Errorf("expected procedure code %v, got %v", expectedProc.ByteCode.Code, proc)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, v := range []struct {
+		version string
+		script  string
+		source  string //decompiled source, script if ""
+	}{
+		{"", "set i 0\nwhile {$i < 3} {\n    incr i\n}\ncatch {foo} msg\n", ""},
+		{"", "set a(x) 1\nputs $a(x)\n", ""},
+		{"", "if {$a > 1 && [f]} {\n    puts yes\n} else {\n    puts no\n}\n", ""},
+		{"", "for {set i 0} {$i < 10} {incr i 2} {\n    puts [expr {$i * 2 + 1}]\n}\n", ""},
+		{"", "puts [expr {$a ? abs($b) : -$c}]\n", ""},
+		{"", "foo \"a $b [c] d\"\n", ""},
+		{"", "while 1 {\n    break\n}\n", "while {1} {\n    break\n}\n"},
+		{"", "proc f {a {b 1} args} {\n    foreach x $args {\n        incr a $x\n    }\n    return $a\n}\n", ""},
+		{"", "proc g x {\n    if {[catch {h $x} msg]} {\n        return $msg\n    }\n}\n", ""},
+		{"8.4", "append s x\n", ""},
+		{"", "append s x\n", ""},
+		{"", "while $a {incr i}\n", "while $a {incr i}\n"},
+	} {
+		c := &Compiler{TclVersion: v.version}
+		f := testCompile(t, c, v.script)
+		source := v.source
+		if source == "" {
+			source = v.script
+		}
+		if s := testDecompile(t, f); s != source {
+			t.Errorf("%q: expected:\n%s\ngot:\n%s", v.script, source, s)
+		}
+	}
+}
+
+func TestCompileLarge(t *testing.T) {
+	//jumps over long body use 4 bytes operand
+	var sb strings.Builder
+	sb.WriteString("if {$a} {\n")
+	for i := 0; i < 100; i++ {
+		sb.WriteString("    puts hello\n")
+	}
+	sb.WriteString("}\n")
+	f := testCompile(t, NewCompiler(), sb.String())
+	if s := testDecompile(t, f); s != sb.String() {
+		t.Errorf("expected:\n%s\ngot:\n%s", sb.String(), s)
+	}
+	//more than 256 literals use push4
+	sb.Reset()
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&sb, "puts word%d\n", i)
+	}
+	f = testCompile(t, NewCompiler(), sb.String())
+	if s := testDecompile(t, f); s != sb.String() {
+		t.Errorf("expected:\n%s\ngot:\n%s", sb.String(), s)
+	}
+	if !bytes.Contains(f.ByteCode.Code, []byte{2, 0, 0, 1, 0}) {
+		t.Errorf("expected push4 of literal 256")
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, script := range []string{"puts {a", "set a [b", "puts \"a", "puts {a}}", "puts \"a\"b",
+		"proc f {a} {puts \"x}", "foreach x {a b} {puts $x", "if {$a} {\n    puts [b\n}"} {
+		if _, err := Compile(script); !errors.Is(err, ErrScriptSyntax) {
+			t.Errorf("%q: expected ErrScriptSyntax, got %v", script, err)
+		}
+	}
+	if _, err := (&Compiler{TclVersion: "7.6"}).Compile("puts a"); err == nil {
+		t.Errorf("expected error of Tcl 7.6")
+	}
+}
+
+func TestCompileInvalidExpr(t *testing.T) {
+	for _, src := range []string{"1 +", "(1 + 2", "1 2", "$", "1 ? 2", "\"a", "abs(1", "-"} {
+		if _, err := parseExpr(src, func(string) bool { return true }); !errors.Is(err, ErrScriptSyntax) {
+			t.Errorf("%q: expected ErrScriptSyntax, got %v", src, err)
+		}
+	}
+	//invalid expression is invoked as command, error is raised when it is run
+	for _, script := range []string{"set a [expr {1 +}]\n", "expr {(1 + 2}\n", "while {$i <} {incr i}\n"} {
+		f := testCompile(t, NewCompiler(), script)
+		code, err := disassemble(tcl80OpTable, f.ByteCode.Code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ins := range code {
+			if ins.Name == "exprStk" || isExprInstruction(&ins) {
+				t.Errorf("%q: unexpected %s", script, ins.Name)
+			}
+		}
+		if s := testDecompile(t, f); s != script {
+			t.Errorf("%q: expected:\n%s\ngot:\n%s", script, script, s)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package tbcload
+
+import (
+	"strings"
+)
+
+// compiledExpr is expression of words, parsed if it is known at compile time
+type compiledExpr struct {
+	words []*tclWord
+	node  *exprNode //nil if expression has substitutions, evaluated by exprStk
+}
+
+// prepareExpr parse expression of words, return false if it is invalid
+func (u *compileUnit) prepareExpr(words []*tclWord) (e compiledExpr, ok bool) {
+	e.words = words
+	var texts []string
+	for _, w := range words {
+		s, literal := w.literal()
+		if !literal {
+			return e, true
+		}
+		texts = append(texts, s)
+	}
+	node, err := parseExpr(strings.Join(texts, " "), u.has)
+	if err != nil {
+		return e, false
+	}
+	e.node = node
+	return e, u.checkExpr(node)
+}
+
+// checkExpr return true if functions of n can be compiled
+func (u *compileUnit) checkExpr(n *exprNode) bool {
+	if n.op == "()" {
+		for _, f := range builtinFuncs {
+			if f.name == n.fn && (f.numArgs != len(n.args) || !u.has("callBuiltinFunc1")) {
+				return false
+			}
+		}
+	}
+	for _, arg := range n.args {
+		if !u.checkExpr(arg) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileExpr push value of e
+func (u *compileUnit) compileExpr(e compiledExpr) error {
+	if e.node == nil {
+		for index, w := range e.words {
+			if index > 0 {
+				u.push(" ")
+			}
+			if err := u.compileWord(w); err != nil {
+				return err
+			}
+		}
+		if n := 2*len(e.words) - 1; n > 1 {
+			u.concat(n)
+		}
+		u.emit("exprStk")
+		return nil
+	}
+	if err := u.compileExprNode(e.node); err != nil {
+		return err
+	}
+	if e.node.op == "" {
+		//value of single operand is converted to number
+		u.emit("tryCvtToNumeric")
+	}
+	return nil
+}
+
+func (u *compileUnit) compileExprNode(n *exprNode) error {
+	switch n.op {
+	case "":
+		return u.compileWord(n.word)
+	case "&&", "||":
+		//a; jumpFalse short; b; jumpFalse short; push 1; jump end; short: push 0; end:
+		jump, value, short := "jumpFalse", "1", "0"
+		if n.op == "||" {
+			jump, value, short = "jumpTrue", "0", "1"
+		}
+		shortL, end := u.newLabel(), u.newLabel()
+		for _, arg := range n.args {
+			if err := u.compileExprNode(arg); err != nil {
+				return err
+			}
+			u.emitJump(jump, shortL)
+		}
+		u.push(value)
+		u.emitJump("jump", end)
+		u.setLabel(shortL)
+		u.push(short)
+		u.setLabel(end)
+		return nil
+	case "?:":
+		//c; jumpFalse f; t; jump end; f: f; end:
+		f, end := u.newLabel(), u.newLabel()
+		if err := u.compileExprNode(n.args[0]); err != nil {
+			return err
+		}
+		u.emitJump("jumpFalse", f)
+		if err := u.compileExprNode(n.args[1]); err != nil {
+			return err
+		}
+		u.emitJump("jump", end)
+		u.setLabel(f)
+		if err := u.compileExprNode(n.args[2]); err != nil {
+			return err
+		}
+		u.setLabel(end)
+		return nil
+	case "()":
+		builtin := -1
+		for index, f := range builtinFuncs {
+			if f.name == n.fn {
+				builtin = index
+			}
+		}
+		if builtin < 0 {
+			u.push(n.fn)
+		}
+		for _, arg := range n.args {
+			if err := u.compileExprNode(arg); err != nil {
+				return err
+			}
+		}
+		if builtin < 0 {
+			u.emit("callFunc1", len(n.args)+1)
+		} else {
+			u.emit("callBuiltinFunc1", builtin)
+		}
+		return nil
+	}
+	for _, arg := range n.args {
+		if err := u.compileExprNode(arg); err != nil {
+			return err
+		}
+	}
+	if len(n.args) == 1 {
+		for name, symbol := range unaryOperators {
+			if symbol == n.op {
+				u.emit(name)
+			}
+		}
+		return nil
+	}
+	u.emit(exprOperators[n.op])
+	return nil
+}
+
+// compileExprCmd compile expr arg ?arg ...?
+func (u *compileUnit) compileExprCmd(words []*tclWord) (bool, error) {
+	if len(words) < 2 {
+		return false, nil
+	}
+	e, ok := u.prepareExpr(words[1:])
+	if !ok {
+		return false, nil
+	}
+	return true, u.compileExpr(e)
+}
+
+// compileBody compile script of literal word w
+func (u *compileUnit) compileBody(w *tclWord) error {
+	s, _ := w.literal()
+	return u.compileScript(s)
+}
+
+// literalWords return true if all words are literal
+func literalWords(words ...*tclWord) bool {
+	for _, w := range words {
+		if _, ok := w.literal(); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isWord return true if w is literal s
+func isWord(w *tclWord, s string) bool {
+	text, ok := w.literal()
+	return ok && text == s
+}
+
+// compileIf compile if expr1 ?then? body1 elseif expr2 ?then? body2 ... ?else? ?bodyN?
+//
+//	test; jumpFalse next; body; jump end; next: ... {else body|push ""}; end:
+func (u *compileUnit) compileIf(words []*tclWord) (bool, error) {
+	type clause struct {
+		cond compiledExpr
+		body *tclWord
+	}
+	var clauses []clause
+	var elseBody *tclWord
+	index := 1
+	for {
+		if index >= len(words) {
+			return false, nil
+		}
+		cond, ok := u.prepareExpr(words[index : index+1])
+		if !ok {
+			return false, nil
+		}
+		if index++; index < len(words) && isWord(words[index], "then") {
+			index++
+		}
+		if index >= len(words) || !literalWords(words[index]) {
+			return false, nil
+		}
+		clauses = append(clauses, clause{cond, words[index]})
+		if index++; index == len(words) {
+			break
+		}
+		if isWord(words[index], "elseif") {
+			index++
+			continue
+		}
+		if isWord(words[index], "else") {
+			index++
+		}
+		if index != len(words)-1 || !literalWords(words[index]) {
+			return false, nil
+		}
+		elseBody = words[index]
+		break
+	}
+	end := u.newLabel()
+	for _, c := range clauses {
+		next := u.newLabel()
+		if err := u.compileExpr(c.cond); err != nil {
+			return true, err
+		}
+		u.emitJump("jumpFalse", next)
+		if err := u.compileBody(c.body); err != nil {
+			return true, err
+		}
+		u.emitJump("jump", end)
+		u.setLabel(next)
+	}
+	if elseBody != nil {
+		if err := u.compileBody(elseBody); err != nil {
+			return true, err
+		}
+	} else {
+		u.push("")
+	}
+	u.setLabel(end)
+	return true, nil
+}
+
+// compileLoop compile loop of Tcl 8.0, code of start is compiled before
+//
+//	test: test; jumpFalse end; body; pop; continue: [next; pop]; jump test; end: push ""
+func (u *compileUnit) compileLoop(cond compiledExpr, body, next *tclWord) error {
+	test := u.label()
+	if err := u.compileExpr(cond); err != nil {
+		return err
+	}
+	end := u.newLabel()
+	u.emitJump("jumpFalse", end)
+	_, r := u.beginRange(LoopExceptionRange)
+	if err := u.compileBody(body); err != nil {
+		return err
+	}
+	u.emit("pop")
+	u.endRange(r)
+	r.continueL, r.breakL = test, end
+	if next != nil {
+		r.continueL = u.label()
+		if err := u.compileBody(next); err != nil {
+			return err
+		}
+		u.emit("pop")
+	}
+	u.emitJump("jump", test)
+	u.setLabel(end)
+	u.push("")
+	return nil
+}
+
+// compileWhile compile while test body, test must be literal to be evaluated again
+func (u *compileUnit) compileWhile(words []*tclWord) (bool, error) {
+	if len(words) != 3 || !literalWords(words...) {
+		return false, nil
+	}
+	cond, ok := u.prepareExpr(words[1:2])
+	if !ok {
+		return false, nil
+	}
+	return true, u.compileLoop(cond, words[2], nil)
+}
+
+// compileFor compile for start test next body
+func (u *compileUnit) compileFor(words []*tclWord) (bool, error) {
+	if len(words) != 5 || !literalWords(words...) {
+		return false, nil
+	}
+	cond, ok := u.prepareExpr(words[2:3])
+	if !ok {
+		return false, nil
+	}
+	if err := u.compileBody(words[1]); err != nil {
+		return true, err
+	}
+	u.emit("pop")
+	return true, u.compileLoop(cond, words[4], words[3])
+}
+
+// isScalarName return true if name is scalar, which can be compiled local
+func isScalarName(name string) bool {
+	return name != "" && !strings.Contains(name, "::") && !(strings.Contains(name, "(") && strings.HasSuffix(name, ")"))
+}
+
+// compileForeach compile foreach varList list ?varList list ...? body, in procedure only
+//
+//	lists stored in temps; foreach_start4; step: foreach_step4; jumpFalse end; body; pop; jump step; end: push ""
+func (u *compileUnit) compileForeach(words []*tclWord) (bool, error) {
+	if u.proc == nil || len(words) < 4 || len(words)%2 != 0 || !literalWords(words[len(words)-1]) {
+		return false, nil
+	}
+	var varLists [][]string
+	for index := 1; index < len(words)-1; index += 2 {
+		s, ok := words[index].literal()
+		if !ok {
+			return false, nil
+		}
+		names, err := splitList(s)
+		if err != nil || len(names) == 0 {
+			return false, nil
+		}
+		for _, name := range names {
+			if !isScalarName(name) {
+				return false, nil
+			}
+		}
+		varLists = append(varLists, names)
+	}
+	info := &ForeachInfo{}
+	for _, names := range varLists {
+		var indexes []int
+		for _, name := range names {
+			indexes = append(indexes, u.local(name))
+		}
+		info.VarLists = append(info.VarLists, indexes)
+	}
+	info.FirstValueTemp = u.temp()
+	for range varLists[1:] {
+		u.temp()
+	}
+	info.LoopCtTemp = u.temp()
+	for i := range varLists {
+		if err := u.compileWord(words[2+2*i]); err != nil {
+			return true, err
+		}
+		u.emitIndex("storeScalar", info.FirstValueTemp+i)
+		u.emit("pop")
+	}
+	aux := len(u.auxData)
+	u.auxData = append(u.auxData, &AuxData{Type: ForeachAuxData, Foreach: info})
+	u.emit("foreach_start4", aux)
+	step := u.label()
+	u.emit("foreach_step4", aux)
+	end := u.newLabel()
+	u.emitJump("jumpFalse", end)
+	_, r := u.beginRange(LoopExceptionRange)
+	if err := u.compileBody(words[len(words)-1]); err != nil {
+		return true, err
+	}
+	u.emit("pop")
+	u.endRange(r)
+	r.continueL, r.breakL = step, end
+	u.emitJump("jump", step)
+	u.setLabel(end)
+	u.push("")
+	return true, nil
+}
+
+// compileCatch compile catch script ?varName?
+//
+//	[push var]; beginCatch4; body; [store var]; pop; push 0; jump end;
+//	catch: [pushResult; store var; pop]; pushReturnCode; end: endCatch
+func (u *compileUnit) compileCatch(words []*tclWord) (bool, error) {
+	if len(words) != 2 && len(words) != 3 || !literalWords(words...) {
+		return false, nil
+	}
+	var ref *compiledVar
+	if len(words) == 3 {
+		name, _ := words[2].literal()
+		if !isScalarName(name) {
+			return false, nil
+		}
+		ref = &compiledVar{name: name, local: u.local(name)}
+	}
+	store := func() {
+		if ref.local >= 0 {
+			u.emitIndex("storeScalar", ref.local)
+		} else {
+			u.emit("storeScalarStk")
+		}
+	}
+	if ref != nil && ref.local < 0 {
+		u.push(ref.name)
+	}
+	index, r := u.beginRange(CatchExceptionRange)
+	u.emit("beginCatch4", index)
+	u.setLabel(r.start)
+	if err := u.compileBody(words[1]); err != nil {
+		return true, err
+	}
+	u.endRange(r)
+	if ref != nil {
+		store()
+	}
+	u.emit("pop")
+	u.push("0")
+	end := u.newLabel()
+	u.emitJump("jump", end)
+	r.catchL = u.label()
+	if ref != nil {
+		u.emit("pushResult")
+		store()
+		u.emit("pop")
+	}
+	u.emit("pushReturnCode")
+	u.setLabel(end)
+	u.emit("endCatch")
+	return true, nil
+}
+
+// compileProc compile proc name args body, body is compiled into procedure literal
+func (u *compileUnit) compileProc(words []*tclWord) (bool, error) {
+	if len(words) != 4 || !literalWords(words[2:]...) {
+		return false, nil
+	}
+	argList, _ := words[2].literal()
+	body, _ := words[3].literal()
+	proc, err := newProcedure(u.opTable, argList, body)
+	if proc == nil {
+		return err != nil, err
+	}
+	u.push("proc")
+	if err = u.compileWord(words[1]); err != nil {
+		return true, err
+	}
+	u.push(argList)
+	u.literals = append(u.literals, &Literal{Type: LiteralProc, Proc: proc})
+	u.emitIndex("push", len(u.literals)-1)
+	u.emitIndex("invokeStk", 4)
+	return true, nil
+}
+
+// newProcedure compile procedure of argList and body,
+// return nil if argList is not compiled as locals
+func newProcedure(opTable []InstructionDesc, argList, body string) (*Procedure, error) {
+	args, err := splitList(argList)
+	if err != nil {
+		return nil, nil
+	}
+	proc := &Procedure{NumArgs: len(args)}
+	for index, arg := range args {
+		fields, err := splitList(arg)
+		if err != nil || len(fields) == 0 || len(fields) > 2 || !isScalarName(fields[0]) {
+			return nil, nil
+		}
+		local := &CompiledLocal{Name: fields[0], Index: index, Flags: varArgument}
+		if len(fields) == 2 {
+			local.Default = &Literal{Type: literalType(fields[1]), Value: fields[1]}
+		}
+		proc.Locals = append(proc.Locals, local)
+	}
+	pu := newCompileUnit(opTable, proc)
+	if err = pu.compileScript(body); err != nil {
+		return nil, err
+	}
+	pu.emit("done")
+	if proc.ByteCode, err = pu.byteCode(len(body)); err != nil {
+		return nil, err
+	}
+	return proc, nil
+}
@@ -0,0 +1,563 @@
+package tbcload
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Decompiler reconstruct Tcl source from File, by simulating
+// the stack of bytecode instructions
+type Decompiler struct {
+	w          bufio.Writer
+	TclVersion string //e.g. "8.3", select opcode table by it instead of header
+
+	header  Header //header of file being decompiled
+	opTable []InstructionDesc
+}
+
+// NewDecompiler create Decompiler
+func NewDecompiler(w io.Writer) *Decompiler {
+	return &Decompiler{w: *bufio.NewWriter(w)}
+}
+
+// Decompile write Tcl source of f
+func (d *Decompiler) Decompile(f *File) (err error) {
+	var lines []string
+	for _, b := range f.Blocks() {
+		if d.header = b.Header; d.header == (Header{}) {
+			d.header = defaultHeader
+		}
+		p := Parser{header: d.header, TclVersion: d.TclVersion}
+		if d.opTable, err = p.selectOpTable(); err != nil {
+			return
+		}
+		if b.Command == BlockProc {
+			//procedure of bcproc is written as proc command
+			args := b.Args
+			if args == "" {
+				args = b.Proc.ArgList()
+			}
+			lines = []string{"proc " + quoteWord(b.Name) + " " + quoteWord(args) + " " + d.procBody(b.Proc)}
+		} else if lines, err = d.decompileByteCode(b.ByteCode, nil); err != nil {
+			return
+		}
+		for _, line := range lines {
+			d.w.WriteString(line)
+			d.w.WriteByte('\n')
+		}
+	}
+	return d.w.Flush()
+}
+
+// decompileByteCode return commands of bc, one item for each command,
+// which may be multi-lines
+func (d *Decompiler) decompileByteCode(bc *ByteCode, locals []*CompiledLocal) ([]string, error) {
+	code, err := disassemble(d.opTable, bc.Code)
+	if err != nil {
+		return nil, err
+	}
+	fr := &frame{d: d, bc: bc, locals: locals, code: code, index: map[int]int{}, temps: map[int]*value{}}
+	for index, ins := range code {
+		fr.index[ins.PC] = index
+	}
+	fr.run(0, len(code))
+	return fr.lines, nil
+}
+
+// valueKind is kind of value on the simulated stack
+type valueKind int
+
+const (
+	literalValue valueKind = iota //text is the literal
+	varValue                      //text is variable reference, e.g. "$a" or "$a(b)"
+	commandValue                  //text is command, e.g. "set a 1"
+	concatValue                   //parts are concatenated
+	exprValue                     //op applied to parts, e.g. "+"
+	unknownValue                  //pushed by unsupported instruction
+)
+
+// value is one item on the simulated stack
+type value struct {
+	kind  valueKind
+	text  string
+	name  string     //variable name of scalar varValue
+	parts []*value   //concatValue
+	proc  *Procedure //literalValue of procedure body
+	ifc   *ifCommand //commandValue of if
+	op    string     //operator of exprValue
+	words []*value   //words of commandValue invoked by invokeStk
+	alt   *value     //exprValue of if, when used as value, e.g. "a ? b : c"
+}
+
+// frame is the state of decompiling one ByteCode
+type frame struct {
+	d      *Decompiler
+	bc     *ByteCode
+	locals []*CompiledLocal
+	code   []Instruction
+	index  map[int]int //index of instruction in code by pc
+	stack  []*value
+	lines  []string
+	pcs    []int //pc of instruction emitting each line
+	pc     int   //pc of instruction being decompiled
+
+	loops []*ExceptionRange //enclosing loops, for break/continue
+	temps map[int]*value    //values stored in unnamed temp locals
+
+	shortPC    int    //pc of short circuit value of && ||
+	shortValue *value //nil if not in && ||
+}
+
+// run simulate instructions code[from:to]
+func (fr *frame) run(from, to int) {
+	for index := from; index < to; {
+		fr.pc = fr.code[index].PC
+		if next, ok := fr.control(index, to); ok {
+			index = next
+			continue
+		}
+		fr.step(&fr.code[index])
+		index++
+	}
+}
+
+func (fr *frame) addLine(s string) {
+	fr.lines = append(fr.lines, s)
+	fr.pcs = append(fr.pcs, fr.pc)
+}
+
+// takeLines remove and return lines emitted from pc
+func (fr *frame) takeLines(pc int) (lines []string) {
+	index := len(fr.pcs)
+	for index > 0 && fr.pcs[index-1] >= pc {
+		index--
+	}
+	lines = append(lines, fr.lines[index:]...)
+	fr.lines, fr.pcs = fr.lines[:index], fr.pcs[:index]
+	return
+}
+
+func (fr *frame) push(v *value) {
+	fr.stack = append(fr.stack, v)
+}
+
+// pop return top of stack, or unknown value if stack is empty
+func (fr *frame) pop() *value {
+	if len(fr.stack) == 0 {
+		return &value{kind: unknownValue}
+	}
+	v := fr.stack[len(fr.stack)-1]
+	fr.stack = fr.stack[:len(fr.stack)-1]
+	return v
+}
+
+// popN return top n items of stack, in order pushed. n is operand of
+// untrusted bytecode, values missing from stack are one unknown value
+func (fr *frame) popN(n int) []*value {
+	if n > len(fr.stack)+1 {
+		n = len(fr.stack) + 1
+	}
+	if n < 0 {
+		n = 0
+	}
+	res := make([]*value, n)
+	for index := n - 1; index >= 0; index-- {
+		res[index] = fr.pop()
+	}
+	return res
+}
+
+// pushCommand push result of command made of words
+func (fr *frame) pushCommand(words ...string) {
+	fr.push(&value{kind: commandValue, text: strings.Join(words, " ")})
+}
+
+// emit add v as a command, values which are not commands are dropped
+func (fr *frame) emit(v *value) {
+	switch v.kind {
+	case commandValue:
+		fr.addLine(v.text)
+	case exprValue:
+		fr.addLine("expr {" + fr.exprText(v) + "}")
+	case varValue:
+		//[set a] is compiled into loadScalar
+		fr.addLine("set " + v.text[1:])
+	}
+}
+
+// unsupported add instruction as comment, and keep stack balanced
+func (fr *frame) unsupported(ins *Instruction) {
+	fr.addLine(fmt.Sprintf("# (%d)%s", ins.PC, ins))
+	effect := ins.desc.stackEffect
+	if effect == INT_MIN {
+		effect = 0
+	}
+	for ; effect < 0; effect++ {
+		fr.pop()
+	}
+	for ; effect > 0; effect-- {
+		fr.push(&value{kind: unknownValue})
+	}
+}
+
+func (fr *frame) literal(index int) *value {
+	if index >= len(fr.bc.Literals) {
+		return &value{kind: unknownValue}
+	}
+	lit := fr.bc.Literals[index]
+	return &value{kind: literalValue, text: lit.Value, proc: lit.Proc}
+}
+
+// localName return name of compiled local, "%index" for unnamed temp
+func (fr *frame) localName(index int) string {
+	if local := findLocal(fr.locals, index); local != nil && local.Name != "" {
+		return local.Name
+	}
+	return fmt.Sprintf("%%%d", index)
+}
+
+func (fr *frame) step(ins *Instruction) {
+	var op int
+	if len(ins.Operands) > 0 {
+		op = ins.Operands[0]
+	}
+	switch ins.Name {
+	case "done":
+		if len(fr.stack) > 0 {
+			v := fr.pop()
+			if v.kind == literalValue && v.text != "" && v.proc == nil {
+				fr.addLine("return " + fr.word(v))
+			} else {
+				fr.emit(v)
+			}
+		}
+	case "push1", "push4":
+		fr.push(fr.literal(op))
+	case "pop":
+		fr.emit(fr.pop())
+	case "dup":
+		v := fr.pop()
+		fr.push(v)
+		fr.push(v)
+	case "concat1", "strcat":
+		fr.push(&value{kind: concatValue, parts: fr.popN(op)})
+	case "invokeStk1", "invokeStk4":
+		words := fr.popN(op)
+		fr.pushCommand(fr.words(words)...)
+		fr.stack[len(fr.stack)-1].words = words
+	case "evalStk":
+		fr.pushCommand("eval", fr.word(fr.pop()))
+	case "exprStk":
+		fr.pushCommand("expr", fr.word(fr.pop()))
+
+	case "loadScalar1", "loadScalar4":
+		if v := fr.temps[op]; v != nil {
+			fr.push(v)
+		} else {
+			fr.push(varRef(fr.localName(op)))
+		}
+	case "loadScalarStk", "loadStk":
+		fr.push(fr.varRefStk(fr.pop()))
+	case "loadArray1", "loadArray4":
+		fr.push(fr.arrayRef(fr.localName(op), fr.pop()))
+	case "loadArrayStk":
+		elem := fr.pop()
+		fr.push(fr.arrayRef(fr.nameOf(fr.pop()), elem))
+
+	case "storeScalar1", "storeScalar4":
+		if local := findLocal(fr.locals, op); local != nil && local.Name == "" {
+			//temp of compiled command, e.g. value list of foreach
+			fr.temps[op] = fr.pop()
+			fr.push(&value{kind: literalValue})
+			break
+		}
+		fr.pushCommand("set", quoteWord(fr.localName(op)), fr.word(fr.pop()))
+	case "storeScalarStk", "storeStk":
+		v := fr.pop()
+		fr.pushCommand("set", fr.word(fr.pop()), fr.word(v))
+	case "storeArray1", "storeArray4":
+		v := fr.pop()
+		fr.pushCommand("set", fr.elemName(fr.localName(op), fr.pop()), fr.word(v))
+	case "storeArrayStk":
+		v, elem := fr.pop(), fr.pop()
+		fr.pushCommand("set", fr.elemName(fr.nameOf(fr.pop()), elem), fr.word(v))
+
+	case "incrScalar1":
+		fr.pushCommand("incr", quoteWord(fr.localName(op)), fr.word(fr.pop()))
+	case "incrScalarStk", "incrStk":
+		v := fr.pop()
+		fr.pushCommand("incr", fr.word(fr.pop()), fr.word(v))
+	case "incrArray1":
+		v := fr.pop()
+		fr.pushCommand("incr", fr.elemName(fr.localName(op), fr.pop()), fr.word(v))
+	case "incrArrayStk":
+		v, elem := fr.pop(), fr.pop()
+		fr.pushCommand("incr", fr.elemName(fr.nameOf(fr.pop()), elem), fr.word(v))
+	case "incrScalar1Imm":
+		fr.pushCommand(incrWords(quoteWord(fr.localName(op)), ins.Operands[1])...)
+	case "incrScalarStkImm", "incrStkImm":
+		fr.pushCommand(incrWords(fr.word(fr.pop()), op)...)
+	case "incrArray1Imm":
+		fr.pushCommand(incrWords(fr.elemName(fr.localName(op), fr.pop()), ins.Operands[1])...)
+	case "incrArrayStkImm":
+		elem := fr.pop()
+		fr.pushCommand(incrWords(fr.elemName(fr.nameOf(fr.pop()), elem), op)...)
+
+	case "appendScalar1", "appendScalar4", "lappendScalar1", "lappendScalar4":
+		fr.pushCommand(commandOf(ins.Name), quoteWord(fr.localName(op)), fr.word(fr.pop()))
+	case "appendStk", "lappendStk":
+		v := fr.pop()
+		fr.pushCommand(commandOf(ins.Name), fr.word(fr.pop()), fr.word(v))
+	case "appendArray1", "appendArray4", "lappendArray1", "lappendArray4":
+		v := fr.pop()
+		fr.pushCommand(commandOf(ins.Name), fr.elemName(fr.localName(op), fr.pop()), fr.word(v))
+	case "appendArrayStk", "lappendArrayStk":
+		v, elem := fr.pop(), fr.pop()
+		fr.pushCommand(commandOf(ins.Name), fr.elemName(fr.nameOf(fr.pop()), elem), fr.word(v))
+
+	case "list":
+		fr.pushCommand(append([]string{"list"}, fr.words(fr.popN(op))...)...)
+	case "listindex", "listIndex":
+		fr.pushCommand(append([]string{"lindex"}, fr.words(fr.popN(2))...)...)
+	case "lindexMulti":
+		fr.pushCommand(append([]string{"lindex"}, fr.words(fr.popN(op))...)...)
+	case "listlength", "listLength":
+		fr.pushCommand("llength", fr.word(fr.pop()))
+	case "strlen":
+		fr.pushCommand("string", "length", fr.word(fr.pop()))
+	case "strindex":
+		fr.pushCommand(append([]string{"string", "index"}, fr.words(fr.popN(2))...)...)
+	case "strcmp":
+		fr.pushCommand(append([]string{"string", "compare"}, fr.words(fr.popN(2))...)...)
+	default:
+		if !fr.exprStep(ins) {
+			fr.unsupported(ins)
+		}
+	}
+}
+
+// commandOf return command name of append/lappend instructions
+func commandOf(name string) string {
+	if strings.HasPrefix(name, "lappend") {
+		return "lappend"
+	}
+	return "append"
+}
+
+func incrWords(name string, amount int) []string {
+	if amount == 1 {
+		return []string{"incr", name}
+	}
+	return []string{"incr", name, fmt.Sprint(amount)}
+}
+
+// nameOf return variable name pushed on stack, "" if it is not literal
+func (fr *frame) nameOf(v *value) string {
+	if v.kind == literalValue {
+		return v.text
+	}
+	return ""
+}
+
+// varRefStk return reference of variable whose name is on stack
+func (fr *frame) varRefStk(name *value) *value {
+	if name.kind == literalValue {
+		return varRef(name.text)
+	}
+	return &value{kind: commandValue, text: "set " + fr.word(name)}
+}
+
+// elemName return word of array element, e.g. "a(b)"
+func (fr *frame) elemName(array string, elem *value) string {
+	if elem.kind == literalValue {
+		return quoteWord(array + "(" + elem.text + ")")
+	}
+	s := escapeString(array) + "(" + fr.inner(elem) + ")"
+	if strings.ContainsAny(s, " \t;{}") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// arrayRef return reference of array element, e.g. "$a($b)"
+func (fr *frame) arrayRef(array string, elem *value) *value {
+	index := fr.inner(elem)
+	if simpleVarName.MatchString(array) && !strings.ContainsAny(index, " \t\n;)") {
+		return &value{kind: varValue, text: "$" + array + "(" + index + ")"}
+	}
+	return &value{kind: commandValue, text: "set " + fr.elemName(array, elem)}
+}
+
+var simpleVarName = regexp.MustCompile(`^(::)?[A-Za-z0-9_]+(::[A-Za-z0-9_]+)*$`)
+
+// varRef return reference of scalar variable, e.g. "$a" or "${a b}"
+func varRef(name string) *value {
+	switch {
+	case simpleVarName.MatchString(name):
+		return &value{kind: varValue, text: "$" + name, name: name}
+	case name != "" && !strings.ContainsAny(name, "{}\\"):
+		return &value{kind: varValue, text: "${" + name + "}", name: name}
+	}
+	return &value{kind: commandValue, text: "set " + quoteWord(name)}
+}
+
+func (fr *frame) words(values []*value) (res []string) {
+	for _, v := range values {
+		res = append(res, fr.word(v))
+	}
+	return
+}
+
+// word return v as one word of command
+func (fr *frame) word(v *value) string {
+	switch v.kind {
+	case literalValue:
+		if v.proc != nil {
+			return fr.procBody(v.proc)
+		}
+		return quoteWord(v.text)
+	case varValue:
+		return v.text
+	case commandValue:
+		if v.alt != nil {
+			return fr.word(v.alt)
+		}
+		return "[" + v.text + "]"
+	case exprValue:
+		return "[expr {" + fr.exprText(v) + "}]"
+	case concatValue:
+		s := fr.inner(v)
+		if s == "" || strings.ContainsAny(s, " \t;{}") || s[0] == '#' {
+			return `"` + s + `"`
+		}
+		return s
+	}
+	return "???"
+}
+
+// inner return v as part of a word in double quotes
+func (fr *frame) inner(v *value) string {
+	switch v.kind {
+	case literalValue:
+		return escapeString(v.text)
+	case concatValue:
+		var b strings.Builder
+		for index, part := range v.parts {
+			s := fr.inner(part)
+			//$a followed by name chars must be written as ${a}
+			if part.kind == varValue && part.name != "" && index+1 < len(v.parts) {
+				if next := fr.inner(v.parts[index+1]); next != "" && isVarNameChar(next[0]) {
+					s = "${" + part.name + "}"
+				}
+			}
+			b.WriteString(s)
+		}
+		return b.String()
+	}
+	return fr.word(v)
+}
+
+func isVarNameChar(c byte) bool {
+	return c == '_' || c == ':' || c == '(' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// procBody return body of procedure in braces
+func (fr *frame) procBody(proc *Procedure) string {
+	return fr.d.procBody(proc)
+}
+
+func (d *Decompiler) procBody(proc *Procedure) string {
+	lines, err := d.decompileByteCode(proc.ByteCode, proc.Locals)
+	if err != nil {
+		lines = []string{"# " + err.Error()}
+	}
+	if len(lines) == 0 {
+		return "{}"
+	}
+	return "{\n" + indentLines(lines) + "}"
+}
+
+// indentLines join lines, each is indented by 4 spaces
+func indentLines(lines []string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		for _, s := range strings.Split(line, "\n") {
+			b.WriteString("    ")
+			b.WriteString(s)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// quoteWord return s as one word of Tcl command,
+// in braces if possible, or with backslashes
+func quoteWord(s string) string {
+	if s == "" {
+		return "{}"
+	}
+	if !strings.ContainsAny(s, " \t\n\r;\"$[]{}\\") && s[0] != '#' {
+		return s
+	}
+	if !strings.ContainsAny(s, "\n\r\\") && bracesBalanced(s) {
+		return "{" + s + "}"
+	}
+	var b strings.Builder
+	for index := 0; index < len(s); index++ {
+		switch c := s[index]; c {
+		case ' ', ';', '"', '$', '[', ']', '{', '}', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			writeControl(&b, c)
+		}
+	}
+	return b.String()
+}
+
+// escapeString return s escaped for inside of double quotes
+func escapeString(s string) string {
+	var b strings.Builder
+	for index := 0; index < len(s); index++ {
+		switch c := s[index]; c {
+		case '"', '$', '[', ']', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			writeControl(&b, c)
+		}
+	}
+	return b.String()
+}
+
+// writeControl write c, newline and tab are written as \n \r \t
+func writeControl(b *strings.Builder, c byte) {
+	switch c {
+	case '\n':
+		b.WriteString(`\n`)
+	case '\r':
+		b.WriteString(`\r`)
+	case '\t':
+		b.WriteString(`\t`)
+	default:
+		b.WriteByte(c)
+	}
+}
+
+func bracesBalanced(s string) bool {
+	depth := 0
+	for index := 0; index < len(s); index++ {
+		switch s[index] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
@@ -0,0 +1,131 @@
+package tbcload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQuoteWord(t *testing.T) {
+	for _, v := range []struct {
+		s, word string
+	}{
+		{"", "{}"},
+		{"hello", "hello"},
+		{"hello world", "{hello world}"},
+		{"$a [b]", "{$a [b]}"},
+		{"#x", "{#x}"},
+		{"a}b", `a\}b`},
+		{"a\nb c", `a\nb\ c`},
+	} {
+		if word := quoteWord(v.s); word != v.word {
+			t.Errorf("%q: expected %s, got %s", v.s, v.word, word)
+		}
+	}
+}
+
+func testDecompile(t *testing.T, f *File) string {
+	var out bytes.Buffer
+	if err := NewDecompiler(&out).Decompile(f); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestDecompile(t *testing.T) {
+	for _, v := range []struct {
+		fileName string
+		source   string
+	}{
+		{"testdata/hello.tbc", "proc hello name {\n    puts \"Hello $name\"\n}\nhello world\n"},
+		{"testdata/catch.tbc", "set i 0\nwhile {$i < 3} {\n    incr i\n}\ncatch {foo} msg\n"},
+		{"testdata/blocks.tbc", "set greeting Hello\nproc greet name {\n    global greeting\n    puts \"$greeting $name\"\n}\ngreet world\n"},
+	} {
+		fs, err := os.Open(v.fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ReadFile(fs)
+		fs.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := testDecompile(t, f); s != v.source {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", v.fileName, v.source, s)
+		}
+	}
+}
+
+// assemble return code of instructions, e.g. "push1 0", by opTable
+func assemble(opTable []InstructionDesc, lines ...string) (code []byte) {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		op := -1
+		for index, desc := range opTable {
+			if desc.name == fields[0] {
+				op = index
+			}
+		}
+		if op < 0 || len(fields)-1 != opTable[op].numOperands {
+			panic(line)
+		}
+		code = append(code, byte(op))
+		for index, t := range opTable[op].opTypes[:opTable[op].numOperands] {
+			i, err := strconv.Atoi(fields[index+1])
+			if err != nil {
+				panic(line)
+			}
+			if operandSize(t) == 1 {
+				code = append(code, byte(i))
+			} else {
+				code = binary.BigEndian.AppendUint32(code, uint32(i))
+			}
+		}
+	}
+	return
+}
+
+func stringLiterals(values ...string) (lits []*Literal) {
+	for _, s := range values {
+		lits = append(lits, &Literal{Type: LiteralString, Value: s})
+	}
+	return
+}
+
+var header84 = Header{FormatMajor: 2, CompilerVersion: "1.0", TclVersion: "8.4"}
+
+func TestDecompileVariables(t *testing.T) {
+	code := assemble(tcl84OpTable,
+		"push1 0", "push1 1", "storeScalarStk", "pop", //set a {x y}
+		"push1 2", "push1 3", "push1 0", "loadScalarStk", "storeArrayStk", "pop", //set b(k) $a
+		"push1 0", "push1 2", "push1 4", "loadScalarStk", "loadArrayStk", "push1 5", "concat1 2", "appendStk", "pop",
+		"push1 4", "incrScalarStkImm -1",
+		"done")
+	f := &File{Block: Block{Header: header84, ByteCode: &ByteCode{Code: code, Literals: stringLiterals("a", "x y", "b", "k", "i", "z")}}}
+	expected := "set a {x y}\nset b(k) $a\nappend a $b($i)z\nincr i -1\n"
+	if s := testDecompile(t, f); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestDecompileOperandRange(t *testing.T) {
+	//tampered operands of invokeStk4, list and concat1 are larger than stack
+	code := assemble(tcl84OpTable,
+		"push1 0", "push1 1", "invokeStk4 2147483647", "pop",
+		"push1 1", "list 4294967295", "pop",
+		"push1 1", "concat1 255", "done")
+	f := &File{Block: Block{Header: header84, ByteCode: &ByteCode{Code: code, Literals: stringLiterals("puts", "a")}}}
+	expected := "??? puts a\nlist ??? a\n"
+	if s := testDecompile(t, f); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+	//notes of --detail dump simulate the same instructions
+	ins, err := disassemble(tcl84OpTable, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprNotes(tcl84OpTable, f.ByteCode, nil, ins)
+}
@@ -0,0 +1,160 @@
+package tbcload
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Instruction is one decoded instruction of ByteCode
+type Instruction struct {
+	PC       int //offset in code
+	Opcode   byte
+	Name     string
+	Size     int //number of bytes, including opcode
+	Operands []int
+
+	desc *InstructionDesc
+}
+
+// Disassemble decode code into instructions,
+// by opcode table of tclVersion, e.g. "8.3"
+func Disassemble(code []byte, tclVersion string) ([]Instruction, error) {
+	v := parseTclVersion(tclVersion)
+	if v < minTclVersion || v > maxTclVersion {
+		return nil, fmt.Errorf("%w: Tcl %s", ErrUnsupportedVersion, tclVersion)
+	}
+	return disassemble(opTableFor(v), code)
+}
+
+func disassemble(opTable []InstructionDesc, code []byte) (res []Instruction, err error) {
+	var ins Instruction
+	for pc := 0; pc < len(code); pc += ins.Size {
+		if ins, err = decodeInstruction(opTable, code, pc); err != nil {
+			return
+		}
+		res = append(res, ins)
+	}
+	return
+}
+
+func decodeInstruction(opTable []InstructionDesc, code []byte, pc int) (ins Instruction, err error) {
+	src := code[pc:]
+	opInt := int(src[0])
+	if opInt >= len(opTable) {
+		return ins, fmt.Errorf("pc %d: opcode %d: %w", pc, opInt, os.ErrNotExist)
+	}
+	desc := &opTable[opInt]
+	if len(src) < desc.numBytes {
+		return ins, fmt.Errorf("pc %d: %s: %w", pc, desc.name, io.ErrUnexpectedEOF)
+	}
+	ins = Instruction{PC: pc, Opcode: src[0], Name: desc.name, Size: desc.numBytes, desc: desc}
+	src = src[1:]
+	for _, t := range desc.opTypes[:desc.numOperands] {
+		ins.Operands = append(ins.Operands, decodeOperand(src, t))
+		src = src[operandSize(t):]
+	}
+	return
+}
+
+func operandSize(operandType byte) int {
+	switch operandType {
+	case OPERAND_NONE:
+		return 0
+	case OPERAND_INT1, OPERAND_UINT1, OPERAND_LVT1, OPERAND_OFFSET1, OPERAND_LIT1, OPERAND_SCLS1:
+		return 1
+	}
+	return 4
+}
+
+func decodeOperand(src []byte, operandType byte) int {
+	switch operandType {
+	case OPERAND_NONE:
+		return 0
+	case OPERAND_INT1, OPERAND_OFFSET1:
+		/* One byte signed integer. */
+		return int(int8(src[0]))
+	case OPERAND_UINT1, OPERAND_LVT1, OPERAND_LIT1, OPERAND_SCLS1:
+		/* One byte unsigned integer. */
+		return int(src[0])
+	case OPERAND_INT4, OPERAND_IDX4, OPERAND_OFFSET4:
+		/* Four byte signed integer. */
+		return int(int32(binary.BigEndian.Uint32(src)))
+	}
+	/* Four byte unsigned integer. */
+	return int(binary.BigEndian.Uint32(src))
+}
+
+// String return as "push1 0"
+func (ins Instruction) String() string {
+	var b strings.Builder
+	b.WriteString(ins.Name)
+	for _, i := range ins.Operands {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Itoa(i))
+	}
+	return b.String()
+}
+
+// operandTypes return types of ins.Operands
+func (ins *Instruction) operandTypes() []byte {
+	return ins.desc.opTypes[:ins.desc.numOperands]
+}
+
+// maxLiteralChars is the max chars of literal in annotation
+const maxLiteralChars = 40
+
+// annotate return comment of instruction as tcl::unsupported::disassemble,
+// literal text, local variable name, jump target, exception range and aux data
+func annotate(ins *Instruction, bc *ByteCode, locals []*CompiledLocal) string {
+	var notes []string
+	if ins.Name == "beginCatch4" && ins.Operands[0] < len(bc.ExceptionRanges) {
+		notes = append(notes, fmt.Sprintf("range %d", ins.Operands[0]))
+	}
+	for index, t := range ins.operandTypes() {
+		v := ins.Operands[index]
+		switch t {
+		case OPERAND_LIT1, OPERAND_LIT4:
+			if v < len(bc.Literals) {
+				notes = append(notes, quoteLiteral(bc.Literals[v]))
+			}
+		case OPERAND_LVT1, OPERAND_LVT4:
+			if local := findLocal(locals, v); local != nil && local.Name != "" {
+				notes = append(notes, fmt.Sprintf("var %q", local.Name))
+			} else if local != nil {
+				notes = append(notes, fmt.Sprintf("temp %%%d", v))
+			}
+		case OPERAND_OFFSET1, OPERAND_OFFSET4:
+			notes = append(notes, fmt.Sprintf("pc %d", ins.PC+v))
+		case OPERAND_AUX4:
+			if v < len(bc.AuxData) {
+				notes = append(notes, bc.AuxData[v].summary(ins.PC, locals))
+			}
+		}
+	}
+	return strings.Join(notes, ", ")
+}
+
+func quoteLiteral(lit *Literal) string {
+	if lit.Type == LiteralProc {
+		return "proc body"
+	}
+	s := lit.Value
+	if len(s) > maxLiteralChars {
+		s = s[:maxLiteralChars] + "..."
+	}
+	return strconv.Quote(s)
+}
+
+// findLocal return compiled local of frame index
+func findLocal(locals []*CompiledLocal, index int) *CompiledLocal {
+	for _, local := range locals {
+		if local.Index == index {
+			return local
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package tbcload
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	//push1 0; loadScalar1 0; jump1 -4; jump4 -6; done
+	code := []byte{1, 0, 10, 0, 34, 0xfc, 35, 0xff, 0xff, 0xff, 0xfa, 0}
+	instructions, err := Disassemble(code, "8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instructions) != 5 {
+		t.Fatalf("expected 5 instructions, got %d", len(instructions))
+	}
+	if s := instructions[3].String(); s != "jump4 -6" || instructions[3].PC != 6 {
+		t.Errorf("wrong instruction 3: %s at %d", s, instructions[3].PC)
+	}
+
+	bc := &ByteCode{Code: code, Literals: []*Literal{{Type: LiteralString, Value: "x"}}}
+	locals := []*CompiledLocal{{Name: "name", Index: 0}}
+	for index, expected := range []string{`"x"`, `var "name"`, "pc 0", "pc 0", ""} {
+		if note := annotate(&instructions[index], bc, locals); note != expected {
+			t.Errorf("instruction %d: expected annotation %s, got %s", index, expected, note)
+		}
+	}
+}
+
+func TestDisassembleError(t *testing.T) {
+	if _, err := Disassemble([]byte{0}, "7.6"); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	//push4 without operand
+	if _, err := Disassemble([]byte{2, 0}, "8.0"); err == nil {
+		t.Errorf("expected error for truncated instruction")
+	}
+}
@@ -3,9 +3,11 @@ package tbcload
 import (
 	"bufio"
 	"bytes"
-	"encoding/ascii85"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 /*
@@ -18,124 +20,276 @@ import (
 // The encoding handles 4-byte chunks, using a special encoding
 // for the last fragment, so Encode is not appropriate for use on
 // individual blocks of a large data stream. Use NewEncoder() instead.
-func Encode(dst, src []byte) int {
-	if len(src) == 0 {
-		return 0
+func Encode(dst, src []byte) (ndst int) {
+	for len(src) > 0 {
+		n := len(src)
+		if n > 4 {
+			n = 4
+		}
+		ndst += encodeGroup(dst[ndst:], src[:n])
+		src = src[n:]
 	}
-	//step 1 align to 4 bytes,padding as 0
-	srcCopy := []byte(src)
-	srcCopy = align4Bytes(srcCopy, 0)
+	return
+}
 
-	//step 2 Big-Endian to Little-Endian
-	exchangeEvery4(srcCopy)
+// MaxEncodedLen return max length of encoding n source bytes
+func MaxEncodedLen(n int) int {
+	return (n + 3) / 4 * 5
+}
 
-	//step 3 ascii85 encode
-	encodeLen := ascii85.Encode(dst, srcCopy)
+// encodeGroup encode 4-byte group src into 5 chars of dst, or 'z' if it is
+// 0x00000000, fragment of 1-3 bytes is encoded into len(src)+1 chars
+func encodeGroup(dst, src []byte) int {
+	var group [4]byte
+	copy(group[:], src)
+	//Big-Endian to Little-Endian, padding zeros are the high bytes
+	v := binary.LittleEndian.Uint32(group[:])
+	if v == 0 && len(src) == len(group) {
+		dst[0] = 'z'
+		return 1
+	}
+	//ascii85 digits, reversed as least significant first, the dropped
+	//high digits of fragment are always 0
+	for index := 0; index <= len(src); index++ {
+		dst[index] = encodeMap[v%85]
+		v /= 85
+	}
+	return len(src) + 1
+}
+
+// Encoder wrap Encode for stream writer, the encoded chars are
+// wrapped every maxCharsOneLine chars as TclPro does
+type Encoder struct {
+	w       io.Writer
+	buf     [4]byte //bytes of 4-byte group not yet encoded
+	nbuf    int
+	line    []byte //encoded chars of current line
+	newLine string
+	err     error
+}
 
-	//step 4 reverse string every 5 bytes
-	exchangeEvery5(dst[:encodeLen])
+// NewEncoder return Encoder which wrap Encode for stream writer,
+// Close must be called to flush the last fragment
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, newLine: "\n", line: make([]byte, 0, maxCharsOneLine)}
+}
 
-	//step 5 map special char
-	for index := 0; index < encodeLen; index++ {
-		//there is 'z'(122) special for 0x00000000
-		if n := int(dst[index] - '!'); n < len(encodeMap) {
-			dst[index] = encodeMap[n]
+// Write encode p every 4-byte group
+func (e *Encoder) Write(p []byte) (n int, err error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	for ; n < len(p) && e.err == nil; n++ {
+		e.buf[e.nbuf] = p[n]
+		if e.nbuf++; e.nbuf == len(e.buf) {
+			e.flushGroup()
 		}
 	}
-	//if 0x00 00 00 00->'z', we cannot known how many '!' drop
-	return encodeLen
-	//return encodeLen - (len(srcCopy) - len(src))
+	return n, e.err
+}
+
+// Close encode the last fragment and end the line, it does not close wrapped writer
+func (e *Encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.nbuf > 0 {
+		e.flushGroup()
+	}
+	e.writeLine()
+	return e.err
+}
+
+// flushGroup encode bytes of e.buf
+func (e *Encoder) flushGroup() {
+	var dst [5]byte
+	for _, c := range dst[:encodeGroup(dst[:], e.buf[:e.nbuf])] {
+		e.emit(c)
+	}
+	e.nbuf = 0
+}
+
+func (e *Encoder) emit(c byte) {
+	e.line = append(e.line, c)
+	if len(e.line) == maxCharsOneLine {
+		e.writeLine()
+	}
+}
+
+func (e *Encoder) writeLine() {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, string(e.line)+e.newLine)
+	}
+	e.line = e.line[:0]
 }
 
 /*
  * Decoder
  */
 
-// Decode encodes src into at most len(src)
-// bytes of dst, returning the actual number of bytes written.
+// Decode decodes src into dst, returning the actual number of bytes written.
+// dst of MaxDecodedLen(len(src)) bytes is always enough, Decode panics if
+// dst is too short for decoded bytes.
 //
-// The encoding handles 5-byte chunks, using a special encoding
-// for the last fragment, so Encode is not appropriate for use on
-// individual blocks of a large data stream. Use NewEncoder() instead.
+// 'z' stands for 4 zero bytes and can only be at start of a 5-char group,
+// the last group of 2-4 chars is a fragment of 1-3 bytes. White space is
+// skipped, decoding stops at illegal char. src is not modified.
 func Decode(dst, src []byte) (ndst int) {
-	ndst = 0
-	//step 0 map special char
-	srcCopy := []byte(src)
-	for index := 0; index < len(src); index++ {
-		srcCopy[index] = decodeMap[src[index]] + '!'
+	var err error
+	if ndst, err = decode(dst, src); errors.Is(err, io.ErrShortBuffer) {
+		panic("tbcload.Decode: " + err.Error())
 	}
+	return
+}
 
-	//step 1 align to 5 bytes,padding as 0 (but not for z)
-	//no need do this
-	//BUGBUG, if there is 'z' in middle
-	srcCopy = align5Bytes(srcCopy, '!')
-
-	//step 2 Big-Endian to Little-Endian(but not for 'z')
-	exchangeEvery5(srcCopy)
-
-	//step 3 ascii85 decode
-	ndst, _, _ = ascii85.Decode(dst, srcCopy, true)
+// MaxDecodedLen return max length of decoding n encoded chars,
+// which are all 'z' of 4 zero bytes
+func MaxDecodedLen(n int) int {
+	return n * 4
+}
 
-	//step 4 reverse string every 4 bytes
-	exchangeEvery4(dst[:ndst])
+// ErrDecodeChar means there is illegal char or misplaced 'z' in encoded text
+var ErrDecodeChar = errors.New("illegal char in encoded text")
 
-	//step 5 drop padding
-	if padding := len(srcCopy) - len(src); padding == 4 {
-		ndst = ndst - 2
-	} else {
-		ndst = ndst - padding
+// decode decodes src into dst, returning number of bytes written and error
+// of illegal char, or io.ErrShortBuffer if dst is too short
+func decode(dst, src []byte) (ndst int, err error) {
+	short := func(n int) bool {
+		if len(dst)-ndst < n {
+			err = fmt.Errorf("%w: %d bytes decoded into %d bytes", io.ErrShortBuffer, ndst+n, len(dst))
+			return true
+		}
+		return false
+	}
+	var digits [5]byte
+	n := 0
+	for index, c := range src {
+		d := a85IllegalChar
+		if int(c) < len(decodeMap) {
+			d = decodeMap[c]
+		}
+		switch {
+		case d == a85Whitespace:
+			continue
+		case d == a85Z && n == 0:
+			if short(4) {
+				return
+			}
+			ndst += copy(dst[ndst:], []byte{0, 0, 0, 0})
+			continue
+		case d == a85Z || d == a85IllegalChar:
+			return ndst, fmt.Errorf("%w: %q at %d", ErrDecodeChar, c, index)
+		}
+		digits[n] = d
+		if n++; n == len(digits) {
+			if short(4) {
+				return
+			}
+			ndst += decodeGroup(dst[ndst:], digits[:])
+			n = 0
+		}
+	}
+	switch n {
+	case 0:
+	case 1:
+		return ndst, fmt.Errorf("%w: fragment of 1 char", ErrDecodeChar)
+	default:
+		if short(n - 1) {
+			return
+		}
+		ndst += decodeGroup(dst[ndst:], digits[:n])
 	}
 	return
 }
 
+// decodeGroup decode 5 digits into 4 bytes, or fragment of n digits into n-1 bytes
+func decodeGroup(dst, digits []byte) int {
+	var v uint32
+	for index := len(digits) - 1; index >= 0; index-- {
+		v = v*85 + uint32(digits[index])
+	}
+	var group [4]byte
+	binary.LittleEndian.PutUint32(group[:], v)
+	return copy(dst, group[:len(digits)-1])
+}
+
+// DefaultMaxBytes is default limit of bytes decoded from one section of tbc file
+const DefaultMaxBytes = 64 << 20
+
+// ErrSectionTooLarge means section of tbc file is larger than limit of Decoder
+var ErrSectionTooLarge = errors.New("section is too large")
+
 // Decoder wrap decode for stream reader
 type Decoder struct {
-	wrapped io.Reader
-	srcbuf  [2048000]byte
-	dstbuf  [2048000]byte
-	src     []byte
-	dst     []byte
-	//we did not record error, is that ok?
+	wrapped  io.Reader
+	lines    *numCharsLineReader
+	dst      []byte
+	MaxBytes int //limit of bytes decoded from one section, DefaultMaxBytes if 0
 }
 
 const maxCharsOneLine = 72
 
 // NewDecoder return Decoder which wrap Decode for stream reader
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{wrapped: &eatLastNewLineReader{wrapped: newLineReader(r, maxCharsOneLine)}}
+	lines := newLineReader(r, maxCharsOneLine)
+	return &Decoder{wrapped: &eatLastNewLineReader{wrapped: lines}, lines: lines}
 }
 
 // ErrDecodeErr mean error while decoding from bytes
 var ErrDecodeErr = errors.New("error decoding from bytes")
 
+func (d *Decoder) maxBytes() int {
+	if d.MaxBytes > 0 {
+		return d.MaxBytes
+	}
+	return DefaultMaxBytes
+}
+
+// Read decode next section into p, the rest of section is returned by next Read.
+// Sections decoded to nothing are skipped
 func (d *Decoder) Read(p []byte) (nRead int, err error) {
-	var nsrc int
-	for {
-		//we ask more buffer
-		if len(d.dst) > len(p) {
-			return 0, io.ErrShortBuffer
-		}
-		//we have data, read from it
-		if len(d.dst) > 0 {
-			nRead = copy(p, d.dst)
-			d.dst = d.dst[nRead:]
-			return
+	for len(d.dst) == 0 {
+		var line string
+		if line, err = d.lines.readLine(MaxEncodedLen(d.maxBytes())); err != nil {
+			return 0, err
 		}
-		//we dont have data, so read it frist
-		nsrc, err = d.wrapped.Read(d.srcbuf[:])
-		//we read zero byte
-		if nsrc == 0 {
-			return nsrc, err
+		d.dst = make([]byte, maxDecodedLen(line))
+		var decodeErr error
+		if nRead, decodeErr = decode(d.dst, []byte(line)); decodeErr != nil {
+			d.dst = nil
+			return 0, fmt.Errorf("%w: %w", ErrDecodeErr, decodeErr)
 		}
+		d.dst = d.dst[:nRead]
+	}
+	nRead = copy(p, d.dst)
+	d.dst = d.dst[nRead:]
+	return
+}
 
-		d.src = d.srcbuf[:nsrc]
-		nRead = Decode(d.dstbuf[:], d.src)
-		d.dst = d.dstbuf[:nRead]
-		if nRead == 0 {
-			return 0, ErrDecodeErr
-		}
-		//we read&decode into d.dst ,so back to for-loop return
+// ReadSection read and decode next section, which is declared as n bytes
+func (d *Decoder) ReadSection(n int) ([]byte, error) {
+	if n < 0 || n > d.maxBytes() {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d bytes", ErrSectionTooLarge, n, d.maxBytes())
 	}
+	line, err := d.lines.readLine(MaxEncodedLen(n))
+	if err != nil && (err != io.EOF || n > 0) {
+		return nil, err
+	}
+	dst := make([]byte, n)
+	nRead, err := decode(dst, []byte(line))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecodeErr, err)
+	}
+	if nRead < n {
+		return nil, fmt.Errorf("expected %d bytes, but decoded %d bytes", n, nRead)
+	}
+	return dst, nil
+}
+
+// maxDecodedLen return max length of bytes decoded from encoded text src
+func maxDecodedLen(src string) int {
+	zeros := strings.Count(src, "z")
+	return zeros*4 + (len(src)-zeros+4)/5*4
 }
 
 // ReadRaw only read  from wrapped io without Decoding
@@ -144,6 +298,11 @@ func (d *Decoder) ReadRaw(p []byte) (nRead int, err error) {
 	return
 }
 
+// readRawLine read next line without decoding, joined with its continuation lines
+func (d *Decoder) readRawLine() (string, error) {
+	return d.lines.readLine(MaxEncodedLen(d.maxBytes()))
+}
+
 type eatLastNewLineReader struct {
 	wrapped io.Reader
 }
@@ -165,11 +324,13 @@ func (r *eatLastNewLineReader) Read(p []byte) (nRead int, err error) {
 type numCharsLineReader struct {
 	wrapped   bufio.Reader
 	numChars  int //number of each line
+	line      int //number of lines read
 	readError error
 	lastStr   string
+	record    *bytes.Buffer //if not nil, copy of raw lines read
 }
 
-func newLineReader(r io.Reader, numChars int) io.Reader {
+func newLineReader(r io.Reader, numChars int) *numCharsLineReader {
 	return &numCharsLineReader{wrapped: *bufio.NewReader(r), numChars: numChars}
 }
 func (r *numCharsLineReader) Read(p []byte) (nRead int, err error) {
@@ -195,105 +356,75 @@ func (r *numCharsLineReader) Read(p []byte) (nRead int, err error) {
 		}
 
 		//buffer string为空，需要从wrapped里面Read到buffer中
-		var b bytes.Buffer
-		var bRead = true
-
-		for bRead {
-			line, err = r.wrapped.ReadString('\n') //includes '\n'
-			nLen := len(line)
-			//if char[72]+"\r\n" || char[72] + "\n" ,则继续读下一行
-			if nLen == (r.numChars+2) && err == nil {
-				//bRead = true
-			} else if nLen == (r.numChars+1) && line[nLen-1] == '\n' && line[nLen-2] != '\r' && err == nil {
-				//bRead = true
-			} else {
-				bRead = false
-			}
-
-			//如果line之间的'\n'，则删除；保留String末尾的'\r' '\n'
-			if nLen >= r.numChars {
-				b.WriteString(line[:r.numChars])
-			} else if nLen > 0 {
-				b.WriteString(line)
-			}
-		}
-
-		//已经连续读完，如果读到空，直接返回
-		if b.Len() == 0 {
+		if line, err = r.readJoined(0); line == "" {
 			return 0, err
 		}
-
 		r.readError = err
-		r.lastStr = b.String()
+		r.lastStr = line
 		//保存到buffer中，返回for循环
 	}
 }
 
-func align4Bytes(src []byte, padding byte) []byte {
-	switch len(src) % 4 {
-	case 1:
-		src = append(src, padding)
-		fallthrough
-	case 2:
-		src = append(src, padding)
-		fallthrough
-	case 3:
-		src = append(src, padding)
-		fallthrough
-	case 0:
-		return src
-	}
-	return src
-}
+// readJoined read next line, and continue reading while size of line eq numChars.
+// It fails if limit > 0 and joined line is longer than limit chars and line end
+func (r *numCharsLineReader) readJoined(limit int) (string, error) {
+	var b bytes.Buffer
+	var bRead = true
+	var line string
+	var err error
 
-func align5Bytes(src []byte, padding byte) []byte {
-	//'z' dont need align to 5 bytes
-	numZ := bytes.Count(src, []byte{'z'})
-	nLen := len(src) - numZ
+	for bRead {
+		line, err = r.wrapped.ReadString('\n') //includes '\n'
+		if line != "" {
+			r.line++
+		}
+		if r.record != nil {
+			r.record.WriteString(line)
+		}
+		nLen := len(line)
+		//if char[72]+"\r\n" || char[72] + "\n" ,则继续读下一行
+		if nLen == (r.numChars+2) && err == nil {
+			//bRead = true
+		} else if nLen == (r.numChars+1) && line[nLen-1] == '\n' && line[nLen-2] != '\r' && err == nil {
+			//bRead = true
+		} else {
+			bRead = false
+		}
 
-	switch nLen % 5 {
-	case 1:
-		src = append(src, padding)
-		fallthrough
-	case 2:
-		src = append(src, padding)
-		fallthrough
-	case 3:
-		src = append(src, padding)
-		fallthrough
-	case 4:
-		src = append(src, padding)
-		fallthrough
-	case 0:
-		return src
+		//如果line之间的'\n'，则删除；保留String末尾的'\r' '\n'
+		if nLen >= r.numChars && bRead {
+			b.WriteString(line[:r.numChars])
+		} else if nLen > 0 {
+			b.WriteString(line)
+		}
+		if limit > 0 && b.Len() > limit+len("\r\n") {
+			return "", fmt.Errorf("%w: line is longer than %d chars", ErrSectionTooLarge, limit)
+		}
 	}
-	return src
+	return b.String(), err
 }
 
-func exchangeEvery4(src []byte) {
-	//BUG fixme,there is some len(src)%4!=0
-	// if len(src)%4 != 0 {
-	// 	return
-	// }
-
-	for len(src) >= 4 {
-		src[0], src[1], src[2], src[3] = src[3], src[2], src[1], src[0]
-		src = src[4:]
+// readLine return rest of current line, or next line joined with its
+// continuation lines, without line end. It fails if line is longer than limit chars
+func (r *numCharsLineReader) readLine(limit int) (line string, err error) {
+	if len(r.lastStr) > 0 {
+		line, err = r.lastStr, r.readError
+		r.lastStr, r.readError = "", nil
+	} else if line, err = r.readJoined(limit); line == "" {
+		return "", err
+	} else {
+		err = nil
 	}
-}
-func exchangeEvery5(src []byte) {
-	//special 'z' for 0x00000000, dont exchange 'z'
-	for len(src) > 0 {
-		if src[0] == 'z' {
-			src = src[1:]
-		} else if len(src) >= 5 {
-			src[0], src[1], src[3], src[4] = src[4], src[3], src[1], src[0]
-			src = src[5:]
-		} else {
-			return
-		}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if len(line) > limit {
+		return "", fmt.Errorf("%w: line of %d chars, limit is %d chars", ErrSectionTooLarge, len(line), limit)
 	}
-	return
+	return line, err
+}
+
+// unreadLine put line back, to be returned by next readLine
+func (r *numCharsLineReader) unreadLine(line string) {
+	r.lastStr = line + "\n"
 }
 
 var encodeMap = [...]byte{
@@ -511,8 +642,7 @@ var decodeMap = [...]byte{
 	3,              /* w (replaces $) */
 	58,             /* x (replaces [) */
 	59,             /* y (replaces \) */
-	89,             /* z->z,special for 0x00000000 */
-	//	a85Z,       /* z */
+	a85Z,           /* z, special for 0x00000000 */
 	a85IllegalChar, /* { */
 	60,             /* | (replaces ]) */
 	a85IllegalChar, /* } */
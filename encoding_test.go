@@ -3,9 +3,13 @@ package tbcload
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"strings"
 	"testing"
+	"testing/quick"
 )
 
 type testVector struct {
@@ -21,6 +25,9 @@ var testData = []testVector{
 	{"Hello TclPro", "RZ!iChROo@jZSfD"},
 	{"cbk_clicked", "y+aY?hafq@VY|+"},
 	{"tbcload::bcproc", "rpwhC;Z2b3<?<+EfqT+"},
+	{"\x00", "!!"},
+	{"\x00\x00\x00\x00", "z"},
+	{"\x0b\x00\x00\x00\x00", ",!!!!!!"},
 }
 
 func testEncode(t *testing.T, v []testVector) {
@@ -49,7 +56,151 @@ func TestEncodeDecode(t *testing.T) {
 	testDecode(t, testData)
 }
 
-func ExampleChainReader() {
+// zeroWords return random bytes, half of 4-byte words are zero
+func zeroWords(r *rand.Rand) []byte {
+	var src []byte
+	for n := r.Intn(40); n > 0; n-- {
+		word := make([]byte, 4)
+		if r.Intn(2) == 0 {
+			r.Read(word)
+		}
+		src = append(src, word...)
+	}
+	tail := make([]byte, r.Intn(4))
+	if r.Intn(2) == 0 {
+		r.Read(tail)
+	}
+	return append(src, tail...)
+}
+
+// encodedLen return length of encoding src, 'z' for each zero word
+func encodedLen(src []byte) (n int) {
+	for ; len(src) >= 4; src = src[4:] {
+		if bytes.Equal(src[:4], []byte{0, 0, 0, 0}) {
+			n++
+		} else {
+			n += 5
+		}
+	}
+	if len(src) > 0 {
+		n += len(src) + 1
+	}
+	return
+}
+
+func TestEncodeDecodeProperty(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	roundTrip := func(src []byte) bool {
+		enc := make([]byte, MaxEncodedLen(len(src)))
+		nenc := Encode(enc, src)
+		if nenc != encodedLen(src) {
+			t.Logf("%x: encoded length %d, expected %d", src, nenc, encodedLen(src))
+			return false
+		}
+		encoded := append([]byte(nil), enc[:nenc]...)
+		dst := make([]byte, len(src)+4)
+		ndst := Decode(dst, enc[:nenc])
+		if !bytes.Equal(enc[:nenc], encoded) {
+			t.Logf("%x: Decode modified src", src)
+			return false
+		}
+		return bytes.Equal(dst[:ndst], src)
+	}
+	for i := 0; i < 2000; i++ {
+		if src := zeroWords(r); !roundTrip(src) {
+			t.Fatalf("round trip failed: %x", src)
+		}
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeZ(t *testing.T) {
+	for _, v := range []struct {
+		encoded string
+		src     string
+		err     bool
+	}{
+		{"z,!!!!z", "\x00\x00\x00\x00\x0b\x00\x00\x00\x00\x00\x00\x00", false},
+		{"zz!!", "\x00\x00\x00\x00\x00\x00\x00\x00\x00", false},
+		{",CH z\nr@", "", true},
+		{",CHr@ \n", "proc", false},
+		{"!", "", true},
+		{",CHr\x80", "", true},
+	} {
+		dst := make([]byte, 64)
+		n, err := decode(dst, []byte(v.encoded))
+		if v.err {
+			if !errors.Is(err, ErrDecodeChar) {
+				t.Errorf("%q: expected ErrDecodeChar, got %v", v.encoded, err)
+			}
+			continue
+		}
+		if err != nil || string(dst[:n]) != v.src {
+			t.Errorf("%q: expected %q, got %q, %v", v.encoded, v.src, dst[:n], err)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	for n := 0; n < 300; n += 7 {
+		src := make([]byte, n)
+		for index := range src {
+			//runs of zero bytes are encoded as 'z'
+			if index%12 < 8 {
+				src[index] = byte(index * 31)
+			}
+		}
+		dst := make([]byte, 2*n+8)
+		expected := string(dst[:Encode(dst, src)])
+
+		//write byte by byte
+		var out bytes.Buffer
+		enc := NewEncoder(&out)
+		for index := range src {
+			if _, err := enc.Write(src[index : index+1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(out.String(), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			t.Errorf("%d bytes: expected newline at end, got %q", n, last)
+		}
+		lines = lines[:len(lines)-1]
+		for index, line := range lines[:len(lines)-1] {
+			if len(line) != maxCharsOneLine {
+				t.Errorf("%d bytes: line %d has %d chars", n, index, len(line))
+			}
+		}
+		if s := strings.Join(lines, ""); s != expected {
+			t.Errorf("%d bytes: expected %s, got %s", n, expected, s)
+		}
+	}
+}
+
+func TestEncoderLineEnd(t *testing.T) {
+	//57 bytes are encoded into 72 chars, a line of 72 chars is followed
+	//by another line, so the last line is empty
+	var out bytes.Buffer
+	enc := NewEncoder(&out)
+	enc.Write(bytes.Repeat([]byte{'a'}, 57))
+	enc.Close()
+	if s := out.String(); len(s) != maxCharsOneLine+2 || !strings.HasSuffix(s, "\n\n") {
+		t.Errorf("expected 72 chars and empty line, got %q", s)
+	}
+	out.Reset()
+	enc = NewEncoder(&out)
+	enc.Close()
+	if s := out.String(); s != "\n" {
+		t.Errorf("expected empty line, got %q", s)
+	}
+}
+
+func Example_chainReader() {
 	r1 := strings.NewReader("1234\n5678\n90\n12\n345")
 	r2 := newLineReader(r1, 4)
 	//r3 := &eatLastNewLineReader{wrapped: r2}
@@ -66,7 +217,7 @@ func ExampleChainReader() {
 	// 345
 }
 
-func ExampleChainReader2() {
+func Example_chainReader2() {
 	r1 := strings.NewReader("1234\n5678\n90\n12\n345")
 	r2 := newLineReader(r1, 4)
 	r3 := &eatLastNewLineReader{wrapped: r2}
@@ -84,8 +235,7 @@ func ExampleChainReader2() {
 	// 345
 }
 func ExampleEncode() {
-	//src := []byte("testing aliases for non-existent targets")
-	src := []byte{0, 0, 0, 0}
+	src := []byte("proc")
 	dst := make([]byte, 280)
 	length := Encode(dst, src)
 	fmt.Printf("%s", dst[:length])
@@ -98,7 +248,79 @@ func ExampleDecode() {
 	//src := []byte("z")
 	dst := make([]byte, 280)
 	length := Decode(dst, src)
-	fmt.Printf("%s", string(dst[:length]))
+	fmt.Printf("%x", dst[:length])
 	// Output:
-	// ,CHr@
+	// 01001101030a00110203430000000044000000002613010101020a0401030a04010406060322ea0100030105010601070a0106030602
+}
+
+func TestDecoderSection(t *testing.T) {
+	src := bytes.Repeat([]byte("tbcload "), 100)
+	var buf bytes.Buffer
+	longLine := strings.Repeat("1", 100)
+	buf.WriteString(longLine + "\n")
+	enc := NewEncoder(&buf)
+	enc.Write(src)
+	enc.Close()
+	enc = NewEncoder(&buf)
+	enc.Write(src)
+	enc.Close()
+	buf.WriteString("0\n")
+
+	d := NewDecoder(&buf)
+	d.MaxBytes = len(src)
+	if line, err := d.readRawLine(); err != nil || line != longLine {
+		t.Errorf("expected line of 100 chars, got %q, %v", line, err)
+	}
+	if dst, err := d.ReadSection(len(src)); err != nil || !bytes.Equal(dst, src) {
+		t.Errorf("wrong section: %q, %v", dst, err)
+	}
+	//Read return section in pieces
+	var got []byte
+	p := make([]byte, 100)
+	for len(got) < len(src) {
+		n, err := d.Read(p)
+		if err != nil || n == 0 {
+			t.Fatalf("Read returned %d, %v", n, err)
+		}
+		got = append(got, p[:n]...)
+	}
+	if !bytes.Equal(got, src) {
+		t.Errorf("wrong section by Read: %q", got)
+	}
+	if _, err := d.ReadSection(len(src) + 1); !errors.Is(err, ErrSectionTooLarge) {
+		t.Errorf("expected ErrSectionTooLarge, got %v", err)
+	}
+}
+
+func TestDecodeShortBuffer(t *testing.T) {
+	src := []byte("zz,CHr@")
+	if _, err := decode(make([]byte, 11), src); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("expected io.ErrShortBuffer, got %v", err)
+	}
+	if n := Decode(make([]byte, MaxDecodedLen(len(src))), src); n != 12 {
+		t.Errorf("expected 12 bytes, got %d", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic of short dst")
+		}
+	}()
+	Decode(make([]byte, 4), src)
+}
+
+func TestDecoderEmptyLine(t *testing.T) {
+	//lines decoded to nothing are skipped, Read return data or EOF
+	d := NewDecoder(strings.NewReader("\n \n,CHr@\n\n"))
+	p := make([]byte, 16)
+	if n, err := d.Read(p); err != nil || string(p[:n]) != "proc" {
+		t.Errorf("expected proc, got %q, %v", p[:n], err)
+	}
+	if n, err := d.Read(p); err != io.EOF || n != 0 {
+		t.Errorf("expected EOF, got %d, %v", n, err)
+	}
+	//section longer than declared is error
+	d = NewDecoder(strings.NewReader(",CHr@\n"))
+	if _, err := d.ReadSection(3); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("expected io.ErrShortBuffer, got %v", err)
+	}
 }
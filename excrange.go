@@ -0,0 +1,82 @@
+package tbcload
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExceptionRangeType is type of ExceptionRange
+type ExceptionRangeType byte
+
+// Exception range types, as type character in tbc file
+const (
+	LoopExceptionRange  ExceptionRangeType = 'L' //while,for,foreach body
+	CatchExceptionRange ExceptionRangeType = 'C' //catch body
+)
+
+// ExceptionRange is one item of exception range array,
+// written as "type nestingLevel codeOffset numCodeBytes breakOffset continueOffset catchOffset"
+type ExceptionRange struct {
+	Type           ExceptionRangeType
+	NestingLevel   int
+	CodeOffset     int //pc of first instruction in range
+	NumCodeBytes   int
+	BreakOffset    int //loop only, pc to jump on break
+	ContinueOffset int //loop only, pc to jump on continue
+	CatchOffset    int //catch only, pc to jump on error
+
+	numericType bool //type was written as Tcl enum, 0 for loop, 1 for catch
+}
+
+const numExceptionRangeFields = 7
+
+func parseExceptionRange(line string) (r *ExceptionRange, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != numExceptionRangeFields {
+		return nil, fmt.Errorf("exception range %q has %d fields, expected %d", line, len(fields), numExceptionRangeFields)
+	}
+	r = &ExceptionRange{}
+	switch fields[0] {
+	case "L", "C":
+		r.Type = ExceptionRangeType(fields[0][0])
+	case "0":
+		r.Type, r.numericType = LoopExceptionRange, true
+	case "1":
+		r.Type, r.numericType = CatchExceptionRange, true
+	default:
+		return nil, fmt.Errorf("exception range %q has unknown type %s", line, fields[0])
+	}
+	ints := []*int{&r.NestingLevel, &r.CodeOffset, &r.NumCodeBytes, &r.BreakOffset, &r.ContinueOffset, &r.CatchOffset}
+	for index, i := range ints {
+		if *i, err = strconv.Atoi(fields[index+1]); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// format return as line in tbc file
+func (r *ExceptionRange) format() string {
+	t := string(rune(r.Type))
+	if r.numericType {
+		t = "0"
+		if r.Type == CatchExceptionRange {
+			t = "1"
+		}
+	}
+	return fmt.Sprintf("%s %d %d %d %d %d %d", t, r.NestingLevel, r.CodeOffset, r.NumCodeBytes, r.BreakOffset, r.ContinueOffset, r.CatchOffset)
+}
+
+// End return pc after last instruction in range
+func (r *ExceptionRange) End() int {
+	return r.CodeOffset + r.NumCodeBytes
+}
+
+// String return as "loop,level=0,pc= 4-20,break=25,continue=21"
+func (r *ExceptionRange) String() string {
+	if r.Type == CatchExceptionRange {
+		return fmt.Sprintf("catch,level=%d,pc= %d-%d,catch=%d", r.NestingLevel, r.CodeOffset, r.End()-1, r.CatchOffset)
+	}
+	return fmt.Sprintf("loop,level=%d,pc= %d-%d,break=%d,continue=%d", r.NestingLevel, r.CodeOffset, r.End()-1, r.BreakOffset, r.ContinueOffset)
+}
@@ -0,0 +1,58 @@
+package tbcload
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseExceptionRange(t *testing.T) {
+	for _, v := range []struct {
+		line string
+		str  string
+	}{
+		{"L 0 14 5 21 6 -1", "loop,level=0,pc= 14-18,break=21,continue=6"},
+		{"C 1 31 4 -1 -1 41", "catch,level=1,pc= 31-34,catch=41"},
+		{"1 1 31 4 -1 -1 41", "catch,level=1,pc= 31-34,catch=41"},
+	} {
+		r, err := parseExceptionRange(v.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.String() != v.str {
+			t.Errorf("%s: expected %s, got %s", v.line, v.str, r)
+		}
+		if r.format() != v.line {
+			t.Errorf("%s: wrong format %s", v.line, r.format())
+		}
+	}
+	if _, err := parseExceptionRange("X 0 0 0 0 0 0"); err == nil {
+		t.Errorf("expected error for unknown type")
+	}
+}
+
+func TestDumpExceptionRange(t *testing.T) {
+	fs, err := os.Open("testdata/catch.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	p := NewParser(fs, &out)
+	p.Detail = true
+	if err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"\t[range-00 begin]loop,level=0,pc= 14-18,break=21,continue=6\n",
+		"\t[range-00 end]\n\t(19)jump1 -13\t# pc 6\n",
+		"\t[range-00 break]\n\t(21)",
+		"\t(26)beginCatch4 1\t# range 1\n",
+		"\t[range-01 catch]\n\t(41)pushResult\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected %q in output:\n%s", s, out.String())
+		}
+	}
+}
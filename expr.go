@@ -0,0 +1,293 @@
+package tbcload
+
+import (
+	"regexp"
+	"strings"
+)
+
+// precedence of expression operators, from lowest to highest
+const (
+	precTernary = iota + 1 //?:
+	precOr                 //||
+	precAnd                //&&
+	precBitOr              //|
+	precBitXor             //^
+	precBitAnd             //&
+	precEqual              //== != eq ne in ni
+	precCompare            //< > <= >=
+	precShift              //<< >>
+	precAdd                //+ -
+	precMult               //* / %
+	precExpon              //**
+	precUnary              //- + ~ !
+	precAtom               //operand, e.g. $a, [cmd], 1, f(x)
+)
+
+// exprOperator is operator of instruction in expression
+type exprOperator struct {
+	symbol string
+	prec   int
+}
+
+var binaryOperators = map[string]exprOperator{
+	"lor":       {"||", precOr},
+	"land":      {"&&", precAnd},
+	"bitor":     {"|", precBitOr},
+	"bitxor":    {"^", precBitXor},
+	"bitand":    {"&", precBitAnd},
+	"eq":        {"==", precEqual},
+	"neq":       {"!=", precEqual},
+	"streq":     {"eq", precEqual},
+	"strneq":    {"ne", precEqual},
+	"listIn":    {"in", precEqual},
+	"listNotIn": {"ni", precEqual},
+	"lt":        {"<", precCompare},
+	"gt":        {">", precCompare},
+	"le":        {"<=", precCompare},
+	"ge":        {">=", precCompare},
+	"lshift":    {"<<", precShift},
+	"rshift":    {">>", precShift},
+	"add":       {"+", precAdd},
+	"sub":       {"-", precAdd},
+	"mult":      {"*", precMult},
+	"div":       {"/", precMult},
+	"mod":       {"%", precMult},
+	"expon":     {"**", precExpon},
+}
+
+var unaryOperators = map[string]string{
+	"uplus":  "+",
+	"uminus": "-",
+	"bitnot": "~",
+	"not":    "!",
+}
+
+// builtinFunc is math function called by callBuiltinFunc1
+type builtinFunc struct {
+	name    string
+	numArgs int
+}
+
+// builtinFuncs is indexed by operand of callBuiltinFunc1, as builtinFuncTable of tclBasic.c
+var builtinFuncs = []builtinFunc{
+	{"acos", 1}, {"asin", 1}, {"atan", 1}, {"atan2", 2}, {"ceil", 1},
+	{"cos", 1}, {"cosh", 1}, {"exp", 1}, {"floor", 1}, {"fmod", 2},
+	{"hypot", 2}, {"log", 1}, {"log10", 1}, {"pow", 2}, {"sin", 1},
+	{"sinh", 1}, {"sqrt", 1}, {"tan", 1}, {"tanh", 1}, {"abs", 1},
+	{"double", 1}, {"int", 1}, {"rand", 0}, {"round", 1}, {"srand", 1},
+	{"wide", 1},
+}
+
+// mathFuncPrefix is namespace of math functions invoked by Tcl 8.5 and later
+const mathFuncPrefix = "tcl::mathfunc::"
+
+// exprStep simulate instruction of expression, return false if ins is not
+func (fr *frame) exprStep(ins *Instruction) bool {
+	if operator, ok := binaryOperators[ins.Name]; ok {
+		fr.push(&value{kind: exprValue, op: operator.symbol, parts: fr.popN(2)})
+		return true
+	}
+	if symbol, ok := unaryOperators[ins.Name]; ok {
+		if symbol == "!" {
+			fr.push(notValue(fr.pop()))
+		} else {
+			fr.push(&value{kind: exprValue, op: symbol, parts: fr.popN(1)})
+		}
+		return true
+	}
+	switch ins.Name {
+	case "callBuiltinFunc1":
+		if ins.Operands[0] >= len(builtinFuncs) {
+			return false
+		}
+		f := builtinFuncs[ins.Operands[0]]
+		fr.push(&value{kind: exprValue, op: "func", text: f.name, parts: fr.popN(f.numArgs)})
+	case "callFunc1":
+		//function name and arguments
+		if ins.Operands[0] < 1 {
+			return false
+		}
+		parts := fr.popN(ins.Operands[0])
+		fr.push(&value{kind: exprValue, op: "func", text: parts[0].text, parts: parts[1:]})
+	case "tryCvtToNumeric":
+		v := exprOf(fr.pop())
+		if v.kind != exprValue {
+			v = &value{kind: exprValue, parts: []*value{v}}
+		}
+		fr.push(v)
+	default:
+		return false
+	}
+	return true
+}
+
+// exprOf return v as used in expression
+func exprOf(v *value) *value {
+	if v.alt != nil {
+		return v.alt
+	}
+	return v
+}
+
+// notValue return !v
+func notValue(v *value) *value {
+	v = exprOf(v)
+	if v.kind == exprValue && v.op == "!" && len(v.parts) == 1 {
+		return v.parts[0]
+	}
+	return &value{kind: exprValue, op: "!", parts: []*value{v}}
+}
+
+func isLiteral(v *value, s string) bool {
+	return v.kind == literalValue && v.proc == nil && v.text == s
+}
+
+// isBoolean return true if v is always 0 or 1
+func isBoolean(v *value) bool {
+	if v.kind != exprValue {
+		return isLiteral(v, "0") || isLiteral(v, "1")
+	}
+	switch v.op {
+	case "!", "&&", "||", "bool":
+		return true
+	}
+	return len(v.parts) == 2 && (v.op != "func") && precOf(v.op) >= precEqual && precOf(v.op) <= precCompare
+}
+
+func precOf(symbol string) int {
+	for _, operator := range binaryOperators {
+		if operator.symbol == symbol {
+			return operator.prec
+		}
+	}
+	return 0
+}
+
+// ternary return c ? t : f, as && || ! if possible
+func ternary(c, t, f *value) *value {
+	c, t, f = exprOf(c), exprOf(t), exprOf(f)
+	switch {
+	case isLiteral(t, "1") && isLiteral(f, "0"):
+		if isBoolean(c) {
+			return c
+		}
+		return &value{kind: exprValue, op: "bool", parts: []*value{c}}
+	case isLiteral(t, "0") && isLiteral(f, "1"):
+		return notValue(c)
+	case isLiteral(f, "0") && isBoolean(t):
+		return &value{kind: exprValue, op: "&&", parts: []*value{unbool(c), unbool(t)}}
+	case isLiteral(t, "1") && isBoolean(f):
+		return &value{kind: exprValue, op: "||", parts: []*value{unbool(c), unbool(f)}}
+	}
+	return &value{kind: exprValue, op: "?:", parts: []*value{c, t, f}}
+}
+
+// unbool return operand of v, if v is converted to boolean for && ||
+func unbool(v *value) *value {
+	if v.kind == exprValue && v.op == "bool" {
+		return v.parts[0]
+	}
+	return v
+}
+
+// exprText return v as expression
+func (fr *frame) exprText(v *value) string {
+	s, _ := fr.exprPrec(v)
+	return s
+}
+
+// exprNot return negative expression of v
+func (fr *frame) exprNot(v *value) string {
+	return fr.exprText(notValue(v))
+}
+
+// exprPrec return v as expression and precedence of its operator
+func (fr *frame) exprPrec(v *value) (string, int) {
+	v = exprOf(v)
+	switch v.kind {
+	case literalValue:
+		if isNumber(v.text) {
+			return v.text, precAtom
+		}
+		return `"` + escapeString(v.text) + `"`, precAtom
+	case concatValue:
+		return `"` + fr.inner(v) + `"`, precAtom
+	case commandValue:
+		if len(v.words) > 0 && v.words[0].kind == literalValue && strings.HasPrefix(v.words[0].text, mathFuncPrefix) {
+			return fr.exprFunc(strings.TrimPrefix(v.words[0].text, mathFuncPrefix), v.words[1:]), precAtom
+		}
+		return fr.word(v), precAtom
+	case exprValue:
+	default:
+		return fr.word(v), precAtom
+	}
+	switch {
+	case v.op == "":
+		return fr.exprPrec(v.parts[0])
+	case v.op == "func":
+		return fr.exprFunc(v.text, v.parts), precAtom
+	case v.op == "bool":
+		//c ? 1 : 0
+		return fr.operand(v.parts[0], precTernary, false) + " ? 1 : 0", precTernary
+	case v.op == "?:":
+		return fr.operand(v.parts[0], precTernary, false) + " ? " + fr.operand(v.parts[1], precTernary, true) +
+			" : " + fr.operand(v.parts[2], precTernary, true), precTernary
+	case len(v.parts) == 1:
+		return v.op + fr.operand(v.parts[0], precUnary, true), precUnary
+	}
+	prec := precOf(v.op)
+	//** is right associative, others are left associative
+	right := v.op == "**"
+	return fr.operand(v.parts[0], prec, !right) + " " + v.op + " " + fr.operand(v.parts[1], prec, right), prec
+}
+
+// operand return v in parentheses if its precedence is lower than prec,
+// or equal to prec and not associative at this side
+func (fr *frame) operand(v *value, prec int, assoc bool) string {
+	s, p := fr.exprPrec(v)
+	if p < prec || p == prec && !assoc {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func (fr *frame) exprFunc(name string, args []*value) string {
+	var texts []string
+	for _, arg := range args {
+		texts = append(texts, fr.exprText(arg))
+	}
+	return name + "(" + strings.Join(texts, ", ") + ")"
+}
+
+var numberPattern = regexp.MustCompile(`^[-+]?(0[xX][0-9a-fA-F]+|[0-9]+(\.[0-9]*)?([eE][-+]?[0-9]+)?|\.[0-9]+([eE][-+]?[0-9]+)?)$`)
+
+// isNumber return true if s is integer or double in expression
+func isNumber(s string) bool {
+	return numberPattern.MatchString(s)
+}
+
+// isExprInstruction return true if ins is operator of expression
+func isExprInstruction(ins *Instruction) bool {
+	_, binary := binaryOperators[ins.Name]
+	_, unary := unaryOperators[ins.Name]
+	return binary || unary || ins.Name == "callBuiltinFunc1" || ins.Name == "callFunc1" || ins.Name == "tryCvtToNumeric"
+}
+
+// exprNotes return expression computed by the last operator of each expression,
+// by pc of the operator, for annotation of disassembly
+func exprNotes(opTable []InstructionDesc, bc *ByteCode, locals []*CompiledLocal, code []Instruction) map[int]string {
+	notes := map[int]string{}
+	fr := &frame{d: &Decompiler{opTable: opTable}, bc: bc, locals: locals, code: code, temps: map[int]*value{}}
+	for index := range code {
+		ins := &code[index]
+		fr.step(ins)
+		if !isExprInstruction(ins) || len(fr.stack) == 0 {
+			continue
+		}
+		if index+1 < len(code) && isExprInstruction(&code[index+1]) {
+			continue
+		}
+		notes[ins.PC] = "expr {" + fr.exprText(fr.stack[len(fr.stack)-1]) + "}"
+	}
+	return notes
+}
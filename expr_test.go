@@ -0,0 +1,85 @@
+package tbcload
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecompileShortCircuit(t *testing.T) {
+	//expr {$a && $b || !$a}
+	bc := &ByteCode{Literals: stringLiterals("1", "0"), Code: assemble(tcl86OpTable,
+		"loadScalar1 0", "jumpFalse1 10",
+		"loadScalar1 1", "jumpFalse1 6",
+		"push1 0", "jump1 4",
+		"push1 1",
+		"jumpTrue1 11",
+		"loadScalar1 0", "nop", "jumpFalse1 6",
+		"push1 1", "jump1 4",
+		"push1 0",
+		"done")}
+	testDecompileProc(t, bc, "expr {$a && $b || !$a}", "a", "b")
+}
+
+func TestDecompileExpr(t *testing.T) {
+	//set x [expr {-$a*($b+1)**2 > sin($a) ? "x" : abs($b)}]
+	bc := &ByteCode{Literals: stringLiterals("1", "2", "tcl::mathfunc::sin", "x", "tcl::mathfunc::abs"), Code: assemble(tcl86OpTable,
+		"loadScalar1 0", "uminus",
+		"loadScalar1 1", "push1 0", "add", "push1 1", "expon", "mult",
+		"push1 2", "loadScalar1 0", "invokeStk1 2",
+		"gt", "jumpFalse1 6",
+		"push1 3", "jump1 8",
+		"push1 4", "loadScalar1 1", "invokeStk1 2",
+		"tryCvtToNumeric", "storeScalar1 2",
+		"done")}
+	testDecompileProc(t, bc, `set x [expr {-$a * ($b + 1) ** 2 > sin($a) ? "x" : abs($b)}]`, "a", "b", "x")
+}
+
+func TestDecompileBuiltinFunc(t *testing.T) {
+	//set y [expr {(sqrt($x) - 1) / 2 % $x}]
+	bc := &ByteCode{Literals: stringLiterals("1", "2"), Code: assemble(tcl80OpTable,
+		"loadScalar1 0", "callBuiltinFunc1 16", "push1 0", "sub", "push1 1", "div",
+		"loadScalar1 0", "mod", "storeScalar1 1",
+		"done")}
+	proc := &Procedure{ByteCode: bc, Locals: []*CompiledLocal{{Name: "x", Index: 0}, {Name: "y", Index: 1}}}
+	d := &Decompiler{header: defaultHeader, opTable: tcl80OpTable}
+	lines, err := d.decompileByteCode(proc.ByteCode, proc.Locals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(lines, "\n"); s != "set y [expr {(sqrt($x) - 1) / 2 % $x}]" {
+		t.Errorf("wrong expression: %s", s)
+	}
+}
+
+func TestDumpExpr(t *testing.T) {
+	fs, err := os.Open("testdata/catch.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	p := NewParser(fs, &out)
+	p.Detail = true
+	if err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if s := "\t(11)lt\t# expr {$i < 3}\n"; !strings.Contains(out.String(), s) {
+		t.Errorf("expected %q in output:\n%s", s, out.String())
+	}
+}
+
+func TestDecompileCallFuncOperand(t *testing.T) {
+	//callFunc1 of tampered file has no function name
+	bc := &ByteCode{Literals: stringLiterals("1"), Code: assemble(tcl80OpTable,
+		"push1 0", "callFunc1 0", "storeScalar1 0", "done")}
+	d := &Decompiler{header: defaultHeader, opTable: tcl80OpTable}
+	lines, err := d.decompileByteCode(bc, []*CompiledLocal{{Name: "x", Index: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(lines, "\n"); s != "# (2)callFunc1 0\nset x 1" {
+		t.Errorf("expected callFunc1 as comment, got %s", s)
+	}
+}
@@ -0,0 +1,237 @@
+package tbcload
+
+import (
+	"regexp"
+	"strings"
+)
+
+// exprNode is node of parsed expression
+type exprNode struct {
+	op   string      //operator symbol, "()" for function, "" for operand
+	args []*exprNode //operands of operator, arguments of function
+	word *tclWord    //operand: number, variable, command, quoted or braced string
+	fn   string      //name of function
+}
+
+// exprParser parse Tcl expression into exprNode, by rules of expr(n)
+type exprParser struct {
+	scriptParser
+	has func(name string) bool //return true if instruction is in opcode table
+}
+
+// exprOperators is instruction of binary operator symbol, for both && || and ?: are jumps
+var exprOperators = func() map[string]string {
+	m := map[string]string{}
+	for name, operator := range binaryOperators {
+		m[operator.symbol] = name
+	}
+	return m
+}()
+
+// exprSymbols is binary operator symbols, longer before shorter
+var exprSymbols = []string{"**", "||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "<", ">", "+", "-", "*", "/", "%", "eq", "ne", "in", "ni"}
+
+var exprNumberPattern = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?)`)
+
+// parseExpr parse expression src, has return true if instruction is supported
+func parseExpr(src string, has func(name string) bool) (*exprNode, error) {
+	p := &exprParser{scriptParser: scriptParser{src: src}, has: has}
+	n, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(src) {
+		return nil, p.errorf("syntax error in expression %q", src)
+	}
+	return n, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) {
+		if c := p.src[p.pos]; isSpace(c) || c == '\n' {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "\\\n") {
+			p.pos += 2
+		} else {
+			return
+		}
+	}
+}
+
+// ternary parse c ? t : f
+func (p *exprParser) ternary() (*exprNode, error) {
+	c, err := p.binary(precOr)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.peek() != '?' {
+		return c, nil
+	}
+	p.pos++
+	t, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.peek() != ':' {
+		return nil, p.errorf("missing : in expression %q", p.src)
+	}
+	p.pos++
+	f, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return &exprNode{op: "?:", args: []*exprNode{c, t, f}}, nil
+}
+
+// operator return binary operator at pos, without consuming it
+func (p *exprParser) operator() (string, int) {
+	p.skipSpace()
+	rest := p.src[p.pos:]
+	for _, symbol := range exprSymbols {
+		if !strings.HasPrefix(rest, symbol) {
+			continue
+		}
+		//eq ne in ni are words
+		if isNameChar(symbol[0]) && len(rest) > len(symbol) && isNameChar(rest[len(symbol)]) {
+			continue
+		}
+		if symbol == "&&" || symbol == "||" {
+			return symbol, precOf(symbol)
+		}
+		if name, ok := exprOperators[symbol]; ok && p.has(name) {
+			return symbol, precOf(symbol)
+		}
+		return "", 0
+	}
+	return "", 0
+}
+
+// binary parse operators of precedence prec or higher
+func (p *exprParser) binary(prec int) (*exprNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		symbol, opPrec := p.operator()
+		if symbol == "" || opPrec < prec {
+			return left, nil
+		}
+		p.pos += len(symbol)
+		//** is right associative
+		next := opPrec + 1
+		if symbol == "**" {
+			next = opPrec
+		}
+		right, err := p.binary(next)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{op: symbol, args: []*exprNode{left, right}}
+	}
+}
+
+func (p *exprParser) unary() (*exprNode, error) {
+	p.skipSpace()
+	for name, symbol := range unaryOperators {
+		if p.peek() == symbol[0] && p.has(name) {
+			p.pos++
+			n, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &exprNode{op: symbol, args: []*exprNode{n}}, nil
+		}
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (n *exprNode, err error) {
+	p.skipSpace()
+	n = &exprNode{word: &tclWord{braced: true}}
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		if n, err = p.ternary(); err != nil {
+			return
+		}
+		if p.skipSpace(); p.peek() != ')' {
+			return nil, p.errorf("missing ) in expression %q", p.src)
+		}
+		p.pos++
+	case c == '$':
+		part, ok, err := p.parseVariable()
+		if err != nil || !ok {
+			return nil, p.errorf("invalid variable in expression %q", p.src)
+		}
+		n.word.parts = append(n.word.parts, part)
+	case c == '[':
+		script, err := p.parseBracketed()
+		if err != nil {
+			return nil, err
+		}
+		n.word.parts = append(n.word.parts, wordPart{kind: scriptPart, text: script})
+	case c == '"':
+		p.pos++
+		if err = p.parseParts(n.word, func(c byte) bool { return c == '"' }); err != nil {
+			return
+		}
+		if p.peek() != '"' {
+			return nil, p.errorf("missing \" in expression %q", p.src)
+		}
+		p.pos++
+		if len(n.word.parts) == 0 {
+			n.word.addText("")
+		}
+	case c == '{':
+		s, err := p.parseBraced()
+		if err != nil {
+			return nil, err
+		}
+		n.word.addText(s)
+	case exprNumberPattern.MatchString(p.src[p.pos:]):
+		number := exprNumberPattern.FindString(p.src[p.pos:])
+		p.pos += len(number)
+		n.word.addText(number)
+	case isNameChar(c):
+		return p.function()
+	default:
+		return nil, p.errorf("syntax error in expression %q", p.src)
+	}
+	return
+}
+
+// function parse name(arg, ...)
+func (p *exprParser) function() (*exprNode, error) {
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	n := &exprNode{op: "()", fn: p.src[start:p.pos]}
+	if p.skipSpace(); p.peek() != '(' {
+		return nil, p.errorf("invalid bareword %q in expression", n.fn)
+	}
+	p.pos++
+	if p.skipSpace(); p.peek() == ')' {
+		p.pos++
+		return n, nil
+	}
+	for {
+		arg, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		n.args = append(n.args, arg)
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+			continue
+		case ')':
+			p.pos++
+			return n, nil
+		}
+		return nil, p.errorf("missing ) after arguments of %s", n.fn)
+	}
+}
@@ -0,0 +1,152 @@
+package tbcload
+
+import (
+	"fmt"
+	"io"
+)
+
+// File is the object model of a .tbc file, which is a Tcl script
+// of one or more blocks of bytecode
+type File struct {
+	Block             //first block
+	More     []*Block //blocks following the first block
+	Epilogue string   //raw text after last block, e.g. "}"
+
+	newLine string //line ending of file read, "\n" or "\r\n"
+}
+
+// Wrapper commands of Block
+const (
+	BlockEval = "tbcload::bceval" //evaluate bytecode as script
+	BlockProc = "tbcload::bcproc" //define procedure of bytecode body
+)
+
+// Block is one bytecode block of tbc file, an argument of wrapper command
+// as "tbcload::bceval {...}" or "tbcload::bcproc name args {...}"
+type Block struct {
+	Prologue string     //raw text before Header, e.g. "tbcload::bceval {"
+	Command  string     //BlockEval or BlockProc, BlockEval if ""
+	Name     string     //name of procedure of BlockProc
+	Args     string     //argument list of BlockProc, as written in source
+	Header   Header     //e.g. "TclPro ByteCode 2 0 1.0 8.0"
+	ByteCode *ByteCode  //top level script, or body of Proc
+	Proc     *Procedure //procedure of BlockProc, nil for BlockEval
+}
+
+// Blocks return all blocks of f, first block at first
+func (f *File) Blocks() []*Block {
+	return append([]*Block{&f.Block}, f.More...)
+}
+
+// ByteCode is one compiled block, either the top level script
+// or the body of a procedure.
+type ByteCode struct {
+	Info            StructInfo
+	Code            []byte
+	CodeDelta       []byte
+	CodeLength      []byte
+	Literals        []*Literal
+	ExceptionRanges []*ExceptionRange
+	AuxData         []*AuxData
+}
+
+// StructInfo is the structure-info line of ByteCode,
+// e.g. "2 27 18 5 0 0 4 0 4"
+type StructInfo struct {
+	NumCommands     int
+	NumSrcBytes     int
+	NumCodeBytes    int
+	NumLitObjects   int
+	NumExceptRanges int
+	NumAuxDataItems int
+	NumCmdLocBytes  int
+	MaxExceptDepth  int
+	MaxStackDepth   int
+	Extra           []int //fields following maxStackDepth, if any
+}
+
+const numStructInfoFields = 9
+
+func newStructInfo(ints []int64) (info StructInfo, err error) {
+	if len(ints) < numStructInfoFields {
+		return info, fmt.Errorf("structure info has %d fields, expected %d", len(ints), numStructInfoFields)
+	}
+	fields := info.fields()
+	for index, i := range ints {
+		if index < len(fields) {
+			*fields[index] = int(i)
+		} else {
+			info.Extra = append(info.Extra, int(i))
+		}
+	}
+	return
+}
+
+func (info *StructInfo) fields() []*int {
+	return []*int{&info.NumCommands, &info.NumSrcBytes, &info.NumCodeBytes,
+		&info.NumLitObjects, &info.NumExceptRanges, &info.NumAuxDataItems,
+		&info.NumCmdLocBytes, &info.MaxExceptDepth, &info.MaxStackDepth}
+}
+
+func (info *StructInfo) ints() (res []int) {
+	for _, i := range info.fields() {
+		res = append(res, *i)
+	}
+	return append(res, info.Extra...)
+}
+
+// String return as "numCommands=2,numSrcBytes=27,..."
+func (info StructInfo) String() string {
+	s := fmt.Sprintf("numCommands=%d,numSrcBytes=%d,numCodeBytes=%d,numLitObjects=%d,numExceptRanges=%d,numAuxDataItems=%d,numCmdLocBytes=%d,maxExceptDepth=%d,maxStackDepth=%d",
+		info.NumCommands, info.NumSrcBytes, info.NumCodeBytes,
+		info.NumLitObjects, info.NumExceptRanges, info.NumAuxDataItems,
+		info.NumCmdLocBytes, info.MaxExceptDepth, info.MaxStackDepth)
+	for _, i := range info.Extra {
+		s += fmt.Sprintf(",%d", i)
+	}
+	return s
+}
+
+// LiteralType is the type character of literal in tbc file
+type LiteralType byte
+
+// Literal types written by TclPro
+const (
+	LiteralInt     LiteralType = 'i' //integer, as text
+	LiteralDouble  LiteralType = 'd' //double, as text
+	LiteralString  LiteralType = 's' //string, as text
+	LiteralXString LiteralType = 'x' //string, ascii85 encoded
+	LiteralProc    LiteralType = 'p' //procedure body
+)
+
+// Literal is one object of literal array
+type Literal struct {
+	Type  LiteralType
+	Value string     //text of LiteralInt/LiteralDouble/LiteralString/LiteralXString
+	Proc  *Procedure //only for LiteralProc
+}
+
+// Procedure is a compiled procedure body ('p' literal)
+type Procedure struct {
+	ByteCode *ByteCode
+	NumArgs  int
+	Locals   []*CompiledLocal
+}
+
+// CompiledLocal is one compiled local variable of Procedure
+type CompiledLocal struct {
+	Name    string
+	Index   int
+	Flags   int
+	Default *Literal //nil if there is no default value
+}
+
+// ReadFile read tbc file from r into File
+func ReadFile(r io.Reader) (*File, error) {
+	return NewParser(r, io.Discard).readFile()
+}
+
+// ReadFile read tbc file into File instead of writing disassembly, with limits of p
+func (p *Parser) ReadFile() (*File, error) {
+	return p.readFile()
+}
@@ -0,0 +1,182 @@
+package tbcload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	fs, err := os.Open("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	f, err := ReadFile(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := f.ByteCode
+	if bc.Info.NumCommands != 2 || bc.Info.NumCodeBytes != 18 || bc.Info.MaxStackDepth != 4 {
+		t.Errorf("wrong structure info: %s", bc.Info)
+	}
+	if len(bc.Code) != 18 || len(bc.CodeDelta) != 2 || len(bc.CodeLength) != 2 {
+		t.Errorf("wrong length of code,codeDelta,codeLength: %d,%d,%d", len(bc.Code), len(bc.CodeDelta), len(bc.CodeLength))
+	}
+	if len(bc.Literals) != 5 {
+		t.Fatalf("expected 5 literals, got %d", len(bc.Literals))
+	}
+	if bc.Literals[4].Type != LiteralString || bc.Literals[4].Value != "world" {
+		t.Errorf("wrong literal 4: %+v", bc.Literals[4])
+	}
+	proc := bc.Literals[3].Proc
+	if bc.Literals[3].Type != LiteralProc || proc == nil {
+		t.Fatalf("literal 3 is not procedure: %+v", bc.Literals[3])
+	}
+	if proc.NumArgs != 1 || len(proc.Locals) != 1 || proc.Locals[0].Name != "name" {
+		t.Errorf("wrong procedure: %+v", proc)
+	}
+	if lit := proc.ByteCode.Literals[1]; lit.Type != LiteralXString || lit.Value != "Hello " {
+		t.Errorf("wrong procedure literal 1: %+v", lit)
+	}
+}
+
+func TestReadFileMismatch(t *testing.T) {
+	src, err := os.ReadFile("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := strings.Replace(string(src), "2 27 18 5 0 0 4 0 4", "2 27 19 5 0 0 4 0 4", 1)
+	if _, err = ReadFile(strings.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "numCodeBytes") {
+		t.Errorf("expected numCodeBytes mismatch, got %v", err)
+	}
+}
+
+func TestReadFileLarge(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 8000; i++ {
+		fmt.Fprintf(&sb, "puts word%d\n", i)
+	}
+	f, err := NewCompiler().Compile(sb.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ByteCode.Code) <= 20480 || len(f.ByteCode.CodeDelta) <= 4096 {
+		t.Fatalf("expected large code, got %d bytes", len(f.ByteCode.Code))
+	}
+	var buf bytes.Buffer
+	if err = NewWriter(&buf).WriteFile(f); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ReadFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(g.ByteCode.Code, f.ByteCode.Code) || !bytes.Equal(g.ByteCode.CodeDelta, f.ByteCode.CodeDelta) ||
+		len(g.ByteCode.Literals) != len(f.ByteCode.Literals) {
+		t.Errorf("large file is not read back")
+	}
+
+	p := NewParser(bytes.NewReader(buf.Bytes()), io.Discard)
+	p.MaxBytes = 20480
+	if _, err = p.ReadFile(); !errors.Is(err, ErrSectionTooLarge) {
+		t.Errorf("expected ErrSectionTooLarge, got %v", err)
+	}
+	p = NewParser(bytes.NewReader(buf.Bytes()), io.Discard)
+	p.MaxBytes = len(f.ByteCode.Code)
+	if _, err = p.ReadFile(); err != nil {
+		t.Errorf("expected code of limit size, got %v", err)
+	}
+}
+
+func TestReadFileBlocks(t *testing.T) {
+	fs, err := os.Open("testdata/blocks.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	f, err := ReadFile(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := f.Blocks()
+	if len(blocks) != 3 || f.Epilogue != "}\n" {
+		t.Fatalf("expected 3 blocks, got %d, epilogue %q", len(blocks), f.Epilogue)
+	}
+	for index, command := range []string{BlockEval, BlockProc, BlockEval} {
+		if blocks[index].Command != command {
+			t.Errorf("block %d: expected %s, got %q", index, command, blocks[index].Command)
+		}
+	}
+	b := blocks[1]
+	if b.Name != "greet" || b.Args != "name" || b.Proc == nil || b.ByteCode != b.Proc.ByteCode || b.Proc.NumArgs != 1 {
+		t.Errorf("wrong bcproc block: %+v", b)
+	}
+	if !strings.HasPrefix(b.Prologue, "}\n") || blocks[2].ByteCode.Literals[0].Value != "greet" {
+		t.Errorf("wrong blocks following bcproc: %q", b.Prologue)
+	}
+	defs, err := f.Procs("")
+	if err != nil || len(defs) != 1 || defs[0].Name != "greet" || defs[0].Proc != b.Proc {
+		t.Errorf("expected procedure greet, got %+v, %v", defs, err)
+	}
+	if err = f.VerifyStack(""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseWrapper(t *testing.T) {
+	for _, v := range []struct {
+		prologue, command, name, args string
+	}{
+		{"tbcload::bceval {\n", BlockEval, "", ""},
+		{"}\ntbcload::bcproc greet name {\n", BlockProc, "greet", "name"},
+		{"}\r\n::tbcload::bcproc {a b} {x {y 1} args} {\r\n", BlockProc, "a b", "x {y 1} args"},
+		{"tbcload::bcproc p {} {\n", BlockProc, "p", ""},
+		{"eval {\n", "", "", ""},
+	} {
+		command, name, args := parseWrapper(v.prologue)
+		if command != v.command || name != v.name || args != v.args {
+			t.Errorf("%q: expected %q %q %q, got %q %q %q", v.prologue, v.command, v.name, v.args, command, name, args)
+		}
+	}
+}
+
+func TestProcedureArgList(t *testing.T) {
+	for _, argList := range []string{"", "name", "a {b default} args", "{x {a b}} {y {}}", "a args"} {
+		proc, err := newProcedure(tcl80OpTable, argList, "set t 1")
+		if err != nil || proc == nil {
+			t.Fatalf("%q: %v", argList, err)
+		}
+		if s := proc.ArgList(); s != argList {
+			t.Errorf("expected %q, got %q", argList, s)
+		}
+	}
+	//"args" flagged by Tcl 8.4+ has no default, temporaries are not arguments
+	proc := &Procedure{NumArgs: 2, Locals: []*CompiledLocal{
+		{Name: "a", Flags: varArgument, Default: &Literal{Type: LiteralInt, Value: "1"}},
+		{Name: "args", Index: 1, Flags: varArgument | varIsArgs, Default: &Literal{Type: LiteralString, Value: "x"}},
+		{Index: 2, Flags: varTemporary},
+	}}
+	if s := proc.ArgList(); s != "{a 1} args" {
+		t.Errorf("expected {a 1} args, got %q", s)
+	}
+}
+
+func TestParseProcSkeleton(t *testing.T) {
+	fs, err := os.Open("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	if err = NewParser(fs, &out).Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\n---procedure begin---\n[proc]proc hello name {...}\n") {
+		t.Errorf("expected skeleton of procedure hello, got\n%s", out.String())
+	}
+}
@@ -0,0 +1,109 @@
+package tbcload
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Header is the first line of ByteCode,
+// e.g. "TclPro ByteCode 2 0 1.0 8.0"
+type Header struct {
+	FormatMajor     int //format version of tbc file
+	FormatMinor     int
+	CompilerVersion string //version of procomp, e.g. "1.0"
+	TclVersion      string //target Tcl version, e.g. "8.0"
+}
+
+// ErrUnsupportedVersion means format or Tcl version in header is not supported
+var ErrUnsupportedVersion = errors.New("version is not supported")
+
+// supported versions
+const (
+	minFormatMajor = 1
+	maxFormatMajor = 2
+	minTclVersion  = 80 //8.0
+	maxTclVersion  = 86 //8.6
+)
+
+// defaultHeader is used, if File has no Header
+var defaultHeader = Header{FormatMajor: 2, FormatMinor: 0, CompilerVersion: "1.0", TclVersion: "8.0"}
+
+func parseHeader(line string) (h Header, err error) {
+	if !strings.HasPrefix(line, tbcFileBeginWith) {
+		return h, fmt.Errorf("header %q does not begin with %q", line, tbcFileBeginWith)
+	}
+	fields := strings.Fields(line[len(tbcFileBeginWith):])
+	if len(fields) != 4 {
+		return h, fmt.Errorf("header %q has %d fields, expected 4", line, len(fields))
+	}
+	if h.FormatMajor, err = strconv.Atoi(fields[0]); err != nil {
+		return
+	}
+	if h.FormatMinor, err = strconv.Atoi(fields[1]); err != nil {
+		return
+	}
+	h.CompilerVersion, h.TclVersion = fields[2], fields[3]
+	err = h.check()
+	return
+}
+
+func (h Header) check() error {
+	if h.FormatMajor < minFormatMajor || h.FormatMajor > maxFormatMajor {
+		return fmt.Errorf("%w: format %d.%d", ErrUnsupportedVersion, h.FormatMajor, h.FormatMinor)
+	}
+	if v := h.tclVersion(); v < minTclVersion || v > maxTclVersion {
+		return fmt.Errorf("%w: Tcl %s", ErrUnsupportedVersion, h.TclVersion)
+	}
+	return nil
+}
+
+// tclVersion return TclVersion as major*10+minor, e.g. 83 for "8.3",
+// or -1 if it is not a version
+func (h Header) tclVersion() int {
+	return parseTclVersion(h.TclVersion)
+}
+
+func parseTclVersion(s string) int {
+	var major, minor int
+	var err error
+	fields := strings.SplitN(s, ".", 3)
+	if len(fields) < 2 {
+		return -1
+	}
+	if major, err = strconv.Atoi(fields[0]); err != nil {
+		return -1
+	}
+	if minor, err = strconv.Atoi(fields[1]); err != nil || minor > 9 {
+		return -1
+	}
+	return major*10 + minor
+}
+
+// utf8 return true if strings are Tcl's internal utf-8,
+// Tcl 8.0 strings are bytes as is.
+func (h Header) utf8() bool {
+	return h.tclVersion() >= 81
+}
+
+// String return as "TclPro ByteCode 2 0 1.0 8.0"
+func (h Header) String() string {
+	return fmt.Sprintf("%s%d %d %s %s", tbcFileBeginWith, h.FormatMajor, h.FormatMinor, h.CompilerVersion, h.TclVersion)
+}
+
+// fromTclString convert Tcl's internal utf-8 (nul as 0xC0 0x80) to string
+func (h Header) fromTclString(s string) string {
+	if h.utf8() {
+		return strings.ReplaceAll(s, "\xc0\x80", "\x00")
+	}
+	return s
+}
+
+// toTclString convert string to Tcl's internal utf-8 (nul as 0xC0 0x80)
+func (h Header) toTclString(s string) string {
+	if h.utf8() {
+		return strings.ReplaceAll(s, "\x00", "\xc0\x80")
+	}
+	return s
+}
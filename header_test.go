@@ -0,0 +1,37 @@
+package tbcload
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	h, err := parseHeader("TclPro ByteCode 1 0 1.3 8.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != (Header{FormatMajor: 1, FormatMinor: 0, CompilerVersion: "1.3", TclVersion: "8.3"}) {
+		t.Errorf("wrong header: %+v", h)
+	}
+	if h.String() != "TclPro ByteCode 1 0 1.3 8.3" {
+		t.Errorf("wrong header string: %s", h)
+	}
+	if !h.utf8() {
+		t.Errorf("Tcl 8.3 strings should be utf-8")
+	}
+}
+
+func TestParseHeaderUnsupported(t *testing.T) {
+	for _, line := range []string{
+		"TclPro ByteCode 3 0 1.0 8.0",
+		"TclPro ByteCode 2 0 1.0 7.6",
+		"TclPro ByteCode 2 0 1.0 9.0",
+	} {
+		if _, err := parseHeader(line); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("%s: expected ErrUnsupportedVersion, got %v", line, err)
+		}
+	}
+	if _, err := parseHeader("TclPro ByteCode 2 0"); err == nil {
+		t.Errorf("expected error for short header")
+	}
+}
@@ -0,0 +1,265 @@
+package tbcload
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Output formats of Parser
+const (
+	FormatText = "text" //free text, e.g. [lit-0003]
+	FormatJSON = "json" //JSON of schema jsonFile
+)
+
+// jsonSchemaVersion is increased when fields of JSON output are changed or removed
+const jsonSchemaVersion = 1
+
+// jsonFile is JSON output of tbc file
+type jsonFile struct {
+	Schema int `json:"schema"`
+	jsonBlock
+	Blocks      []jsonBlock      `json:"blocks,omitempty"`      //blocks following the first block
+	Diagnostics []jsonDiagnostic `json:"diagnostics,omitempty"` //errors recovered in Tolerant mode
+}
+
+// jsonBlock is a bytecode block, procedure of bcproc has its bytecode in procedure
+type jsonBlock struct {
+	Command   string         `json:"command,omitempty"` //tbcload::bceval or tbcload::bcproc
+	Name      string         `json:"name,omitempty"`    //procedure name of bcproc
+	Args      string         `json:"args,omitempty"`    //argument list of bcproc
+	Header    jsonHeader     `json:"header"`
+	ByteCode  *jsonByteCode  `json:"bytecode,omitempty"`
+	Procedure *jsonProcedure `json:"procedure,omitempty"`
+}
+
+type jsonHeader struct {
+	FormatMajor     int    `json:"formatMajor"`
+	FormatMinor     int    `json:"formatMinor"`
+	CompilerVersion string `json:"compilerVersion"`
+	TclVersion      string `json:"tclVersion"`
+}
+
+type jsonByteCode struct {
+	Info            jsonStructInfo    `json:"info"`
+	Commands        []jsonCommand     `json:"commands"`
+	Instructions    []jsonInstruction `json:"instructions"`
+	Literals        []jsonLiteral     `json:"literals"`
+	ExceptionRanges []jsonRange       `json:"exceptionRanges"`
+	AuxData         []jsonAuxData     `json:"auxData"`
+}
+
+type jsonDiagnostic struct {
+	Line    int      `json:"line"`
+	Section string   `json:"section"`
+	Path    []string `json:"path"`
+	Message string   `json:"message"`
+}
+
+type jsonStructInfo struct {
+	NumCommands     int   `json:"numCommands"`
+	NumSrcBytes     int   `json:"numSrcBytes"`
+	NumCodeBytes    int   `json:"numCodeBytes"`
+	NumLitObjects   int   `json:"numLitObjects"`
+	NumExceptRanges int   `json:"numExceptRanges"`
+	NumAuxDataItems int   `json:"numAuxDataItems"`
+	NumCmdLocBytes  int   `json:"numCmdLocBytes"`
+	MaxExceptDepth  int   `json:"maxExceptDepth"`
+	MaxStackDepth   int   `json:"maxStackDepth"`
+	Extra           []int `json:"extra,omitempty"`
+}
+
+type jsonCommand struct {
+	CodeOffset int `json:"codeOffset"`
+	CodeLength int `json:"codeLength"`
+}
+
+type jsonInstruction struct {
+	PC       int    `json:"pc"`
+	Opcode   int    `json:"opcode"`
+	Name     string `json:"name"`
+	Size     int    `json:"size"`
+	Operands []int  `json:"operands"`
+	Note     string `json:"note,omitempty"` //literal, variable, jump target or expression
+}
+
+type jsonLiteral struct {
+	Index     int            `json:"index"`
+	Type      string         `json:"type"` //int, double, string, xstring or proc
+	Value     string         `json:"value"`
+	Procedure *jsonProcedure `json:"procedure,omitempty"`
+}
+
+type jsonProcedure struct {
+	Name     string        `json:"name,omitempty"` //name in proc command defining it, if it is found
+	ArgList  string        `json:"argList"`        //reconstructed from numArgs and locals
+	NumArgs  int           `json:"numArgs"`
+	Locals   []jsonLocal   `json:"locals"`
+	ByteCode *jsonByteCode `json:"bytecode"`
+}
+
+type jsonLocal struct {
+	Index     int     `json:"index"`
+	Name      string  `json:"name"`
+	Flags     int     `json:"flags"`
+	Argument  bool    `json:"argument"`
+	Temporary bool    `json:"temporary"`
+	Default   *string `json:"default,omitempty"`
+}
+
+type jsonRange struct {
+	Index          int    `json:"index"`
+	Type           string `json:"type"` //loop or catch
+	NestingLevel   int    `json:"nestingLevel"`
+	CodeOffset     int    `json:"codeOffset"`
+	NumCodeBytes   int    `json:"numCodeBytes"`
+	BreakOffset    int    `json:"breakOffset"`
+	ContinueOffset int    `json:"continueOffset"`
+	CatchOffset    int    `json:"catchOffset"`
+}
+
+type jsonAuxData struct {
+	Index      int                 `json:"index"`
+	Type       string              `json:"type"` //foreach, jumpTable or dictUpdate
+	Foreach    *jsonForeachInfo    `json:"foreach,omitempty"`
+	JumpTable  []jsonJumpEntry     `json:"jumpTable,omitempty"`
+	DictUpdate *jsonDictUpdateInfo `json:"dictUpdate,omitempty"`
+}
+
+type jsonForeachInfo struct {
+	FirstValueTemp int     `json:"firstValueTemp"`
+	LoopCtTemp     int     `json:"loopCtTemp"`
+	VarLists       [][]int `json:"varLists"`
+}
+
+type jsonJumpEntry struct {
+	Key    string `json:"key"`
+	Offset int    `json:"offset"`
+}
+
+type jsonDictUpdateInfo struct {
+	VarIndexes []int `json:"varIndexes"`
+}
+
+var literalTypeNames = map[LiteralType]string{
+	LiteralInt: "int", LiteralDouble: "double", LiteralString: "string",
+	LiteralXString: "xstring", LiteralProc: "proc",
+}
+
+var auxDataTypeNames = map[AuxDataType]string{
+	ForeachAuxData: "foreach", JumpTableAuxData: "jumpTable", DictUpdateAuxData: "dictUpdate",
+}
+
+// dumpJSON write f as JSON
+func (p *Parser) dumpJSON(f *File) (err error) {
+	out := jsonFile{Schema: jsonSchemaVersion}
+	for index, b := range f.Blocks() {
+		var block *jsonBlock
+		if block, err = p.jsonBlock(b); err != nil {
+			return fmt.Errorf("block %d: %w", index, err)
+		}
+		if index == 0 {
+			out.jsonBlock = *block
+		} else {
+			out.Blocks = append(out.Blocks, *block)
+		}
+	}
+	for _, d := range p.Diagnostics {
+		out.Diagnostics = append(out.Diagnostics, jsonDiagnostic{d.Line, d.Section, append([]string{}, d.Path...), d.Err.Error()})
+	}
+	enc := json.NewEncoder(&p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func (p *Parser) jsonBlock(b *Block) (res *jsonBlock, err error) {
+	if err = p.selectBlock(b); err != nil {
+		return
+	}
+	h := b.Header
+	res = &jsonBlock{Command: b.Command, Name: b.Name, Args: b.Args,
+		Header: jsonHeader{h.FormatMajor, h.FormatMinor, h.CompilerVersion, h.TclVersion}}
+	if b.Command == BlockProc {
+		res.Procedure, err = p.jsonProcedure(b.Proc, b.Name)
+	} else {
+		res.ByteCode, err = p.jsonByteCode(b.ByteCode, nil)
+	}
+	return
+}
+
+func (p *Parser) jsonByteCode(bc *ByteCode, locals []*CompiledLocal) (*jsonByteCode, error) {
+	info := bc.Info
+	res := &jsonByteCode{
+		Info: jsonStructInfo{info.NumCommands, info.NumSrcBytes, info.NumCodeBytes, info.NumLitObjects,
+			info.NumExceptRanges, info.NumAuxDataItems, info.NumCmdLocBytes, info.MaxExceptDepth,
+			info.MaxStackDepth, info.Extra},
+		Commands: []jsonCommand{}, Instructions: []jsonInstruction{}, Literals: []jsonLiteral{},
+		ExceptionRanges: []jsonRange{}, AuxData: []jsonAuxData{},
+	}
+	locs, err := bc.CommandLocations()
+	if err != nil {
+		return nil, err
+	}
+	for _, loc := range locs {
+		res.Commands = append(res.Commands, jsonCommand{loc.CodeOffset, loc.CodeLength})
+	}
+	code, err := disassemble(p.opTable, bc.Code)
+	if err != nil {
+		return nil, err
+	}
+	exprs := exprNotes(p.opTable, bc, locals, code)
+	for _, ins := range code {
+		note := annotate(&ins, bc, locals)
+		if expr, ok := exprs[ins.PC]; ok {
+			note = expr
+		}
+		operands := append([]int{}, ins.Operands...)
+		res.Instructions = append(res.Instructions, jsonInstruction{ins.PC, int(ins.Opcode), ins.Name, ins.Size, operands, note})
+	}
+	for index, lit := range bc.Literals {
+		item := jsonLiteral{Index: index, Type: literalTypeNames[lit.Type], Value: lit.Value}
+		if lit.Type == LiteralProc {
+			if item.Procedure, err = p.jsonProcedure(lit.Proc, procName(p.opTable, bc, index)); err != nil {
+				return nil, fmt.Errorf("literal %d: %w", index, err)
+			}
+		}
+		res.Literals = append(res.Literals, item)
+	}
+	for index, r := range bc.ExceptionRanges {
+		t := "loop"
+		if r.Type == CatchExceptionRange {
+			t = "catch"
+		}
+		res.ExceptionRanges = append(res.ExceptionRanges, jsonRange{index, t, r.NestingLevel, r.CodeOffset,
+			r.NumCodeBytes, r.BreakOffset, r.ContinueOffset, r.CatchOffset})
+	}
+	for index, aux := range bc.AuxData {
+		item := jsonAuxData{Index: index, Type: auxDataTypeNames[aux.Type]}
+		switch {
+		case aux.Foreach != nil:
+			item.Foreach = &jsonForeachInfo{aux.Foreach.FirstValueTemp, aux.Foreach.LoopCtTemp, aux.Foreach.VarLists}
+		case aux.JumpTable != nil:
+			item.JumpTable = []jsonJumpEntry{}
+			for _, entry := range aux.JumpTable.Entries {
+				item.JumpTable = append(item.JumpTable, jsonJumpEntry{entry.Key, entry.Offset})
+			}
+		case aux.DictUpdate != nil:
+			item.DictUpdate = &jsonDictUpdateInfo{aux.DictUpdate.VarIndexes}
+		}
+		res.AuxData = append(res.AuxData, item)
+	}
+	return res, nil
+}
+
+func (p *Parser) jsonProcedure(proc *Procedure, name string) (res *jsonProcedure, err error) {
+	res = &jsonProcedure{Name: name, ArgList: proc.ArgList(), NumArgs: proc.NumArgs, Locals: []jsonLocal{}}
+	for _, local := range proc.Locals {
+		item := jsonLocal{Index: local.Index, Name: local.Name, Flags: local.Flags,
+			Argument: local.Flags&varArgument != 0, Temporary: local.Flags&varTemporary != 0}
+		if local.Default != nil {
+			item.Default = &local.Default.Value
+		}
+		res.Locals = append(res.Locals, item)
+	}
+	res.ByteCode, err = p.jsonByteCode(proc.ByteCode, proc.Locals)
+	return
+}
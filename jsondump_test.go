@@ -0,0 +1,116 @@
+package tbcload
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	fs, err := os.Open("testdata/foreach.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	p := NewParser(fs, &out)
+	p.Format = FormatJSON
+	if err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	var f jsonFile
+	if err = json.Unmarshal(out.Bytes(), &f); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, out.String())
+	}
+	if f.Schema != jsonSchemaVersion || f.Header.TclVersion != "8.0" || len(f.ByteCode.Literals) != 4 {
+		t.Fatalf("unexpected output %s", out.String())
+	}
+	if ins := f.ByteCode.Instructions[1]; ins.PC != 2 || ins.Name != "push1" || ins.Operands[0] != 1 || ins.Note != `"f"` {
+		t.Errorf("unexpected instruction %+v", ins)
+	}
+	proc := f.ByteCode.Literals[3].Procedure
+	if proc == nil || proc.NumArgs != 2 || len(proc.Locals) == 0 || !proc.Locals[0].Argument || proc.Locals[0].Name != "l1" {
+		t.Fatalf("unexpected procedure %+v", proc)
+	}
+	if proc.Name != "f" || proc.ArgList != "l1 l2" {
+		t.Errorf("unexpected procedure signature %q %q", proc.Name, proc.ArgList)
+	}
+	aux := proc.ByteCode.AuxData
+	if len(aux) != 1 || aux[0].Type != "foreach" || aux[0].Foreach == nil || len(aux[0].Foreach.VarLists) != 2 {
+		t.Errorf("unexpected aux data %+v", aux)
+	}
+	if len(proc.ByteCode.ExceptionRanges) != 1 || proc.ByteCode.ExceptionRanges[0].Type != "loop" {
+		t.Errorf("unexpected exception ranges %+v", proc.ByteCode.ExceptionRanges)
+	}
+}
+
+func TestParseJSONDiagnostics(t *testing.T) {
+	src, err := os.ReadFile("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(src), "\n")
+	lines[30] = "b"
+	var out bytes.Buffer
+	p := NewParser(strings.NewReader(strings.Join(lines, "\n")), &out)
+	p.Format, p.Tolerant = FormatJSON, true
+	if err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	var f jsonFile
+	if err = json.Unmarshal(out.Bytes(), &f); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, out.String())
+	}
+	if len(f.Diagnostics) != 1 || f.Diagnostics[0].Line != 31 || f.Diagnostics[0].Section != "literal 1" ||
+		len(f.Diagnostics[0].Path) != 1 || f.Diagnostics[0].Message != "unsupported object type 'b'" {
+		t.Errorf("unexpected diagnostics %+v", f.Diagnostics)
+	}
+	if len(f.ByteCode.Literals) != 5 || f.ByteCode.Literals[4].Value != "world" {
+		t.Errorf("expected literals after damaged literal")
+	}
+}
+
+func TestParseJSONBlocks(t *testing.T) {
+	fs, err := os.Open("testdata/blocks.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	p := NewParser(fs, &out)
+	p.Format = FormatJSON
+	if err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	var f jsonFile
+	if err = json.Unmarshal(out.Bytes(), &f); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, out.String())
+	}
+	if f.Command != BlockEval || f.ByteCode == nil || len(f.Blocks) != 2 {
+		t.Fatalf("unexpected output %s", out.String())
+	}
+	b := f.Blocks[0]
+	if b.Command != BlockProc || b.Name != "greet" || b.Args != "name" || b.ByteCode != nil ||
+		b.Procedure == nil || b.Procedure.NumArgs != 1 || len(b.Procedure.ByteCode.Literals) != 4 {
+		t.Errorf("unexpected bcproc block %+v", b)
+	}
+	if f.Blocks[1].ByteCode == nil || f.Blocks[1].ByteCode.Literals[0].Value != "greet" {
+		t.Errorf("unexpected block %+v", f.Blocks[1])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	fs, err := os.Open("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	p := NewParser(fs, &out)
+	p.Format = "xml"
+	if err = p.Parse(); err == nil {
+		t.Errorf("expected error of unknown format")
+	}
+}
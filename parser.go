@@ -3,26 +3,55 @@ package tbcload
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"math"
-	"os"
 	"strconv"
 	"strings"
 )
 
 // Parser read tbc file and write 'dissemble' to w
 type Parser struct {
-	r      Decoder
-	w      bufio.Writer
-	Detail bool //true: disassemble bytecode
+	r          Decoder
+	w          bufio.Writer
+	Detail     bool   //true: disassemble bytecode
+	TclVersion string //e.g. "8.3", select opcode table by it instead of header
+	Format     string //FormatText if "", FormatJSON or FormatTcl
+	MaxBytes   int    //limit of bytes of one section, DefaultMaxBytes if 0
+	Tolerant   bool   //true: record errors in Diagnostics and continue parsing
+
+	Diagnostics []*ParseError //errors recovered in Tolerant mode
+
+	header  Header //header of file being parsed
+	opTable []InstructionDesc
+	section string   //section being parsed, for ParseError
+	path    []string //procedures being parsed, for ParseError
+}
+
+// ParseError is error of parsing tbc file, with position where it happened
+type ParseError struct {
+	Line    int      //line number of input, from 1
+	Section string   //e.g. header, code, codeDelta, literal 7, aux data 0
+	Path    []string //enclosing procedures, outermost first, e.g. proc hello
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(e.Line) + ": ")
+	for _, proc := range e.Path {
+		b.WriteString(proc + ": ")
+	}
+	if e.Section != "" {
+		b.WriteString(e.Section + ": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
 
-	codeBytes  bytes.Buffer
-	codeDelta  bytes.Buffer
-	codeLength bytes.Buffer
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 // NewParser create Parser
@@ -34,43 +63,251 @@ const tbcFileBeginWith = "TclPro ByteCode "
 
 // Parse from io.Reader
 func (p *Parser) Parse() (err error) {
-	if err = p.skipUntil(tbcFileBeginWith); err != nil {
+	var f *File
+	if f, err = p.readFile(); err != nil {
+		return
+	}
+	switch p.Format {
+	case "", FormatText:
+	case FormatJSON:
+		err = p.dumpJSON(f)
+		p.w.Flush()
+		return
+	case FormatTcl:
+		err = p.dumpTcl(f)
+		p.w.Flush()
 		return
+	default:
+		return fmt.Errorf("unknown output format %q", p.Format)
+	}
+	for index, b := range f.Blocks() {
+		if err = p.selectBlock(b); err != nil {
+			return
+		}
+		if len(f.More) > 0 || b.Command == BlockProc {
+			p.w.WriteString(fmt.Sprintf("[block-%02d]command=%s,name=%s,args=%s\n", index, b.Command, b.Name, b.Args))
+		}
+		h := b.Header
+		p.w.WriteString(fmt.Sprintf("[header]format=%d.%d,compiler=%s,tcl=%s\n", h.FormatMajor, h.FormatMinor, h.CompilerVersion, h.TclVersion))
+		if b.Command == BlockProc {
+			p.dumpProcSkeleton(b.Proc, b.Name)
+			err = p.dumpByteCode(b.ByteCode, b.Proc.Locals)
+			p.dumpLocals(b.Proc.Locals)
+		} else {
+			err = p.dumpByteCode(b.ByteCode, nil)
+		}
+		if err != nil {
+			break
+		}
 	}
-	err = p.parseByteCode()
 	p.w.Flush()
 	return
 }
 
+// selectBlock select opcode table by header of b, for dumping b
+func (p *Parser) selectBlock(b *Block) (err error) {
+	p.header = b.Header
+	p.opTable, err = p.selectOpTable()
+	return
+}
+
+func (p *Parser) readFile() (f *File, err error) {
+	if f, err = p.parseFile(); err != nil {
+		return nil, p.parseError(err)
+	}
+	return
+}
+
+// parseError return err as ParseError at current position
+func (p *Parser) parseError(err error) *ParseError {
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		perr = &ParseError{Line: p.r.lines.line, Section: p.section, Path: append([]string(nil), p.path...), Err: err}
+	}
+	return perr
+}
+
+// recover record err in Diagnostics and return nil in Tolerant mode,
+// or return err if not Tolerant or input is ended
+func (p *Parser) recover(err error) error {
+	if err == nil || !p.Tolerant || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	p.Diagnostics = append(p.Diagnostics, p.parseError(err))
+	return nil
+}
+
+// resync skip lines until object type of types, or a line of integers as
+// count of next section, or end of bceval. It returns true if the line is
+// object type. The line is left to be read again. If err is of unknown type,
+// its first line of content is skipped
+func (p *Parser) resync(types string, err error) (bool, error) {
+	if errors.Is(err, ErrUnsupoortedObjectType) || errors.Is(err, ErrUnsupportedAuxDataType) {
+		if _, err = p.r.readRawLine(); err != nil {
+			return false, err
+		}
+	}
+	for {
+		line, err := p.r.readRawLine()
+		if err != nil {
+			return false, err
+		}
+		isType := len(line) == 1 && strings.Contains(types, line)
+		if isType || line == "}" || isIntegers(line) {
+			p.r.lines.unreadLine(line)
+			return isType, nil
+		}
+	}
+}
+
+// isIntegers return true if line is integers separated by spaces
+func isIntegers(line string) bool {
+	fields := strings.Fields(line)
+	for _, s := range fields {
+		if _, err := strconv.ParseInt(s, 10, 32); err != nil {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
+func (p *Parser) parseFile() (f *File, err error) {
+	var text string
+	p.r.MaxBytes = p.MaxBytes
+	f = &File{newLine: "\n"}
+	for index := 0; ; index++ {
+		p.section, p.path = "", nil
+		if text, err = p.skipToHeader(); err != nil {
+			if index > 0 && err == io.EOF {
+				f.Epilogue = text
+				return f, nil
+			}
+			return nil, err
+		}
+		//last line recorded is the header line
+		if index == 0 && strings.HasSuffix(text, "\r\n") {
+			f.newLine = "\r\n"
+		}
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		b := &f.Block
+		if index > 0 {
+			b = &Block{}
+			f.More = append(f.More, b)
+			p.path = []string{fmt.Sprintf("block %d", index)}
+		}
+		headerIndex := strings.LastIndex(text, "\n") + 1
+		b.Prologue = text[:headerIndex]
+		b.Command, b.Name, b.Args = parseWrapper(b.Prologue)
+		p.section = "header"
+		if b.Header, err = parseHeader(text[headerIndex:]); err != nil {
+			return nil, err
+		}
+		p.header = b.Header
+		if p.opTable, err = p.selectOpTable(); err != nil {
+			return nil, err
+		}
+		if err = p.parseBlock(b); err != nil {
+			if !p.Tolerant || b.ByteCode == nil {
+				return nil, err
+			}
+			//keep what is parsed before end of input
+			p.Diagnostics = append(p.Diagnostics, p.parseError(err))
+			return f, nil
+		}
+	}
+}
+
+// skipToHeader skip lines until header of next block,
+// returns raw text read, which ends with the header line
+func (p *Parser) skipToHeader() (string, error) {
+	var text bytes.Buffer
+	//line put back by resync in Tolerant mode
+	text.WriteString(p.r.lines.lastStr)
+	p.r.lines.record = &text
+	err := p.skipUntil(tbcFileBeginWith)
+	p.r.lines.record = nil
+	return text.String(), err
+}
+
+// parseWrapper return wrapper command of block by text before its header,
+// e.g. "tbcload::bcproc hello {name} {"
+func parseWrapper(prologue string) (command, name, args string) {
+	index := strings.LastIndex(prologue, "tbcload::bc")
+	if index < 0 {
+		return
+	}
+	words, err := splitList(strings.TrimSuffix(strings.TrimSpace(prologue[index:]), "{"))
+	if err != nil {
+		return
+	}
+	switch {
+	case len(words) == 1 && words[0] == BlockEval:
+		command = BlockEval
+	case len(words) == 3 && words[0] == BlockProc:
+		command, name, args = BlockProc, words[1], words[2]
+	}
+	return
+}
+
+// parseBlock parse bytecode of b, and procedure of BlockProc
+func (p *Parser) parseBlock(b *Block) (err error) {
+	if b.Command != BlockProc {
+		b.ByteCode, err = p.parseByteCode()
+		return
+	}
+	p.section = "proc " + b.Name
+	b.Proc, err = p.parseProcedureObject()
+	b.ByteCode = b.Proc.ByteCode
+	return
+}
+
+// fileOpTable return opcode table of tclVersion, or of header if tclVersion is ""
+func fileOpTable(header Header, tclVersion string) ([]InstructionDesc, error) {
+	if header == (Header{}) {
+		header = defaultHeader
+	}
+	p := Parser{header: header, TclVersion: tclVersion}
+	return p.selectOpTable()
+}
+
+// selectOpTable select opcode table by TclVersion if set, or by header
+func (p *Parser) selectOpTable() ([]InstructionDesc, error) {
+	if p.TclVersion == "" {
+		return opTableFor(p.header.tclVersion()), nil
+	}
+	v := parseTclVersion(p.TclVersion)
+	if v < minTclVersion || v > maxTclVersion {
+		return nil, fmt.Errorf("%w: Tcl %s", ErrUnsupportedVersion, p.TclVersion)
+	}
+	return opTableFor(v), nil
+}
+
 func (p *Parser) skipUntil(prefix string) (err error) {
-	var buf [maxCharsOneLine]byte
-	var nRead int
+	var line string
 	for {
-		if nRead, err = p.r.ReadRaw(buf[:]); err != nil {
+		if line, err = p.r.readRawLine(); err != nil {
 			return
 		}
-		if strings.HasPrefix(string(buf[:nRead]), prefix) {
+		if strings.HasPrefix(line, prefix) {
 			return nil
 		}
 	}
 }
 
 func (p *Parser) parseIntLine() (res int64, err error) {
-	var buf [maxCharsOneLine]byte
-	var nRead int
-	if nRead, err = p.r.ReadRaw(buf[:]); err == nil {
-		res, err = strconv.ParseInt(string(buf[:nRead]), 10, 32)
+	var line string
+	if line, err = p.r.readRawLine(); err == nil {
+		res, err = strconv.ParseInt(line, 10, 32)
 	}
 	return
 }
 func (p *Parser) parseIntList() (res []int64, err error) {
-	var buf [maxCharsOneLine]byte
-	var nRead int
+	var line string
 	var i64 int64
-	if nRead, err = p.r.ReadRaw(buf[:]); err != nil {
+	if line, err = p.r.readRawLine(); err != nil {
 		return nil, err
 	}
-	fields := strings.Fields(string(buf[:nRead]))
+	fields := strings.Fields(line)
 	result := make([]int64, len(fields))
 	for i, s := range fields {
 		if i64, err = strconv.ParseInt(s, 10, 32); err != nil {
@@ -80,287 +317,259 @@ func (p *Parser) parseIntList() (res []int64, err error) {
 	}
 	return result, nil
 }
-func (p *Parser) parseByteCode() (err error) {
+func (p *Parser) parseByteCode() (bc *ByteCode, err error) {
+	var ints []int64
+	var locs []CommandLocation
+	bc = &ByteCode{}
 	//1. procedure struct info
-
-	if _, err = p.parseRawStringLine(); err != nil {
+	p.section = "info"
+	if ints, err = p.parseIntList(); err == nil {
+		bc.Info, err = newStructInfo(ints)
+	}
+	if err = p.recover(err); err != nil {
 		return
 	}
 	//2. ByteCode
-	if err = p.parseCode(); err != nil {
+	p.section = "code"
+	if bc.Code, err = p.parseCode(); err == nil && len(bc.Code) != bc.Info.NumCodeBytes {
+		err = fmt.Errorf("numCodeBytes is %d, but code has %d bytes", bc.Info.NumCodeBytes, len(bc.Code))
+	}
+	if err = p.recover(err); err != nil {
 		return
 	}
 	//3. CodeDelta
-	if err = p.parseCodeDelta(); err != nil {
+	p.section = "codeDelta"
+	bc.CodeDelta, err = p.parseCodeDelta()
+	if err = p.recover(err); err != nil {
 		return
 	}
 	//4. CodeLength
-	if err = p.parseCodeLength(); err != nil {
+	p.section = "codeLength"
+	bc.CodeLength, err = p.parseCodeLength()
+	if err = p.recover(err); err != nil {
 		return
 	}
-
-	//if dump all instruction
-	if p.Detail {
-		if err = p.parseDecompile(); err != nil {
-			return
-		}
+	if locs, err = bc.CommandLocations(); err == nil && len(locs) != bc.Info.NumCommands {
+		err = fmt.Errorf("numCommands is %d, but there are %d command locations", bc.Info.NumCommands, len(locs))
+	}
+	if err = p.recover(err); err != nil {
+		return
 	}
-
 	//5. ObjectArray
-	if err = p.parseObjectArray(); err != nil {
+	p.section = "literals"
+	if bc.Literals, err = p.parseObjectArray(bc); err == nil && len(bc.Literals) != bc.Info.NumLitObjects {
+		p.section = "literals"
+		err = fmt.Errorf("numLitObjects is %d, but there are %d literals", bc.Info.NumLitObjects, len(bc.Literals))
+	}
+	if err = p.recover(err); err != nil {
 		return
 	}
 	//6. ExcRangeArray
-	if err = p.parseExcRangeArray(); err != nil {
+	p.section = "exception ranges"
+	if bc.ExceptionRanges, err = p.parseExcRangeArray(); err == nil && len(bc.ExceptionRanges) != bc.Info.NumExceptRanges {
+		p.section = "exception ranges"
+		err = fmt.Errorf("numExceptRanges is %d, but there are %d exception ranges", bc.Info.NumExceptRanges, len(bc.ExceptionRanges))
+	}
+	if err = p.recover(err); err != nil {
 		return
 	}
-	//6. AuxDataArray
-	err = p.parseAuxDataArray()
+	//7. AuxDataArray
+	p.section = "aux data"
+	if bc.AuxData, err = p.parseAuxDataArray(); err == nil && len(bc.AuxData) != bc.Info.NumAuxDataItems {
+		p.section = "aux data"
+		err = fmt.Errorf("numAuxDataItems is %d, but there are %d aux data items", bc.Info.NumAuxDataItems, len(bc.AuxData))
+	}
+	err = p.recover(err)
 	return
 }
-func (p *Parser) parseObjectArray() (err error) {
+func (p *Parser) parseObjectArray(bc *ByteCode) (lits []*Literal, err error) {
 	var num int64
+	var lit *Literal
 	if num, err = p.parseIntLine(); err != nil {
-		return err
+		return
 	}
+	names := procNameLiterals(p.opTable, bc)
+	depth := len(p.path)
 	for index := 0; index < int(num); index++ {
-		//output
-		p.w.WriteString(fmt.Sprintf("[lit-%04d]", index))
-		if err = p.parseObject(); err != nil {
-			return err
+		p.section = fmt.Sprintf("literal %d", index)
+		var procSection string
+		if words, ok := names[index]; ok {
+			if name, ok := words.nameIn(lits); ok {
+				procSection = "proc " + name
+			}
 		}
-		p.w.WriteByte('\n')
+		if lit, err = p.parseObject(procSection); err != nil {
+			if p.recover(err) != nil {
+				return
+			}
+			//placeholder keeps index of following literals
+			p.path = p.path[:depth]
+			lit = &Literal{Type: LiteralString}
+			var more bool
+			if more, err = p.resync(literalTypes, err); err != nil || !more {
+				return append(lits, lit), err
+			}
+		}
+		lits = append(lits, lit)
 	}
 	return
 }
 func (p *Parser) parseObjectType() (c byte, err error) {
-	var buf [maxCharsOneLine]byte
-	if _, err = p.r.ReadRaw(buf[:]); err != nil {
+	var line string
+	if line, err = p.r.readRawLine(); err != nil {
 		return 0, err
 	}
-	return buf[0], err
+	if line == "" {
+		return 0, ErrUnsupoortedObjectType
+	}
+	return line[0], err
 }
 
+// literalTypes is types of literal object, for resync in Tolerant mode
+var literalTypes = string([]LiteralType{LiteralInt, LiteralDouble, LiteralString, LiteralXString, LiteralProc})
+
 // ErrUnsupoortedObjectType means object type is not correct
-var ErrUnsupoortedObjectType = errors.New("object type is not supported")
+var ErrUnsupoortedObjectType = errors.New("unsupported object type")
 
-func (p *Parser) parseObject() (err error) {
+// parseObject read one object, procSection is section of procedure object
+// instead of current one, e.g. "proc hello", if it is not ""
+func (p *Parser) parseObject(procSection string) (lit *Literal, err error) {
 	var objType byte
 	if objType, err = p.parseObjectType(); err != nil {
-		return err
+		return
 	}
-	switch objType {
-	case 'i', 'd', 's':
-		err = p.parseSimpleObject()
-	case 'x':
-		err = p.parseXStringObject()
-	case 'p':
-		err = p.parseProcedureObject()
+	lit = &Literal{Type: LiteralType(objType)}
+	switch lit.Type {
+	case LiteralInt, LiteralDouble, LiteralString:
+		lit.Value, err = p.parseSimpleObject()
+	case LiteralXString:
+		lit.Value, err = p.parseXStringObject()
+	case LiteralProc:
+		if procSection != "" {
+			p.section = procSection
+		}
+		lit.Proc, err = p.parseProcedureObject()
 	default:
-		err = ErrUnsupoortedObjectType
+		err = fmt.Errorf("%w '%c'", ErrUnsupoortedObjectType, objType)
 	}
 	return
 }
-func (p *Parser) parseSimpleObject() (err error) {
-	var buf [maxCharsOneLine]byte
-	var nRead int
-	if nRead, err = p.r.ReadRaw(buf[:]); err != nil {
-		return err
-	}
-
-	//output
-	p.w.Write(buf[:nRead])
-	return
+func (p *Parser) parseSimpleObject() (str string, err error) {
+	return p.parseRawStringLine()
 }
-func (p *Parser) parseXStringObject() (err error) {
-	var buf [20480]byte
-	var nRead int
-	var nLen int64
-	if nLen, err = p.parseIntLine(); err != nil {
-		return err
-	}
-	if nLen == 0 {
-		p.r.ReadRaw(buf[:])
-		return nil
-	}
-	if nRead, err = p.r.Read(buf[:]); err != nil {
-		return err
+func (p *Parser) parseXStringObject() (str string, err error) {
+	var buf []byte
+	if buf, err = p.parseBytes(); err != nil {
+		return
 	}
-
-	//output
-	p.w.Write(buf[:nRead])
-	return
+	return p.header.fromTclString(string(buf)), nil
 }
-func (p *Parser) parseProcedureObject() (err error) {
+func (p *Parser) parseProcedureObject() (proc *Procedure, err error) {
 	var lengths []int64
+	var local *CompiledLocal
 
-	p.w.WriteString("\n---procedure begin---\n")
+	proc = &Procedure{}
+	p.path = append(p.path, p.section)
 	//1. ByteCode
-	if err = p.parseByteCode(); err != nil {
+	if proc.ByteCode, err = p.parseByteCode(); err != nil {
 		return
 	}
+	p.section = "locals"
 	//2. numArgs numCompiledLocal
-	if lengths, err = p.parseIntList(); err != nil || len(lengths) != 2 {
+	if lengths, err = p.parseIntList(); err == nil && len(lengths) != 2 {
+		err = fmt.Errorf("procedure has %d fields of numArgs and numCompiledLocals, expected 2", len(lengths))
+	}
+	if err != nil {
 		return
 	}
+	proc.NumArgs = int(lengths[0])
 	//3. for-loop {CompiledLocal}
 	for index := 0; index < int(lengths[1]); index++ {
-		if err = p.parseCompiledLocal(); err != nil {
+		p.section = fmt.Sprintf("local %d", index)
+		if local, err = p.parseCompiledLocal(); err != nil {
 			return
 		}
+		proc.Locals = append(proc.Locals, local)
 	}
-	p.w.WriteString("\n---procedure end  ---")
+	p.path = p.path[:len(p.path)-1]
 	return
 }
-func (p *Parser) parseCompiledLocal() (err error) {
-	var sName string
+func (p *Parser) parseCompiledLocal() (local *CompiledLocal, err error) {
 	var ints []int64
+	local = &CompiledLocal{}
 	//1. name
-	if _, err = p.parseIntLine(); err != nil {
-		return
-	}
-	if sName, err = p.parseASCII85StringLine(); err != nil {
-		return
+	var name []byte
+	if name, err = p.parseBytes(); err != nil {
+		if err = p.recover(err); err != nil {
+			return
+		}
 	}
+	local.Name = p.header.fromTclString(string(name))
 	//2. index hasDef mask
-	if ints, err = p.parseIntList(); err != nil || len(ints) != 3 {
+	if ints, err = p.parseIntList(); err != nil {
 		return
 	}
-	ss := fmt.Sprintf("[local-%02d]name=%s,hasDefault=%d ", ints[0], sName, ints[1])
-	p.w.WriteString(ss)
+	if len(ints) != 3 {
+		return nil, fmt.Errorf("compiled local has %d fields of index, hasDefault and flags, expected 3", len(ints))
+	}
+	local.Index = int(ints[0])
+	local.Flags = int(ints[2])
 
 	//3. if (hasDef) Object
 	if ints[1] == 1 {
-		err = p.parseObject()
+		local.Default, err = p.parseObject("")
 	}
-	p.w.WriteByte('\n')
 	return
 }
-func (p *Parser) parseExcRangeArray() (err error) {
+func (p *Parser) parseExcRangeArray() (ranges []*ExceptionRange, err error) {
 	var nLen int64
+	var line string
+	var r *ExceptionRange
 	if nLen, err = p.parseIntLine(); err != nil {
 		return
 	}
 	for index := 0; index < int(nLen); index++ {
-		p.parseRawStringLine()
-	}
-	return
-}
-func (p *Parser) parseAuxDataArray() (err error) {
-	//TODO we dont support AuxData parser. later.
-	var num int64
-	if num, err = p.parseIntLine(); err != nil {
-		return
-	}
-	for index := 0; index < int(num); index++ {
-		//we only support CMP_FOREACH_INFO('F')
-		//and only for numLists=1,numVars=1
-		//F
-		//numLists firstValueTemp loopCtTemp
-		//numVars
-		//*varIndexesPtr
-		if _, err = p.parseRawStringLine(); err != nil {
-			return
-		}
-		if _, err = p.parseRawStringLine(); err != nil {
+		p.section = fmt.Sprintf("exception range %d", index)
+		if line, err = p.parseRawStringLine(); err != nil {
 			return
 		}
-		if _, err = p.parseRawStringLine(); err != nil {
+		if r, err = parseExceptionRange(line); err != nil {
 			return
 		}
-		if _, err = p.parseRawStringLine(); err != nil {
-			return
-		}
-	}
-	return err
-}
-
-func (p *Parser) parseCodeDelta() (err error) {
-	var buf [20480]byte
-	var nRead int
-	var nRes int64
-	if nRes, err = p.parseIntLine(); err != nil {
-		return
-	}
-	if nRead, err = p.r.Read(buf[:]); err != nil {
-		return
-	}
-	p.codeDelta.Reset()
-
-	if nRes > 0 && nRead > 0 {
-		s := hex.EncodeToString(buf[:nRead])
-		_, err = p.w.WriteString(s)
-		err = p.w.WriteByte('\n')
-
-		if nRead > int(nRes) {
-			nRead = int(nRes)
-		}
-		p.codeDelta.Write(buf[:nRead])
+		ranges = append(ranges, r)
 	}
 	return
 }
-func (p *Parser) parseCodeLength() (err error) {
-	var buf [20480]byte
-	var nRead int
-	var nRes int64
-	if nRes, err = p.parseIntLine(); err != nil {
-		return
-	}
-	if nRead, err = p.r.Read(buf[:]); err != nil {
-		return
-	}
-
-	p.codeLength.Reset()
-
-	if nRes > 0 && nRead > 0 {
-		s := hex.EncodeToString(buf[:nRead])
-		_, err = p.w.WriteString(s)
-		err = p.w.WriteByte('\n')
-
-		if nRead > int(nRes) {
-			nRead = int(nRes)
-		}
-		p.codeLength.Write(buf[:nRead])
-	}
-	return
+func (p *Parser) parseCodeDelta() (res []byte, err error) {
+	return p.parseBytes()
+}
+func (p *Parser) parseCodeLength() (res []byte, err error) {
+	return p.parseBytes()
+}
+func (p *Parser) parseCode() (res []byte, err error) {
+	return p.parseBytes()
 }
 
-func (p *Parser) parseCode() (err error) {
-	var buf [2048000]byte
-	var nRead int
+// parseBytes read length line and ascii85 encoded bytes of the length,
+// returns nil if length is 0
+func (p *Parser) parseBytes() (res []byte, err error) {
 	var nRes int64
 	if nRes, err = p.parseIntLine(); err != nil {
 		return
 	}
-	if nRead, err = p.r.Read(buf[:]); err != nil {
-		return
-	}
-	p.codeBytes.Reset()
-
-	if nRes > 0 && nRead > 0 {
-		s := hex.EncodeToString(buf[:nRead])
-		_, err = p.w.WriteString(s)
-		err = p.w.WriteByte('\n')
-
-		if nRead > int(nRes) {
-			nRead = int(nRes)
-		}
-		p.codeBytes.Write(buf[:nRead])
+	if res, err = p.r.ReadSection(int(nRes)); len(res) == 0 {
+		res = nil
 	}
 	return
 }
 
 // only conver asci85 to hex printing.
 func (p *Parser) parseHex() (err error) {
-	var buf [20480]byte
-	var nRead int
-	if _, err = p.parseIntLine(); err != nil {
-		return
-	}
-	if nRead, err = p.r.Read(buf[:]); err != nil {
+	var buf []byte
+	if buf, err = p.parseBytes(); err != nil {
 		return
 	}
-	s := hex.EncodeToString(buf[:nRead])
+	s := hex.EncodeToString(buf)
 	_, err = p.w.WriteString(s)
 	err = p.w.WriteByte('\n')
 	// if isCode {
@@ -369,165 +578,151 @@ func (p *Parser) parseHex() (err error) {
 	return
 }
 
-func parseOperand(src []byte, operandType byte) (res string, nextSrc []byte, err error) {
-	var nLen = 0
-	switch operandType {
-	case OPERAND_NONE:
-		nLen = 0
-	case OPERAND_INT1, OPERAND_LVT1, OPERAND_OFFSET1:
-		/* One byte signed integer. */
-		res = strconv.Itoa(int(int8(src[0])))
-		nLen = 1
-	case OPERAND_UINT1, OPERAND_LIT1, OPERAND_SCLS1:
-		/* One byte unsigned integer. */
-		res = strconv.Itoa(int(uint8(src[0])))
-		nLen = 1
-
-	case OPERAND_INT4, OPERAND_IDX4, OPERAND_OFFSET4:
-		/* Four byte signed integer. */
-		var i int32
-		nLen = 4
-		buf := bytes.NewBuffer(src[:nLen])
-		err = binary.Read(buf, binary.BigEndian, &i)
-		res = strconv.Itoa(int(i))
-
-	case OPERAND_UINT4, OPERAND_LVT4, OPERAND_AUX4, OPERAND_LIT4:
-		/* Four byte unsigned integer. */
-		var i uint32
-		nLen = 4
-		buf := bytes.NewBuffer(src[:nLen])
-		err = binary.Read(buf, binary.BigEndian, &i)
-		res = strconv.Itoa(int(i))
-		//BUG? int(uint32(i))?
-	}
-
-	return res, src[nLen:], err
-}
-
-func paresOneOp(src []byte) (res string, numBytes int, err error) {
-	var b strings.Builder
-	var str string
-	opInt := int(src[0])
-	if opInt >= len(tclOpTable) {
-		return "", 1, os.ErrNotExist
+func paresOneOp(opTable []InstructionDesc, src []byte) (res string, numBytes int, err error) {
+	var ins Instruction
+	if ins, err = decodeInstruction(opTable, src, 0); err != nil {
+		return "", 1, err
 	}
-	opName := tclOpTable[opInt].name
-	bytes := tclOpTable[opInt].numBytes
-	numOperands := tclOpTable[opInt].numOperands
-	op1 := tclOpTable[opInt].opTypes[0]
-	op2 := tclOpTable[opInt].opTypes[1]
-	b.WriteString(opName)
-	src = src[1:]
+	return ins.String(), ins.Size, nil
+}
+
+func (p *Parser) dumpByteCode(bc *ByteCode, locals []*CompiledLocal) (err error) {
+	p.w.WriteString(fmt.Sprintf("[info]%s\n", bc.Info))
+	p.dumpHex(bc.Code)
+	p.dumpHex(bc.CodeDelta)
+	p.dumpHex(bc.CodeLength)
 
-	if numOperands > 0 {
-		if str, src, err = parseOperand(src, op1); err != nil {
-			return b.String(), bytes, err
+	//if dump all instruction
+	if p.Detail {
+		if err = p.dumpInstructions(bc, locals); err != nil {
+			return
 		}
-		b.WriteByte(' ')
-		b.WriteString(str)
 	}
-
-	if numOperands > 1 {
-		if str, _, err = parseOperand(src, op2); err != nil {
-			return b.String(), bytes, err
+	for index, lit := range bc.Literals {
+		p.w.WriteString(fmt.Sprintf("[lit-%04d]", index))
+		if lit.Type == LiteralProc {
+			p.dumpProcedure(lit.Proc, procName(p.opTable, bc, index))
+		} else {
+			p.dumpLiteral(lit)
 		}
-		b.WriteByte(' ')
-		b.WriteString(str)
+		p.w.WriteByte('\n')
+	}
+	for index, r := range bc.ExceptionRanges {
+		p.w.WriteString(fmt.Sprintf("[range-%02d]%s\n", index, r))
+	}
+	for index, aux := range bc.AuxData {
+		p.w.WriteString(fmt.Sprintf("[aux-%02d]%s\n", index, aux))
+	}
+	return
+}
+func (p *Parser) dumpHex(b []byte) {
+	if len(b) > 0 {
+		p.w.WriteString(hex.EncodeToString(b))
+		p.w.WriteByte('\n')
+	}
+}
+func (p *Parser) dumpLiteral(lit *Literal) {
+	if lit.Type == LiteralProc {
+		p.dumpProcedure(lit.Proc, "")
+		return
 	}
-	return b.String(), bytes, err
+	p.w.WriteString(lit.Value)
+}
 
+// dumpProcedure write proc, with skeleton as "[proc]proc name {a {b 1} args} {...}",
+// name is written as "?" if it is unknown
+func (p *Parser) dumpProcedure(proc *Procedure, name string) {
+	p.w.WriteString("\n---procedure begin---\n")
+	p.dumpProcSkeleton(proc, name)
+	p.dumpByteCode(proc.ByteCode, proc.Locals)
+	p.dumpLocals(proc.Locals)
+	p.w.WriteString("\n---procedure end  ---")
 }
 
-func (p *Parser) parseDecompile() (err error) {
-	var str string
-	var bytes int
-	var totalBytes int
-	src := p.codeBytes.Bytes()
-	codeDelta := p.codeDelta.Bytes()
-	codeLength := p.codeLength.Bytes()
+func (p *Parser) dumpProcSkeleton(proc *Procedure, name string) {
+	if name != "" {
+		name = quoteWord(name)
+	} else {
+		name = "?"
+	}
+	p.w.WriteString(fmt.Sprintf("[proc]proc %s %s {...}\n", name, quoteWord(proc.ArgList())))
+}
 
-	numCmds := len(codeDelta)
-	indexCmds := 0
-	cmdBegin := true
-	var cmdBytes, cmdDelta int
+func (p *Parser) dumpLocals(locals []*CompiledLocal) {
+	for _, local := range locals {
+		hasDefault := 0
+		if local.Default != nil {
+			hasDefault = 1
+		}
+		p.w.WriteString(fmt.Sprintf("[local-%02d]name=%s,hasDefault=%d ", local.Index, local.Name, hasDefault))
+		if local.Default != nil {
+			p.dumpLiteral(local.Default)
+		}
+		p.w.WriteByte('\n')
+	}
+}
 
-	if len(src) == 0 {
+func (p *Parser) dumpInstructions(bc *ByteCode, locals []*CompiledLocal) (err error) {
+	var locs []CommandLocation
+	var instructions []Instruction
+	if locs, err = bc.CommandLocations(); err != nil {
 		return
 	}
-	for len(src) > 0 {
-		if str, bytes, err = paresOneOp(src); err != nil {
-			return err
-		}
-		//1. print command title: command %d,pc=xx-xx
-		if numCmds > 0 && indexCmds < (numCmds) {
-
-			if cmdBegin {
-				cmdBytes = bytes
-				cmdBegin = false
-				//BUG,FIXME, we dont consider codeDelta = 0xFF 4bytes case
-				samePCforCmds := true
-
-				for samePCforCmds {
-
-					//cmdDelta = int(p.codeDelta[indexCmds+1])
-
-					p.w.WriteString(fmt.Sprintf("\tCommand %d", indexCmds))
-					if indexCmds < len(codeLength) {
-						//BUG,FIXME, we dont consider codeLength = 0xFF 4bytes case
-						p.w.WriteString(fmt.Sprintf(",pc= %d-%d", totalBytes, totalBytes+int(codeLength[indexCmds])-1))
-					}
-					p.w.WriteByte('\n')
-
-					indexCmds++
-					//cmdDelta 是下一条Command 相对于当前Comand的bytes偏移量
-					//如果是最后一条命令，cmdDelta赋值MAX
-					if indexCmds < len(codeDelta) {
-						cmdDelta = int(codeDelta[indexCmds])
-					} else {
-						cmdDelta = math.MaxInt32
-					}
-
-					if cmdDelta != 0 {
-						samePCforCmds = false
-					}
-				}
-
-				if cmdBytes >= cmdDelta {
-					cmdBegin = true
-				}
-
-			} else {
-				cmdBytes += bytes
-				if cmdBytes >= cmdDelta {
-					cmdBegin = true
-				}
-			}
+	if instructions, err = disassemble(p.opTable, bc.Code); err != nil {
+		return
+	}
+	exprs := exprNotes(p.opTable, bc, locals, instructions)
+	indexCmds := 0
+	for _, ins := range instructions {
+		//1. print exception range marks
+		p.dumpRangeMarks(bc, ins.PC)
+
+		//2. print command title: command %d,pc=xx-xx
+		for ; indexCmds < len(locs) && locs[indexCmds].CodeOffset <= ins.PC; indexCmds++ {
+			loc := locs[indexCmds]
+			p.w.WriteString(fmt.Sprintf("\tCommand %d,pc= %d-%d\n", indexCmds, loc.CodeOffset, loc.CodeOffset+loc.CodeLength-1))
 		}
 
-		//2. print command instruction
-		p.w.WriteString(fmt.Sprintf("\t(%d)", totalBytes))
-		p.w.WriteString(str)
+		//3. print command instruction
+		p.w.WriteString(fmt.Sprintf("\t(%d)%s", ins.PC, ins))
+		note := annotate(&ins, bc, locals)
+		if expr, ok := exprs[ins.PC]; ok {
+			note = expr
+		}
+		if note != "" {
+			p.w.WriteString("\t# ")
+			p.w.WriteString(note)
+		}
 		p.w.WriteByte('\n')
-		src = src[bytes:]
-		totalBytes += bytes
 	}
+	p.dumpRangeMarks(bc, len(bc.Code))
 	return
 }
 
-func (p *Parser) parseRawStringLine() (str string, err error) {
-	var buf [maxCharsOneLine]byte
-	var nRead int
-	if nRead, err = p.r.ReadRaw(buf[:]); err != nil {
-		return "", err
+// dumpRangeMarks print end, handler target and begin of exception ranges at pc
+func (p *Parser) dumpRangeMarks(bc *ByteCode, pc int) {
+	for index, r := range bc.ExceptionRanges {
+		if r.End() == pc {
+			p.w.WriteString(fmt.Sprintf("\t[range-%02d end]\n", index))
+		}
+	}
+	for index, r := range bc.ExceptionRanges {
+		switch {
+		case r.Type == CatchExceptionRange && r.CatchOffset == pc:
+			p.w.WriteString(fmt.Sprintf("\t[range-%02d catch]\n", index))
+		case r.Type == LoopExceptionRange && r.BreakOffset == pc:
+			p.w.WriteString(fmt.Sprintf("\t[range-%02d break]\n", index))
+		case r.Type == LoopExceptionRange && r.ContinueOffset == pc:
+			p.w.WriteString(fmt.Sprintf("\t[range-%02d continue]\n", index))
+		}
+	}
+	for index, r := range bc.ExceptionRanges {
+		if r.CodeOffset == pc && pc < len(bc.Code) {
+			p.w.WriteString(fmt.Sprintf("\t[range-%02d begin]%s\n", index, r))
+		}
 	}
-	return string(buf[:nRead]), err
 }
 
-func (p *Parser) parseASCII85StringLine() (str string, err error) {
-	var buf [maxCharsOneLine]byte
-	var nRead int
-	if nRead, err = p.r.Read(buf[:]); err != nil {
-		return "", err
-	}
-	return string(buf[:nRead]), err
+func (p *Parser) parseRawStringLine() (str string, err error) {
+	return p.r.readRawLine()
 }
@@ -1,10 +1,15 @@
 package tbcload
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -87,3 +92,125 @@ func TestSingleFile(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestParseError(t *testing.T) {
+	src, err := os.ReadFile("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(src), "\n")
+	for _, v := range []struct {
+		line    int //line to replace, from 1
+		text    string
+		section string
+		path    string
+		cause   error
+		message string
+	}{
+		{31, "b", "literal 1", "proc hello", ErrUnsupoortedObjectType, "31: proc hello: literal 1: unsupported object type 'b'"},
+		{7, "1x", "code", "", strconv.ErrSyntax, ""},
+		{5, "TclPro ByteCode 2 0 1.0", "header", "", nil, ""},
+		{43, "x", "aux data", "", strconv.ErrSyntax, ""},
+		{38, "njk{A", "local 0", "proc hello", ErrDecodeChar, ""},
+		{39, "0 0", "local 0", "proc hello", nil, "39: proc hello: local 0: compiled local has 2 fields of index, hasDefault and flags, expected 3"},
+	} {
+		bad := append([]string(nil), lines...)
+		bad[v.line-1] = v.text
+		_, err := ReadFile(strings.NewReader(strings.Join(bad, "\n")))
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("line %d: expected ParseError, got %v", v.line, err)
+			continue
+		}
+		if perr.Line != v.line || perr.Section != v.section || strings.Join(perr.Path, ": ") != v.path {
+			t.Errorf("line %d: wrong position %d, %q, %q", v.line, perr.Line, perr.Section, perr.Path)
+		}
+		if v.cause != nil && !errors.Is(err, v.cause) {
+			t.Errorf("line %d: expected cause %v, got %v", v.line, v.cause, perr.Err)
+		}
+		if v.message != "" && err.Error() != v.message {
+			t.Errorf("line %d: expected %q, got %q", v.line, v.message, err)
+		}
+	}
+}
+
+func TestParseTolerant(t *testing.T) {
+	src, err := os.ReadFile("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(src), "\n")
+	for _, v := range []struct {
+		line  int //line to replace, from 1
+		text  string
+		diags []string
+		world bool //top level literal 4 is read
+	}{
+		{31, "b", []string{"31: proc hello: literal 1: unsupported object type 'b'"}, true},
+		{38, "nj{SA", []string{"38: proc hello: local 0: error decoding from bytes: illegal char in encoded text: '{' at 2"}, true},
+		{8, "w0E<!(H&s!/HW{!-r=pv#!!", []string{"8: code: error decoding from bytes: illegal char in encoded text: '{' at 13"}, true},
+		{43, "b", []string{"43: aux data: strconv.ParseInt: parsing \"b\": invalid syntax"}, true},
+		{26, "", []string{"25: proc hello: codeLength: EOF"}, false},
+	} {
+		bad := append([]string(nil), lines...)
+		bad[v.line-1] = v.text
+		if v.text == "" {
+			bad = bad[:v.line-1]
+		}
+		text := strings.Join(bad, "\n")
+		if _, err = ReadFile(strings.NewReader(text)); err == nil {
+			t.Errorf("line %d: expected error without Tolerant", v.line)
+		}
+		p := NewParser(strings.NewReader(text), io.Discard)
+		p.Tolerant = true
+		f, err := p.ReadFile()
+		if err != nil {
+			t.Errorf("line %d: %v", v.line, err)
+			continue
+		}
+		var diags []string
+		for _, d := range p.Diagnostics {
+			diags = append(diags, d.Error())
+		}
+		if strings.Join(diags, "\n") != strings.Join(v.diags, "\n") {
+			t.Errorf("line %d: expected diagnostics %q, got %q", v.line, v.diags, diags)
+		}
+		lits := f.ByteCode.Literals
+		if world := len(lits) == 5 && lits[4].Value == "world"; world != v.world {
+			t.Errorf("line %d: expected literal world %v, got %d literals", v.line, v.world, len(lits))
+		}
+	}
+}
+
+func TestParseErrorBlock(t *testing.T) {
+	src, err := os.ReadFile("testdata/blocks.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := strings.Replace(string(src), "\nx\n", "\nb\n", 1)
+	_, err = ReadFile(strings.NewReader(bad))
+	if expected := "37: block 1: proc greet: literal 3: unsupported object type 'b'"; err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
+
+func TestParseErrorNotProc(t *testing.T) {
+	//foo a b cc, literal 3 is not body of proc
+	code := assemble(tcl80OpTable, "push1 0", "push1 1", "push1 2", "push1 3", "invokeStk1 4", "done")
+	f := &File{Block: Block{ByteCode: &ByteCode{Code: code, Literals: stringLiterals("foo", "a", "b", "cc"),
+		Info: StructInfo{NumCodeBytes: len(code), NumLitObjects: 4}}}}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteFile(f); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	index := len(lines) - 1
+	for lines[index] != "cc" {
+		index--
+	}
+	lines[index-1] = "b"
+	_, err := ReadFile(strings.NewReader(strings.Join(lines, "\n")))
+	if expected := fmt.Sprintf("%d: literal 3: unsupported object type 'b'", index); err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+}
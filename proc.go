@@ -0,0 +1,124 @@
+package tbcload
+
+import "fmt"
+
+// ProcDef is procedure defined by "proc name args body" in bytecode,
+// where body is a procedure literal
+type ProcDef struct {
+	Name string //name of procedure
+	Args string //argument list as written in source
+	Proc *Procedure
+}
+
+// Procs return procedures defined in f, by bcproc blocks or proc commands,
+// including procedures defined in body of procedures. Instructions are
+// decoded by opcode table of tclVersion, or of Header of block if it is ""
+func (f *File) Procs(tclVersion string) (defs []ProcDef, err error) {
+	for _, b := range f.Blocks() {
+		var opTable []InstructionDesc
+		if opTable, err = fileOpTable(b.Header, tclVersion); err != nil {
+			return nil, err
+		}
+		if b.Command == BlockProc {
+			defs = append(defs, ProcDef{Name: b.Name, Args: b.Args, Proc: b.Proc})
+		}
+		var found []ProcDef
+		if found, err = findProcDefs(opTable, b.ByteCode); err != nil {
+			return nil, err
+		}
+		defs = append(defs, found...)
+	}
+	return
+}
+
+// ArgList return argument list of proc reconstructed from NumArgs and
+// first compiled locals, e.g. "a {b default} args"
+func (proc *Procedure) ArgList() string {
+	var args []string
+	for index := 0; index < proc.NumArgs && index < len(proc.Locals); index++ {
+		local := proc.Locals[index]
+		//"args" is last argument, which is flagged since Tcl 8.4
+		isArgs := index == proc.NumArgs-1 && (local.Flags&varIsArgs != 0 || local.Name == "args")
+		if local.Default != nil && !isArgs {
+			args = append(args, formatList([]string{local.Name, local.Default.Value}))
+		} else {
+			args = append(args, local.Name)
+		}
+	}
+	return formatList(args)
+}
+
+// procName return name of procedure literal index of bc,
+// which is defined by "proc name args body", "" if it is unknown
+func procName(opTable []InstructionDesc, bc *ByteCode, index int) string {
+	if words, ok := procNameLiterals(opTable, bc)[index]; ok {
+		name, _ := words.nameIn(bc.Literals)
+		return name
+	}
+	return ""
+}
+
+// procWords is index of command and name literal of "proc name args body"
+type procWords struct {
+	cmd, name int
+}
+
+// nameIn return name literal of lits, false if command literal is not "proc"
+func (w procWords) nameIn(lits []*Literal) (string, bool) {
+	if w.cmd >= len(lits) || w.name >= len(lits) || lits[w.cmd].Value != "proc" {
+		return "", false
+	}
+	return lits[w.name].Value, true
+}
+
+// procNameLiterals return procWords by index of body literal,
+// of "push proc; push name; push args; push body; invokeStk 4" in bc.Code
+func procNameLiterals(opTable []InstructionDesc, bc *ByteCode) map[int]procWords {
+	words := map[int]procWords{}
+	code, _ := disassemble(opTable, bc.Code)
+	for index := 4; index < len(code); index++ {
+		if ins := code[index]; !(ins.Name == "invokeStk1" || ins.Name == "invokeStk4") || ins.Operands[0] != 4 {
+			continue
+		}
+		cmd, name, body := code[index-4], code[index-3], code[index-1]
+		if isPush(&cmd) && isPush(&name) && isPush(&body) {
+			words[body.Operands[0]] = procWords{cmd: cmd.Operands[0], name: name.Operands[0]}
+		}
+	}
+	return words
+}
+
+// findProcDefs find "push proc; push name; push args; push body; invokeStk 4"
+func findProcDefs(opTable []InstructionDesc, bc *ByteCode) (defs []ProcDef, err error) {
+	var code []Instruction
+	if code, err = disassemble(opTable, bc.Code); err != nil {
+		return
+	}
+	for index := 4; index < len(code); index++ {
+		if ins := code[index]; !(ins.Name == "invokeStk1" || ins.Name == "invokeStk4") || ins.Operands[0] != 4 {
+			continue
+		}
+		var words []*Literal
+		for _, ins := range code[index-4 : index] {
+			if ins.Name != "push1" && ins.Name != "push4" || ins.Operands[0] >= len(bc.Literals) {
+				break
+			}
+			words = append(words, bc.Literals[ins.Operands[0]])
+		}
+		if len(words) == 4 && words[0].Value == "proc" && words[3].Type == LiteralProc {
+			defs = append(defs, ProcDef{Name: words[1].Value, Args: words[2].Value, Proc: words[3].Proc})
+		}
+	}
+	//procedures defined in procedures
+	for _, lit := range bc.Literals {
+		if lit.Type != LiteralProc {
+			continue
+		}
+		var nested []ProcDef
+		if nested, err = findProcDefs(opTable, lit.Proc.ByteCode); err != nil {
+			return nil, fmt.Errorf("procedure body: %w", err)
+		}
+		defs = append(defs, nested...)
+	}
+	return
+}
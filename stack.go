@@ -0,0 +1,208 @@
+package tbcload
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStackDepth means stack depth of bytecode is inconsistent,
+// the .tbc file may be corrupted or tampered
+var ErrStackDepth = errors.New("stack depth is inconsistent")
+
+// stackState is stack depth at entry of a basic block
+type stackState struct {
+	depth  int
+	expand []int //depths at expandStart, innermost last
+}
+
+func (s stackState) equal(o stackState) bool {
+	if s.depth != o.depth || len(s.expand) != len(o.expand) {
+		return false
+	}
+	for index := range s.expand {
+		if s.expand[index] != o.expand[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// StackDepth simulate stack depth through g, return maximum depth.
+// error wraps ErrStackDepth for each stack underflow, or block reached
+// by paths of different depths
+func (g *CFG) StackDepth() (maxDepth int, err error) {
+	var errs []error
+	fail := func(pc int, format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf("%w: pc %d: %s", ErrStackDepth, pc, fmt.Sprintf(format, a...)))
+	}
+	if len(g.Blocks) == 0 {
+		return
+	}
+	entry := make([]*stackState, len(g.Blocks))
+	entry[0] = &stackState{}
+	blockAt := map[int]int{}
+	for _, b := range g.Blocks {
+		blockAt[b.Start] = b.Index
+	}
+	reach := func(from *BasicBlock, to int, s stackState, work *[]int) {
+		if entry[to] == nil {
+			entry[to] = &s
+			*work = append(*work, to)
+		} else if !entry[to].equal(s) {
+			fail(g.Blocks[to].Start, "depth %d from pc %d, expected %d", s.depth, from.Instructions[len(from.Instructions)-1].PC, entry[to].depth)
+		}
+	}
+
+	for work := []int{0}; len(work) > 0; {
+		b := g.Blocks[work[0]]
+		work = work[1:]
+		s := *entry[b.Index]
+		s.expand = append([]int(nil), s.expand...)
+		if s.depth > maxDepth {
+			maxDepth = s.depth
+		}
+		for index := range b.Instructions {
+			ins := &b.Instructions[index]
+			pops, pushes := g.stackEffect(ins, &s)
+			if pops > s.depth {
+				fail(ins.PC, "%s pops %d, stack depth is %d", ins.Name, pops, s.depth)
+				pops = s.depth
+			}
+			s.depth += pushes - pops
+			if s.depth > maxDepth {
+				maxDepth = s.depth
+			}
+		}
+		for _, e := range g.Succs(b.Index) {
+			if e.Kind == CatchEdge {
+				//stack is restored to depth at beginning of catch range
+				r := g.innerRange(b.Start, CatchExceptionRange)
+				if start, ok := blockAt[r.CodeOffset]; ok && entry[start] != nil {
+					reach(b, e.To, *entry[start], &work)
+				}
+				continue
+			}
+			reach(b, e.To, s, &work)
+		}
+	}
+	return maxDepth, errors.Join(errs...)
+}
+
+// stackEffect return number of values popped and pushed by ins,
+// variable effects are computed from operands
+func (g *CFG) stackEffect(ins *Instruction, s *stackState) (pops, pushes int) {
+	switch ins.Name {
+	case "strcat", "concat1", "invokeStk1", "invokeStk4", "callFunc1", "list", "lindexMulti",
+		"lsetFlat", "dictUnset", "tailcall", "concatStk", "tclooNext", "tclooNextClass":
+		return ins.Operands[0], 1
+	case "dictGet", "dictSet", "dictExists", "invokeReplace":
+		return ins.Operands[0] + 1, 1
+	case "callBuiltinFunc1":
+		if ins.Operands[0] < len(builtinFuncs) {
+			return builtinFuncs[ins.Operands[0]].numArgs, 1
+		}
+	case "over":
+		//push copy of value at depth operand
+		return ins.Operands[0] + 1, ins.Operands[0] + 2
+	case "reverse":
+		return ins.Operands[0], ins.Operands[0]
+	case "expandStart":
+		s.expand = append(s.expand, s.depth)
+		return 0, 0
+	case "invokeExpanded", "expandDrop":
+		if len(s.expand) == 0 {
+			return 0, 0
+		}
+		mark := s.expand[len(s.expand)-1]
+		s.expand = s.expand[:len(s.expand)-1]
+		if ins.Name == "invokeExpanded" {
+			pushes = 1
+		}
+		if s.depth < mark {
+			return s.depth, pushes
+		}
+		return s.depth - mark, pushes
+	case "foreach_end":
+		//value lists, iterator and info
+		if info := g.foreachInfo(ins); info != nil {
+			return len(info.VarLists) + 2, 0
+		}
+	}
+	effect := ins.desc.stackEffect
+	if effect == INT_MIN {
+		effect = 0
+	}
+	if effect < 0 {
+		return -effect, 0
+	}
+	return 0, effect
+}
+
+// foreachInfo return ForeachInfo of loop ending at ins, by the nearest foreach_start before it
+func (g *CFG) foreachInfo(ins *Instruction) (info *ForeachInfo) {
+	for _, b := range g.Blocks {
+		for index := range b.Instructions {
+			i := &b.Instructions[index]
+			if i.PC >= ins.PC {
+				return
+			}
+			if i.Name == "foreach_start" && i.Operands[0] < len(g.bc.AuxData) {
+				info = g.bc.AuxData[i.Operands[0]].Foreach
+			}
+		}
+	}
+	return
+}
+
+// VerifyStack check stack depth of bc by CFG, with opcode table of tclVersion, e.g. "8.3".
+// error wraps ErrStackDepth if stack underflows, depths are different at merge,
+// or maximum depth exceeds maxStackDepth of structure info
+func VerifyStack(bc *ByteCode, tclVersion string) error {
+	g, err := NewCFG(bc, nil, tclVersion)
+	if err != nil {
+		return err
+	}
+	return g.verifyStack()
+}
+
+func (g *CFG) verifyStack() error {
+	maxDepth, err := g.StackDepth()
+	if maxDepth > g.bc.Info.MaxStackDepth {
+		err = errors.Join(err, fmt.Errorf("%w: maximum depth %d exceeds maxStackDepth %d", ErrStackDepth, maxDepth, g.bc.Info.MaxStackDepth))
+	}
+	return err
+}
+
+// VerifyStack check stack depth of bytecode of blocks and procedure bodies of f,
+// by opcode table of tclVersion, or of Header of block if it is ""
+func (f *File) VerifyStack(tclVersion string) error {
+	for index, b := range f.Blocks() {
+		opTable, err := fileOpTable(b.Header, tclVersion)
+		if err != nil {
+			return err
+		}
+		if err = verifyStack(opTable, b.ByteCode); err != nil {
+			if index > 0 {
+				err = fmt.Errorf("block %d: %w", index, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyStack(opTable []InstructionDesc, bc *ByteCode) error {
+	g, err := newCFG(opTable, bc, nil)
+	if err != nil {
+		return err
+	}
+	errs := []error{g.verifyStack()}
+	for index, lit := range bc.Literals {
+		if lit.Type == LiteralProc {
+			if err = verifyStack(opTable, lit.Proc.ByteCode); err != nil {
+				errs = append(errs, fmt.Errorf("procedure of literal %d: %w", index, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,51 @@
+package tbcload
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyStack(t *testing.T) {
+	for _, fileName := range []string{"testdata/hello.tbc", "testdata/catch.tbc", "testdata/foreach.tbc"} {
+		f := readTestFile(t, fileName)
+		if err := f.VerifyStack(""); err != nil {
+			t.Errorf("%s: %s", fileName, err)
+		}
+	}
+	//tampered maxStackDepth
+	f := readTestFile(t, "testdata/catch.tbc")
+	f.ByteCode.Info.MaxStackDepth = 1
+	if err := f.VerifyStack(""); !errors.Is(err, ErrStackDepth) {
+		t.Errorf("expected ErrStackDepth, got %v", err)
+	}
+}
+
+func TestStackDepth(t *testing.T) {
+	for _, v := range []struct {
+		code     []string
+		maxDepth int
+		err      string
+	}{
+		//puts [list a b c]
+		{[]string{"push1 0", "push1 0", "push1 0", "push1 0", "list 3", "invokeStk1 2", "done"}, 4, ""},
+		//puts {*}$l
+		{[]string{"expandStart", "push1 0", "loadScalar1 0", "expandStkTop 2", "invokeExpanded", "done"}, 2, ""},
+		{[]string{"push1 0", "invokeStk1 2", "done"}, 1, "pc 2: invokeStk1 pops 2, stack depth is 1"},
+		//if {$x} {push a} else {push a; push a}
+		{[]string{"loadScalar1 0", "jumpFalse1 6", "push1 0", "jump1 6", "push1 0", "push1 0", "done"}, 2, "pc 12: depth"},
+	} {
+		bc := &ByteCode{Literals: stringLiterals("a"), Code: assemble(tcl86OpTable, v.code...)}
+		g, err := NewCFG(bc, nil, "8.6")
+		if err != nil {
+			t.Fatal(err)
+		}
+		maxDepth, err := g.StackDepth()
+		if maxDepth != v.maxDepth {
+			t.Errorf("%v: expected depth %d, got %d", v.code, v.maxDepth, maxDepth)
+		}
+		if v.err == "" && err != nil || v.err != "" && (err == nil || !strings.Contains(err.Error(), v.err)) {
+			t.Errorf("%v: expected error %q, got %v", v.code, v.err, err)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package tbcload
+
+import (
+	"sort"
+	"strings"
+)
+
+// ifClause is one condition and body of if command
+type ifClause struct {
+	cond string //expression, without braces
+	body []string
+}
+
+// ifCommand is if/elseif/else, kept to merge "else {if ...}" into elseif
+type ifCommand struct {
+	clauses  []ifClause
+	elseBody []string //nil if there is no else
+}
+
+func (c *ifCommand) String() string {
+	var b strings.Builder
+	for index, clause := range c.clauses {
+		if index == 0 {
+			b.WriteString("if {")
+		} else {
+			b.WriteString(" elseif {")
+		}
+		b.WriteString(clause.cond)
+		b.WriteString("} ")
+		b.WriteString(scriptBody(clause.body))
+	}
+	if c.elseBody != nil {
+		b.WriteString(" else ")
+		b.WriteString(scriptBody(c.elseBody))
+	}
+	return b.String()
+}
+
+// scriptBody return commands as script in braces, one command each line
+func scriptBody(lines []string) string {
+	if len(lines) == 0 {
+		return "{}"
+	}
+	return "{\n" + indentLines(lines) + "}"
+}
+
+// scriptWord return commands as script in braces, in one line if possible
+func scriptWord(lines []string) string {
+	if len(lines) == 1 && !strings.Contains(lines[0], "\n") {
+		return "{" + lines[0] + "}"
+	}
+	return scriptBody(lines)
+}
+
+// control recover control structure begin at code[index],
+// return index of next instruction, or false if it is not recognized
+func (fr *frame) control(index, to int) (int, bool) {
+	ins := &fr.code[index]
+	switch ins.Name {
+	case "startCommand", "nop":
+		return index + 1, true
+	case "break", "continue":
+		fr.addLine(ins.Name)
+		return index + 1, true
+	case "jump1", "jump4":
+		if loop := fr.innerLoop(); loop != nil {
+			switch fr.target(ins) {
+			case loop.BreakOffset:
+				fr.addLine("break")
+				return index + 1, true
+			case loop.ContinueOffset:
+				fr.addLine("continue")
+				return index + 1, true
+			}
+		}
+		return fr.bottomTestedLoop(index, to)
+	case "jumpFalse1", "jumpFalse4", "jumpTrue1", "jumpTrue4":
+		if fr.shortValue != nil && fr.target(ins) == fr.shortPC {
+			return fr.shortCircuitRest(index, to), true
+		}
+		if next, ok := fr.topTestedLoop(index, to); ok {
+			return next, true
+		}
+		if next, ok := fr.shortCircuit(index, to); ok {
+			return next, true
+		}
+		return fr.ifElse(index, to)
+	case "foreach_start4":
+		return fr.foreach(index, to)
+	case "beginCatch4":
+		return fr.catch(index, to)
+	case "jumpTable":
+		return fr.switchTable(index, to)
+	}
+	return 0, false
+}
+
+// block decompile code[from:to] in a new stack,
+// the value left on stack is the last command
+func (fr *frame) block(from, to int, loop *ExceptionRange) []string {
+	sub := fr.sub()
+	if loop != nil {
+		sub.loops = append(sub.loops, loop)
+	}
+	sub.run(from, to)
+	if len(sub.stack) > 0 {
+		sub.emit(sub.pop())
+	}
+	return sub.lines
+}
+
+// blockResult decompile code[from:to] in a new stack,
+// return commands and the value left on stack, which is nil if not any
+func (fr *frame) blockResult(from, to int) ([]string, *value) {
+	sub := fr.sub()
+	sub.run(from, to)
+	if len(sub.stack) == 0 {
+		return sub.lines, nil
+	}
+	return sub.lines, sub.pop()
+}
+
+// blockValue decompile code[from:to] in a new stack, return value left on stack
+func (fr *frame) blockValue(from, to int) *value {
+	sub := fr.sub()
+	sub.run(from, to)
+	return sub.pop()
+}
+
+func (fr *frame) sub() *frame {
+	return &frame{d: fr.d, bc: fr.bc, locals: fr.locals, code: fr.code,
+		index: fr.index, loops: fr.loops, temps: fr.temps,
+		shortPC: fr.shortPC, shortValue: fr.shortValue}
+}
+
+// target return pc of jump instruction's destination
+func (fr *frame) target(ins *Instruction) int {
+	return ins.PC + ins.Operands[0]
+}
+
+// indexOf return index of instruction at pc in [from,to]
+func (fr *frame) indexOf(pc, from, to int) (int, bool) {
+	index, ok := fr.index[pc]
+	if !ok && pc == fr.endPC() {
+		index, ok = len(fr.code), true
+	}
+	return index, ok && index >= from && index <= to
+}
+
+func (fr *frame) endPC() int {
+	if len(fr.code) == 0 {
+		return 0
+	}
+	last := fr.code[len(fr.code)-1]
+	return last.PC + last.Size
+}
+
+// innerLoop return the innermost loop range being decompiled
+func (fr *frame) innerLoop() *ExceptionRange {
+	if len(fr.loops) == 0 {
+		return nil
+	}
+	return fr.loops[len(fr.loops)-1]
+}
+
+// findRange return exception range of type t begin at pc
+func (fr *frame) findRange(t ExceptionRangeType, pc int) *ExceptionRange {
+	for _, r := range fr.bc.ExceptionRanges {
+		if r.Type == t && r.CodeOffset == pc {
+			return r
+		}
+	}
+	return nil
+}
+
+func isJump(ins *Instruction) bool {
+	return ins.Name == "jump1" || ins.Name == "jump4"
+}
+
+func isCondJump(ins *Instruction) bool {
+	return strings.HasPrefix(ins.Name, "jumpTrue") || strings.HasPrefix(ins.Name, "jumpFalse")
+}
+
+// condition return expression, which is true if conditional jump ins falls through
+func (fr *frame) condition(ins *Instruction, v *value) string {
+	if strings.HasPrefix(ins.Name, "jumpTrue") {
+		return fr.exprNot(v)
+	}
+	return fr.exprText(v)
+}
+
+// topTestedLoop recognize while/for loop of Tcl 8.0-8.3,
+// code[index] is jumpFalse after the test
+//
+//	test; jumpFalse end; body; [next]; jump test; end: push ""
+func (fr *frame) topTestedLoop(index, to int) (int, bool) {
+	ins := &fr.code[index]
+	loop := fr.findRange(LoopExceptionRange, ins.PC+ins.Size)
+	if loop == nil || loop.BreakOffset != fr.target(ins) {
+		return 0, false
+	}
+	end, ok := fr.indexOf(loop.BreakOffset, index+2, to)
+	if !ok || !isJump(&fr.code[end-1]) {
+		return 0, false
+	}
+	test := fr.target(&fr.code[end-1])
+	if test > ins.PC {
+		return 0, false
+	}
+	cond := fr.condition(ins, fr.pop())
+	if loop.ContinueOffset == test {
+		fr.addLine("while {" + cond + "} " + scriptBody(fr.block(index+1, end-1, loop)))
+		return end, true
+	}
+	next, ok := fr.indexOf(loop.ContinueOffset, index+1, end-1)
+	if !ok {
+		return 0, false
+	}
+	start := fr.takeLines(fr.commandStart(test, loop.BreakOffset))
+	fr.addLine("for " + scriptWord(start) + " {" + cond + "} " + scriptWord(fr.block(next, end-1, nil)) + " " + scriptBody(fr.block(index+1, next, loop)))
+	return end, true
+}
+
+// bottomTestedLoop recognize while/for loop of Tcl 8.4 and later,
+// code[index] is jump to the test
+//
+//	jump test; body; [next]; test; jumpTrue body; push ""
+func (fr *frame) bottomTestedLoop(index, to int) (int, bool) {
+	ins := &fr.code[index]
+	loop := fr.findRange(LoopExceptionRange, ins.PC+ins.Size)
+	if loop == nil {
+		return 0, false
+	}
+	test, ok := fr.indexOf(fr.target(ins), index+1, to)
+	end, ok2 := fr.indexOf(loop.BreakOffset, test+1, to)
+	if !ok || !ok2 || !isCondJump(&fr.code[end-1]) || fr.target(&fr.code[end-1]) != loop.CodeOffset {
+		return 0, false
+	}
+	//jumpTrue back to body, loops while condition is true
+	v := fr.blockValue(test, end-1)
+	cond := fr.exprText(v)
+	if !strings.HasPrefix(fr.code[end-1].Name, "jumpTrue") {
+		cond = fr.exprNot(v)
+	}
+	if loop.ContinueOffset == fr.code[test].PC {
+		fr.addLine("while {" + cond + "} " + scriptBody(fr.block(index+1, test, loop)))
+		return end, true
+	}
+	next, ok := fr.indexOf(loop.ContinueOffset, index+1, test)
+	if !ok {
+		return 0, false
+	}
+	start := fr.takeLines(fr.commandStart(ins.PC, loop.BreakOffset))
+	fr.addLine("for " + scriptWord(start) + " {" + cond + "} " + scriptWord(fr.block(next, test, nil)) + " " + scriptBody(fr.block(index+1, next, loop)))
+	return end, true
+}
+
+// commandStart return pc of innermost command, which includes code[from:to]
+func (fr *frame) commandStart(from, to int) int {
+	start := from
+	locs, _ := fr.bc.CommandLocations()
+	best := -1
+	for _, loc := range locs {
+		if loc.CodeOffset <= from && loc.CodeOffset+loc.CodeLength >= to && loc.CodeOffset > best {
+			best = loc.CodeOffset
+		}
+	}
+	if best >= 0 {
+		start = best
+	}
+	return start
+}
+
+// ifElse recognize if/elseif/else, code[index] is the conditional jump
+//
+//	test; jumpFalse else; body; jump end; else: {else body|push ""}; end:
+func (fr *frame) ifElse(index, to int) (int, bool) {
+	ins := &fr.code[index]
+	elseIndex, ok := fr.indexOf(fr.target(ins), index+1, to)
+	if !ok {
+		return 0, false
+	}
+	end := elseIndex
+	thenEnd := elseIndex
+	if prev := &fr.code[elseIndex-1]; elseIndex-1 > index && isJump(prev) && !fr.isLoopJump(prev) {
+		if e, ok := fr.indexOf(fr.target(prev), elseIndex, to); ok {
+			end, thenEnd = e, elseIndex-1
+		}
+	}
+	cond := fr.pop()
+	c := &ifCommand{}
+	thenLines, thenValue := fr.blockResult(index+1, thenEnd)
+	clause := ifClause{cond: fr.condition(ins, cond), body: fr.withValue(thenLines, thenValue)}
+	c.clauses = append(c.clauses, clause)
+	var elseLines []string
+	var elseValue *value
+	if end > elseIndex && !fr.isEmptyPush(elseIndex, end) {
+		elseLines, elseValue = fr.blockResult(elseIndex, end)
+		if len(elseLines) == 0 && elseValue != nil && elseValue.ifc != nil {
+			//else {if ...} is elseif
+			c.clauses = append(c.clauses, elseValue.ifc.clauses...)
+			c.elseBody = elseValue.ifc.elseBody
+		} else {
+			c.elseBody = fr.withValue(elseLines, elseValue)
+			if c.elseBody == nil {
+				c.elseBody = []string{}
+			}
+		}
+	}
+	v := &value{kind: commandValue, text: c.String(), ifc: c}
+	//if of values only is c ? a : b
+	if len(thenLines) == 0 && len(elseLines) == 0 && thenValue != nil && elseValue != nil {
+		if strings.HasPrefix(ins.Name, "jumpTrue") {
+			cond = notValue(cond)
+		}
+		v.alt = ternary(cond, thenValue, elseValue)
+	}
+	fr.push(v)
+	return end, true
+}
+
+// withValue return lines, and v as the last command
+func (fr *frame) withValue(lines []string, v *value) []string {
+	if v == nil {
+		return lines
+	}
+	sub := fr.sub()
+	sub.lines = lines
+	sub.emit(v)
+	return sub.lines
+}
+
+// shortCircuit recognize && || and ?: of 0/1 in expression, code[index] is the conditional jump
+//
+//	a; jumpFalse short; b; [jumpFalse short]; push 1; jump end; short: push 0; end:
+func (fr *frame) shortCircuit(index, to int) (int, bool) {
+	ins := &fr.code[index]
+	short, ok := fr.indexOf(fr.target(ins), index+3, to-1)
+	if !ok || !fr.isBooleanPush(short) || !isJump(&fr.code[short-1]) {
+		return 0, false
+	}
+	shortIns := &fr.code[short]
+	if fr.target(&fr.code[short-1]) != shortIns.PC+shortIns.Size {
+		return 0, false
+	}
+	sub := fr.sub()
+	sub.shortPC, sub.shortValue = shortIns.PC, fr.literal(shortIns.Operands[0])
+	sub.run(index+1, short-1)
+	if len(sub.lines) != 0 || len(sub.stack) != 1 {
+		return 0, false
+	}
+	fr.push(fr.shortValueOf(ins, fr.pop(), sub.pop(), sub.shortValue))
+	return short + 1, true
+}
+
+// shortCircuitRest decompile code[index:to] after conditional jump
+// to the short circuit value
+func (fr *frame) shortCircuitRest(index, to int) int {
+	ins := &fr.code[index]
+	cond := fr.pop()
+	sub := fr.sub()
+	sub.run(index+1, to)
+	fr.push(fr.shortValueOf(ins, cond, sub.pop(), fr.shortValue))
+	return to
+}
+
+// shortValueOf return value of expression: jump to short if cond, or rest
+func (fr *frame) shortValueOf(ins *Instruction, cond, rest, short *value) *value {
+	if strings.HasPrefix(ins.Name, "jumpTrue") {
+		return ternary(cond, short, rest)
+	}
+	return ternary(cond, rest, short)
+}
+
+// isBooleanPush return true if code[index] is push of "0" or "1"
+func (fr *frame) isBooleanPush(index int) bool {
+	if !isPush(&fr.code[index]) {
+		return false
+	}
+	v := fr.literal(fr.code[index].Operands[0])
+	return isLiteral(v, "0") || isLiteral(v, "1")
+}
+
+// isLoopJump return true if ins is break/continue of the innermost loop
+func (fr *frame) isLoopJump(ins *Instruction) bool {
+	loop := fr.innerLoop()
+	return loop != nil && (fr.target(ins) == loop.BreakOffset || fr.target(ins) == loop.ContinueOffset)
+}
+
+// isPush return true if ins push a literal, pushResult and pushReturnCode have no operand
+func isPush(ins *Instruction) bool {
+	return ins.Name == "push1" || ins.Name == "push4"
+}
+
+// isEmptyPush return true if code[from:to] is only push of ""
+func (fr *frame) isEmptyPush(from, to int) bool {
+	if to-from != 1 || !isPush(&fr.code[from]) {
+		return false
+	}
+	v := fr.literal(fr.code[from].Operands[0])
+	return v.kind == literalValue && v.text == "" && v.proc == nil
+}
+
+// foreach recognize foreach loop of Tcl 8.0-8.4, code[index] is foreach_start4
+//
+//	value lists stored in temps; foreach_start4; step: foreach_step4; jumpFalse end; body; jump step; end:
+func (fr *frame) foreach(index, to int) (int, bool) {
+	aux := fr.auxData(fr.code[index].Operands[0], ForeachAuxData)
+	if aux == nil || index+2 >= to || fr.code[index+1].Name != "foreach_step4" || !strings.HasPrefix(fr.code[index+2].Name, "jumpFalse") {
+		return 0, false
+	}
+	jump := &fr.code[index+2]
+	loop := fr.findRange(LoopExceptionRange, jump.PC+jump.Size)
+	end, ok := fr.indexOf(fr.target(jump), index+3, to)
+	if loop == nil || !ok || !isJump(&fr.code[end-1]) {
+		return 0, false
+	}
+	words := []string{"foreach"}
+	for i, vars := range aux.Foreach.VarLists {
+		var names []string
+		for _, v := range vars {
+			names = append(names, fr.localName(v))
+		}
+		list := fr.temps[aux.Foreach.FirstValueTemp+i]
+		if list == nil {
+			list = &value{kind: unknownValue}
+		}
+		words = append(words, quoteList(names), fr.word(list))
+	}
+	words = append(words, scriptBody(fr.block(index+3, end-1, loop)))
+	fr.addLine(strings.Join(words, " "))
+	return end, true
+}
+
+// quoteList return items as Tcl list
+func quoteList(items []string) string {
+	var words []string
+	for _, s := range items {
+		words = append(words, quoteWord(s))
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "{" + strings.Join(words, " ") + "}"
+}
+
+func (fr *frame) auxData(index int, t AuxDataType) *AuxData {
+	if index >= len(fr.bc.AuxData) || fr.bc.AuxData[index].Type != t {
+		return nil
+	}
+	return fr.bc.AuxData[index]
+}
+
+// catch recognize catch, code[index] is beginCatch4
+//
+//	beginCatch4; body; [store var]; pop; push 0; jump end;
+//	catch: [pushResult; store var; pop]; pushReturnCode; end: endCatch
+func (fr *frame) catch(index, to int) (int, bool) {
+	op := fr.code[index].Operands[0]
+	if op >= len(fr.bc.ExceptionRanges) {
+		return 0, false
+	}
+	r := fr.bc.ExceptionRanges[op]
+	bodyEnd, ok := fr.indexOf(r.End(), index+1, to)
+	handler, ok2 := fr.indexOf(r.CatchOffset, bodyEnd, to)
+	if r.Type != CatchExceptionRange || !ok || !ok2 {
+		return 0, false
+	}
+	end := handler
+	for end < to && fr.code[end].Name != "endCatch" {
+		end++
+	}
+	if end == to {
+		return 0, false
+	}
+	words := []string{"catch", scriptWord(fr.block(index+1, bodyEnd, nil))}
+	for _, ins := range fr.code[bodyEnd:handler] {
+		switch ins.Name {
+		case "storeScalar1", "storeScalar4":
+			words = append(words, quoteWord(fr.localName(ins.Operands[0])))
+		case "storeScalarStk":
+			words = append(words, fr.word(fr.pop()))
+		}
+	}
+	next := end + 1
+	//Tcl 8.6: endCatch; reverse 2; storeScalar var; pop
+	if next+2 < to && fr.code[next].Name == "reverse" && fr.code[next].Operands[0] == 2 &&
+		(fr.code[next+1].Name == "storeScalar1" || fr.code[next+1].Name == "storeScalar4") && fr.code[next+2].Name == "pop" {
+		words = append(words, quoteWord(fr.localName(fr.code[next+1].Operands[0])))
+		next += 3
+	}
+	fr.pushCommand(words...)
+	return next, true
+}
+
+// switchTable recognize switch compiled into jumpTable of Tcl 8.5 and later
+//
+//	value; jumpTable; jump default; bodies, each ends with jump end; default: {body|push ""}; end:
+func (fr *frame) switchTable(index, to int) (int, bool) {
+	ins := &fr.code[index]
+	aux := fr.auxData(ins.Operands[0], JumpTableAuxData)
+	if aux == nil || index+1 >= to || !isJump(&fr.code[index+1]) || len(aux.JumpTable.Entries) == 0 {
+		return 0, false
+	}
+	def, ok := fr.indexOf(fr.target(&fr.code[index+1]), index+2, to)
+	if !ok {
+		return 0, false
+	}
+	//keys of each body, in order of pc
+	keys := map[int][]string{}
+	var starts []int
+	for _, entry := range aux.JumpTable.Entries {
+		start, ok := fr.indexOf(ins.PC+entry.Offset, index+2, def)
+		if !ok {
+			return 0, false
+		}
+		if keys[start] == nil {
+			starts = append(starts, start)
+		}
+		keys[start] = append(keys[start], entry.Key)
+	}
+	sort.Ints(starts)
+	last := &fr.code[def-1]
+	if !isJump(last) {
+		return 0, false
+	}
+	end, ok := fr.indexOf(fr.target(last), def, to)
+	if !ok {
+		return 0, false
+	}
+	v := fr.pop()
+	var cases []string
+	for i, start := range starts {
+		stop := def
+		if i+1 < len(starts) {
+			stop = starts[i+1]
+		}
+		var words []string
+		for _, key := range keys[start] {
+			words = append(words, quoteWord(key), "-")
+		}
+		words[len(words)-1] = scriptBody(fr.block(start, stop-1, nil))
+		cases = append(cases, strings.Join(words, " "))
+	}
+	if !fr.isEmptyPush(def, end) {
+		cases = append(cases, "default "+scriptBody(fr.block(def, end, nil)))
+	}
+	fr.pushCommand("switch", "--", fr.word(v), "{\n"+indentLines(cases)+"}")
+	return end, true
+}
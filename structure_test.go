@@ -0,0 +1,161 @@
+package tbcload
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+var header86 = Header{FormatMajor: 2, CompilerVersion: "1.0", TclVersion: "8.6"}
+
+func testDecompileProc(t *testing.T, bc *ByteCode, expected string, locals ...string) {
+	t.Helper()
+	proc := &Procedure{ByteCode: bc, NumArgs: 1}
+	for index, name := range locals {
+		proc.Locals = append(proc.Locals, &CompiledLocal{Name: name, Index: index})
+	}
+	d := &Decompiler{header: header86, opTable: tcl86OpTable}
+	lines, err := d.decompileByteCode(proc.ByteCode, proc.Locals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(lines, "\n"); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestDecompileIf(t *testing.T) {
+	//if {$x} {puts a} elseif {$x} {puts b} else {puts c}
+	bc := &ByteCode{Literals: stringLiterals("puts", "a", "b", "c"), Code: assemble(tcl86OpTable,
+		"loadScalar1 0", "nop", "jumpFalse1 10",
+		"push1 0", "push1 1", "invokeStk1 2", "jump1 21",
+		"loadScalar1 0", "nop", "jumpFalse1 10",
+		"push1 0", "push1 2", "invokeStk1 2", "jump1 8",
+		"push1 0", "push1 3", "invokeStk1 2",
+		"done")}
+	testDecompileProc(t, bc, "if {$x} {\n    puts a\n} elseif {$x} {\n    puts b\n} else {\n    puts c\n}", "x")
+}
+
+func TestDecompileWhile(t *testing.T) {
+	//if {$x} {puts a}; while {$x} {if {$x} continue; puts b}
+	bc := &ByteCode{Literals: stringLiterals("puts", "a", "", "b"), Code: assemble(tcl86OpTable,
+		"loadScalar1 0", "nop", "jumpFalse1 10",
+		"push1 0", "push1 1", "invokeStk1 2", "jump1 4",
+		"push1 2", "pop",
+		"startCommand 58 1", "jump1 42",
+		"startCommand 32 1", "loadScalar1 0", "nop", "jumpFalse1 18",
+		"startCommand 14 1", "jump4 17", "jump1 4",
+		"push1 2", "pop",
+		"push1 0", "push1 3", "invokeStk1 2", "pop",
+		"loadScalar1 0", "nop", "jumpTrue1 -43",
+		"push1 2", "done"),
+		ExceptionRanges: []*ExceptionRange{{Type: LoopExceptionRange, CodeOffset: 27, NumCodeBytes: 39, BreakOffset: 72, ContinueOffset: 67, CatchOffset: -1}},
+	}
+	testDecompileProc(t, bc, "if {$x} {\n    puts a\n}\nwhile {$x} {\n    if {$x} {\n        continue\n    }\n    puts b\n}", "x")
+}
+
+func TestDecompileFor(t *testing.T) {
+	//for {set i 0} {$i} {incr i} {puts a; continue}
+	bc := &ByteCode{Literals: stringLiterals("0", "puts", "a", ""), Code: assemble(tcl86OpTable,
+		"push1 0", "storeScalar1 1", "pop",
+		"jump1 37",
+		"push1 1", "push1 2", "invokeStk1 2", "pop",
+		"startCommand 14 1", "jump4 6", "pop",
+		"startCommand 12 1", "incrScalar1Imm 1 1", "pop",
+		"loadScalar1 1", "nop", "jumpTrue1 -38",
+		"push1 3", "done"),
+		ExceptionRanges: []*ExceptionRange{
+			{Type: LoopExceptionRange, CodeOffset: 7, NumCodeBytes: 21, BreakOffset: 47, ContinueOffset: 29, CatchOffset: -1},
+			{Type: LoopExceptionRange, CodeOffset: 29, NumCodeBytes: 12, BreakOffset: 47, ContinueOffset: -1, CatchOffset: -1},
+		},
+	}
+	bc.SetCommandLocations([]CommandLocation{{0, 49}, {0, 4}, {7, 7}, {14, 14}, {29, 12}})
+	testDecompileProc(t, bc, "for {set i 0} {$i} {incr i} {\n    puts a\n    continue\n}", "x", "i")
+}
+
+func TestDecompileCatch(t *testing.T) {
+	//catch {puts a} msg
+	bc := &ByteCode{Literals: stringLiterals("puts", "a", "0"), Code: assemble(tcl86OpTable,
+		"beginCatch4 0",
+		"push1 0", "push1 1", "invokeStk1 2",
+		"push1 2", "jump1 4",
+		"pushResult", "pushReturnCode",
+		"endCatch", "reverse 2", "storeScalar1 1", "pop",
+		"done"),
+		ExceptionRanges: []*ExceptionRange{{Type: CatchExceptionRange, CodeOffset: 5, NumCodeBytes: 6, BreakOffset: -1, ContinueOffset: -1, CatchOffset: 15}},
+	}
+	testDecompileProc(t, bc, "catch {puts a} msg", "x", "msg")
+
+	//tampered: storeScalarStk has no local operand, reverse is not of 2 values
+	for _, tail := range [][]string{{"reverse 2", "storeScalarStk", "pop"}, {"reverse 3", "storeScalar1 1", "pop"}} {
+		lines := append([]string{"beginCatch4 0", "push1 0", "push1 1", "invokeStk1 2", "push1 2", "jump1 4",
+			"pushResult", "pushReturnCode", "endCatch"}, tail...)
+		bc.Code = assemble(tcl86OpTable, append(lines, "done")...)
+		d := &Decompiler{header: header86, opTable: tcl86OpTable}
+		if _, err := d.decompileByteCode(bc, []*CompiledLocal{{Name: "x"}, {Name: "msg", Index: 1}}); err != nil {
+			t.Errorf("%s: %s", tail, err)
+		}
+	}
+}
+
+func TestDecompileSwitch(t *testing.T) {
+	//switch $x {a - b {puts a} c {puts b}}
+	bc := &ByteCode{Literals: stringLiterals("puts", "a", "b", ""), Code: assemble(tcl86OpTable,
+		"loadScalar1 0", "jumpTable 0", "jump4 27",
+		"push1 0", "push1 1", "invokeStk1 2", "jump4 18",
+		"push1 0", "push1 2", "invokeStk1 2", "jump4 7",
+		"push1 3", "done"),
+		AuxData: []*AuxData{{Type: JumpTableAuxData, JumpTable: &JumpTableInfo{Entries: []JumpTableEntry{{"a", 10}, {"b", 10}, {"c", 21}}}}},
+	}
+	testDecompileProc(t, bc, "switch -- $x {\n    a - b {\n        puts a\n    }\n    c {\n        puts b\n    }\n}", "x")
+}
+
+func TestDecompileSwitchTampered(t *testing.T) {
+	//body before default does not end with jump
+	bc := &ByteCode{Literals: stringLiterals("puts", "a", "b", ""), Code: assemble(tcl86OpTable,
+		"loadScalar1 0", "jumpTable 0", "jump4 10",
+		"push1 0", "invokeStk1 1", "pop",
+		"push1 3", "done"),
+		AuxData: []*AuxData{{Type: JumpTableAuxData, JumpTable: &JumpTableInfo{Entries: []JumpTableEntry{{"a", 10}}}}},
+	}
+	d := &Decompiler{header: header86, opTable: tcl86OpTable}
+	lines, err := d.decompileByteCode(bc, []*CompiledLocal{{Name: "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(lines, "\n"); strings.Contains(s, "switch") {
+		t.Errorf("expected no switch, got:\n%s", s)
+	}
+}
+
+func TestDecompileForeach(t *testing.T) {
+	fs, err := os.Open("testdata/foreach.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	f, err := ReadFile(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "proc f {l1 l2} {\n    foreach {a b} $l1 c $l2 {\n        puts $a$b$c\n    }\n}\n"
+	if s := testDecompile(t, f); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestIsPush(t *testing.T) {
+	code, err := disassemble(tcl86OpTable, assemble(tcl86OpTable, "pushResult", "pushReturnCode", "push1 0", "push4 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &frame{bc: &ByteCode{Literals: stringLiterals("", "1")}, code: code}
+	for index, expected := range []bool{false, false, true, false} {
+		if fr.isEmptyPush(index, index+1) != expected {
+			t.Errorf("%s: expected isEmptyPush %v", code[index].Name, expected)
+		}
+		if fr.isBooleanPush(index) != (index == 3) {
+			t.Errorf("%s: expected isBooleanPush %v", code[index].Name, index == 3)
+		}
+	}
+}
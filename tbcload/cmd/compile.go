@@ -0,0 +1,82 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/corbamico/tbcload"
+	"github.com/spf13/cobra"
+)
+
+// compileCmd represents the compile command
+var compileCmd = &cobra.Command{
+	Use:   "compile [file]",
+	Short: "compile a Tcl script into .tbc file, which can be loaded by tbcload",
+	Long: `compile a Tcl script into .tbc file, which can be loaded by tbcload.
+
+Example:
+    tbcload compile script.tcl  #write script.tbc
+    tbcload compile script.tcl -o out.tbc
+    tbcload compile --tcl-version 8.4 script.tcl`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		if err := compileFile(args[0]); err != nil {
+			fmt.Printf("failed compile file (%s), error as (%s)\n", args[0], err)
+			os.Exit(1)
+		}
+	},
+}
+
+var output string
+
+func init() {
+	rootCmd.AddCommand(compileCmd)
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	compileCmd.Flags().StringVarP(&output, "output", "o", "", "name of .tbc file, default as script with extension .tbc")
+	compileCmd.Flags().StringVarP(&tclVersion, "tcl-version", "t", "", "target Tcl version (8.0-8.6), default 8.0")
+}
+
+func compileFile(uri string) error {
+	src, err := os.ReadFile(uri)
+	if err != nil {
+		return err
+	}
+	c := tbcload.NewCompiler()
+	c.TclVersion = tclVersion
+	f, err := c.Compile(string(src))
+	if err != nil {
+		return err
+	}
+	name := output
+	if name == "" {
+		name = strings.TrimSuffix(uri, filepath.Ext(uri)) + ".tbc"
+	}
+	w, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return tbcload.NewWriter(w).WriteFile(f)
+}
@@ -36,7 +36,7 @@ For example:
 
 		if len(args) == 1 {
 			//if there is 'z' ,length will large than src
-			dst := make([]byte, len(args[0])*4)
+			dst := make([]byte, tbcload.MaxDecodedLen(len(args[0])))
 
 			src = []byte(args[0])
 			if ndst := tbcload.Decode(dst, src); ndst > 0 {
@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strings"
@@ -32,6 +33,10 @@ var decompileCmd = &cobra.Command{
 
 Example:
     tbcload decompile  test.tbc  #decompile a file named test.tbc
+    tbcload decompile  --source test.tbc  #reconstruct Tcl source of test.tbc
+    tbcload decompile  --format json test.tbc  #disassembly as JSON
+    tbcload decompile  --format tcl test.tbc  #disassembly as tcl::unsupported::disassemble
+    tbcload decompile  --keep-going damaged.tbc  #report errors and dump as much as possible
     tbcload decompile  https://github.com/ActiveState/teapot/raw/master/lib/tbcload/tests/tbc10/proc.tbc
 			#decompile from a url`,
 	Args: cobra.MinimumNArgs(1),
@@ -51,6 +56,11 @@ Example:
 }
 
 var detail bool
+var tclVersion string
+var source bool
+var format string
+var maxBytes int
+var keepGoing bool
 
 func init() {
 	rootCmd.AddCommand(decompileCmd)
@@ -64,6 +74,11 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	decompileCmd.Flags().BoolVarP(&detail, "detail", "d", false, "decompile bytecode instruction too")
+	decompileCmd.Flags().StringVarP(&tclVersion, "tcl-version", "t", "", "opcode table of Tcl version (8.0-8.6), default as file header")
+	decompileCmd.Flags().BoolVarP(&source, "source", "s", false, "reconstruct Tcl source instead of disassembly")
+	decompileCmd.Flags().StringVarP(&format, "format", "f", tbcload.FormatText, "output format of disassembly, text, json or tcl")
+	decompileCmd.Flags().IntVarP(&maxBytes, "max-bytes", "m", tbcload.DefaultMaxBytes, "max bytes of one section, limit for untrusted file")
+	decompileCmd.Flags().BoolVarP(&keepGoing, "keep-going", "k", false, "report errors of damaged file and continue parsing")
 }
 
 func parseFile(uri string) {
@@ -72,10 +87,11 @@ func parseFile(uri string) {
 		fmt.Printf("failed read from file (%s), error as (%s)\n", uri, err)
 		return
 	}
-	p := tbcload.NewParser(r, os.Stdout)
-	p.Detail = detail
-	if err = p.Parse(); err != nil {
-		fmt.Printf("failed parse file (%s), error as (%s)\n", uri, err)
+	defer r.Close()
+	if err = parse(uri, r); err != nil {
+		if !printParseError(uri, err) {
+			fmt.Printf("failed parse file (%s), error as (%s)\n", uri, err)
+		}
 		return
 	}
 }
@@ -85,12 +101,42 @@ func parseURL(uri string) {
 		fmt.Printf("failed read from uri (%s), error as (%s)\n", uri, err)
 		return
 	}
-	p := tbcload.NewParser(r.Body, os.Stdout)
-	p.Detail = detail
-	
-	if err = p.Parse(); err != nil {
-		fmt.Printf("failed parse uri (%s), error as (%s)\n", uri, err)
+	if err = parse(uri, r.Body); err != nil {
+		if !printParseError(uri, err) {
+			fmt.Printf("failed parse uri (%s), error as (%s)\n", uri, err)
+		}
 		return
 	}
 	r.Body.Close()
 }
+
+// parse write disassembly or Tcl source of r to stdout,
+// and errors recovered with --keep-going to stderr
+func parse(uri string, r io.Reader) error {
+	if source {
+		p := tbcload.NewParser(r, io.Discard)
+		p.MaxBytes, p.Tolerant = maxBytes, keepGoing
+		f, err := p.ReadFile()
+		printDiagnostics(uri, p.Diagnostics)
+		if err != nil {
+			return err
+		}
+		d := tbcload.NewDecompiler(os.Stdout)
+		d.TclVersion = tclVersion
+		return d.Decompile(f)
+	}
+	p := tbcload.NewParser(r, os.Stdout)
+	p.Detail = detail
+	p.TclVersion = tclVersion
+	p.Format = format
+	p.MaxBytes, p.Tolerant = maxBytes, keepGoing
+	err := p.Parse()
+	printDiagnostics(uri, p.Diagnostics)
+	return err
+}
+
+func printDiagnostics(uri string, diags []*tbcload.ParseError) {
+	for _, d := range diags {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", uri, d)
+	}
+}
@@ -15,7 +15,6 @@
 package cmd
 
 import (
-	"encoding/ascii85"
 	"encoding/hex"
 	"fmt"
 
@@ -36,7 +35,7 @@ proc->,CHr@`,
 		var err error
 
 		if len(args) == 1 {
-			dst := make([]byte, ascii85.MaxEncodedLen(len(args[0])))
+			dst := make([]byte, tbcload.MaxEncodedLen(len(args[0])))
 
 			if bHex {
 				if src, err = hex.DecodeString(args[0]); err != nil {
@@ -0,0 +1,123 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/corbamico/tbcload"
+	"github.com/spf13/cobra"
+)
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:   "graph [file|url]",
+	Short: "write control flow graph of a .tbc file in Graphviz DOT",
+	Long: `write control flow graph of a .tbc file in Graphviz DOT,
+of top level bytecode, or of a procedure by --proc.
+
+Example:
+    tbcload graph test.tbc | dot -Tsvg > test.svg
+    tbcload graph --proc hello test.tbc  #graph of procedure hello`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		uri := args[0]
+		r, err := openURI(uri)
+		if err != nil {
+			fmt.Printf("failed read from (%s), error as (%s)\n", uri, err)
+			return
+		}
+		defer r.Close()
+		if err = graph(r); err != nil && !printParseError(uri, err) {
+			fmt.Printf("failed graph (%s), error as (%s)\n", uri, err)
+		}
+	},
+}
+
+var procName string
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	graphCmd.Flags().StringVarP(&procName, "proc", "p", "", "name of procedure, default as top level bytecode")
+	graphCmd.Flags().StringVarP(&tclVersion, "tcl-version", "t", "", "opcode table of Tcl version (8.0-8.6), default as file header")
+}
+
+// openURI open file on disk, or http(s) url
+func openURI(uri string) (io.ReadCloser, error) {
+	if strings.HasPrefix(uri, "https://") || strings.HasPrefix(uri, "http://") {
+		resp, err := http.Get(uri)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+	return os.Open(uri)
+}
+
+// printParseError print err as "file.tbc:123: literal 7: cause" if it is ParseError
+func printParseError(uri string, err error) bool {
+	var perr *tbcload.ParseError
+	if !errors.As(err, &perr) {
+		return false
+	}
+	fmt.Printf("%s:%s\n", uri, perr)
+	return true
+}
+
+// graph write DOT of top level or procedure procName of r to stdout
+func graph(r io.Reader) error {
+	f, err := tbcload.ReadFile(r)
+	if err != nil {
+		return err
+	}
+	version := tclVersion
+	if version == "" {
+		version = f.Header.TclVersion
+	}
+	bc, locals, name := f.ByteCode, []*tbcload.CompiledLocal(nil), "toplevel"
+	if procName != "" {
+		procs, err := f.Procs(tclVersion)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, def := range procs {
+			if def.Name == procName {
+				bc, locals, name, found = def.Proc.ByteCode, def.Proc.Locals, def.Name, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("procedure %s is not found", procName)
+		}
+	}
+	g, err := tbcload.NewCFG(bc, locals, version)
+	if err != nil {
+		return err
+	}
+	return g.WriteDot(os.Stdout, name)
+}
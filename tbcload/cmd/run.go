@@ -0,0 +1,78 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/corbamico/tbcload"
+	"github.com/spf13/cobra"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [file|url]",
+	Short: "dry-run a .tbc file in sandbox, printing commands it invokes",
+	Long: `dry-run a .tbc file in sandbox, printing commands it invokes.
+Bytecode is executed by a VM without Tcl interpreter, every command is
+printed and return empty result. With --builtins, procedures of the script
+are called, and proc, return, error, break, continue and global are run.
+
+Example:
+    tbcload run test.tbc
+    tbcload run --builtins test.tbc
+    tbcload run --max-steps 10000 test.tbc`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		uri := args[0]
+		r, err := openURI(uri)
+		if err != nil {
+			fmt.Printf("failed read from (%s), error as (%s)\n", uri, err)
+			return
+		}
+		defer r.Close()
+		f, err := tbcload.ReadFile(r)
+		if err != nil {
+			if !printParseError(uri, err) {
+				fmt.Printf("failed parse (%s), error as (%s)\n", uri, err)
+			}
+			return
+		}
+		vm := tbcload.NewVM(os.Stdout)
+		vm.TclVersion, vm.MaxSteps, vm.Builtins = tclVersion, maxSteps, builtins
+		if _, err = vm.Run(f); err != nil {
+			fmt.Printf("failed run (%s), error as (%s)\n", uri, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var maxSteps int
+var builtins bool
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	runCmd.Flags().StringVarP(&tclVersion, "tcl-version", "t", "", "opcode table of Tcl version (8.0-8.6), default as file header")
+	runCmd.Flags().IntVarP(&maxSteps, "max-steps", "m", 1000000, "max number of executed instructions, 0 for no limit")
+	runCmd.Flags().BoolVarP(&builtins, "builtins", "b", false, "call procedures of script and run proc, return, error, break, continue, global")
+}
@@ -0,0 +1,69 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/corbamico/tbcload"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [file|url]",
+	Short: "verify stack depth of a .tbc file, to detect corrupted or tampered file",
+	Long: `verify stack depth of a .tbc file, to detect corrupted or tampered file.
+Stack underflow, inconsistent depth where control flows merge, and depth
+exceeding maxStackDepth of bytecode are reported.
+
+Example:
+    tbcload verify test.tbc`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		uri := args[0]
+		r, err := openURI(uri)
+		if err != nil {
+			fmt.Printf("failed read from (%s), error as (%s)\n", uri, err)
+			return
+		}
+		defer r.Close()
+		f, err := tbcload.ReadFile(r)
+		if err != nil {
+			if !printParseError(uri, err) {
+				fmt.Printf("failed parse (%s), error as (%s)\n", uri, err)
+			}
+			return
+		}
+		if err = f.VerifyStack(tclVersion); err != nil {
+			fmt.Printf("%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	verifyCmd.Flags().StringVarP(&tclVersion, "tcl-version", "t", "", "opcode table of Tcl version (8.0-8.6), default as file header")
+}
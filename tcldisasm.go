@@ -0,0 +1,229 @@
+package tbcload
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FormatTcl is output format of Parser as tcl::unsupported::disassemble
+const FormatTcl = "tcl"
+
+// maxSourceChars is the max chars of source printed by Tcl
+const maxSourceChars = 55
+
+// flags of CompiledLocal written by TclPro, as Tcl 8.0-8.4
+const (
+	varArray = 0x2
+	varLink  = 0x4
+)
+
+// dumpTcl write f as tcl::unsupported::disassemble, procedures follow each block.
+// Source is not kept in tbc file, so source of commands is empty as tbcload does
+func (p *Parser) dumpTcl(f *File) error {
+	for index, b := range f.Blocks() {
+		if index > 0 {
+			p.w.WriteByte('\n')
+		}
+		if err := p.selectBlock(b); err != nil {
+			return err
+		}
+		if err := p.dumpTclByteCode(b.ByteCode, b.Proc); err != nil {
+			return err
+		}
+		if err := p.dumpTclProcs(b.ByteCode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Parser) dumpTclProcs(bc *ByteCode) error {
+	for index, lit := range bc.Literals {
+		if lit.Type != LiteralProc {
+			continue
+		}
+		p.w.WriteByte('\n')
+		if err := p.dumpTclByteCode(lit.Proc.ByteCode, lit.Proc); err != nil {
+			return fmt.Errorf("literal %d: %w", index, err)
+		}
+		if err := p.dumpTclProcs(lit.Proc.ByteCode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Parser) dumpTclByteCode(bc *ByteCode, proc *Procedure) error {
+	locs, err := bc.CommandLocations()
+	if err != nil {
+		return err
+	}
+	code, err := disassemble(p.opTable, bc.Code)
+	if err != nil {
+		return err
+	}
+	info := bc.Info
+	ratio := 0.0
+	if info.NumSrcBytes > 0 {
+		ratio = float64(len(bc.Code)) / float64(info.NumSrcBytes)
+	}
+	p.w.WriteString("ByteCode 0x0, refCt 1, epoch 0, interp 0x0 (epoch 0)\n")
+	p.w.WriteString("  Source " + printSource("", maxSourceChars) + "\n")
+	p.w.WriteString(fmt.Sprintf("  Cmds %d, src %d, inst %d, litObjs %d, aux %d, stkDepth %d, code/src %.2f\n",
+		info.NumCommands, info.NumSrcBytes, len(bc.Code), len(bc.Literals), len(bc.AuxData), info.MaxStackDepth, ratio))
+	var locals []*CompiledLocal
+	if proc != nil {
+		locals = proc.Locals
+		p.w.WriteString(fmt.Sprintf("  Proc 0x0, refCt 1, args %d, compiled locals %d\n", proc.NumArgs, len(proc.Locals)))
+		for index, local := range proc.Locals {
+			p.w.WriteString(fmt.Sprintf("      slot %d%s", index, localFlags(local.Flags)))
+			if local.Flags&varTemporary != 0 {
+				p.w.WriteByte('\n')
+			} else {
+				p.w.WriteString(fmt.Sprintf(", %s\n", printSource(local.Name, -1)))
+			}
+		}
+	}
+	if len(bc.ExceptionRanges) > 0 {
+		p.w.WriteString(fmt.Sprintf("  Exception ranges %d, depth %d:\n", len(bc.ExceptionRanges), info.MaxExceptDepth))
+		for index, r := range bc.ExceptionRanges {
+			p.w.WriteString(fmt.Sprintf("      %d: level %d, ", index, r.NestingLevel))
+			if r.Type == LoopExceptionRange {
+				p.w.WriteString(fmt.Sprintf("loop, pc %d-%d, continue %d, break %d\n", r.CodeOffset, r.End()-1, r.ContinueOffset, r.BreakOffset))
+			} else {
+				p.w.WriteString(fmt.Sprintf("catch, pc %d-%d, catch %d\n", r.CodeOffset, r.End()-1, r.CatchOffset))
+			}
+		}
+	}
+	if len(locs) == 0 {
+		p.w.WriteString("  No commands\n")
+	} else {
+		p.w.WriteString(fmt.Sprintf("  Commands %d:", len(locs)))
+		for index, loc := range locs {
+			sep := "     "
+			if index%2 == 0 {
+				sep = "\n   "
+			}
+			//source of commands is not kept, tbcload sets it as empty
+			p.w.WriteString(fmt.Sprintf("%s%4d: pc %d-%d, src 0--1", sep, index+1, loc.CodeOffset, loc.CodeOffset+loc.CodeLength-1))
+		}
+		p.w.WriteByte('\n')
+	}
+	indexCmds := 0
+	for _, ins := range code {
+		for ; indexCmds < len(locs) && locs[indexCmds].CodeOffset <= ins.PC; indexCmds++ {
+			p.w.WriteString(fmt.Sprintf("  Command %d: %s\n", indexCmds+1, printSource("", maxSourceChars)))
+		}
+		p.w.WriteString("    " + formatTclInstruction(&ins, bc, locals) + "\n")
+	}
+	return nil
+}
+
+// localFlags return flags of compiled local, e.g. ", scalar, arg"
+func localFlags(flags int) (s string) {
+	if flags&(varArray|varLink) == 0 {
+		s += ", scalar"
+	}
+	for _, f := range []struct {
+		flag int
+		name string
+	}{{varArray, "array"}, {varLink, "link"}, {varArgument, "arg"}, {varTemporary, "temp"}} {
+		if flags&f.flag != 0 {
+			s += ", " + f.name
+		}
+	}
+	return
+}
+
+// formatTclInstruction format ins as "(pc) name operands \t# note"
+func formatTclInstruction(ins *Instruction, bc *ByteCode, locals []*CompiledLocal) string {
+	var b strings.Builder
+	var suffix string
+	b.WriteString(fmt.Sprintf("(%d) %s ", ins.PC, ins.Name))
+	for index, t := range ins.operandTypes() {
+		v := ins.Operands[index]
+		switch t {
+		case OPERAND_INT1, OPERAND_INT4:
+			b.WriteString(fmt.Sprintf("%+d ", v))
+		case OPERAND_OFFSET1, OPERAND_OFFSET4:
+			b.WriteString(fmt.Sprintf("%+d ", v))
+			if ins.Name == "startCommand" {
+				suffix = fmt.Sprintf("next cmd at pc %d", ins.PC+v)
+			} else {
+				suffix = fmt.Sprintf("pc %d", ins.PC+v)
+			}
+		case OPERAND_UINT4:
+			b.WriteString(fmt.Sprintf("%d ", v))
+			if ins.Name == "startCommand" {
+				suffix += fmt.Sprintf(", %d cmds start here", v)
+			}
+		case OPERAND_LIT1, OPERAND_LIT4:
+			b.WriteString(fmt.Sprintf("%d ", v))
+			if v < len(bc.Literals) {
+				suffix = printSource(bc.Literals[v].Value, maxLiteralChars)
+			}
+		case OPERAND_IDX4:
+			switch {
+			case v >= -1:
+				b.WriteString(fmt.Sprintf("%d ", v))
+			case v == -2:
+				b.WriteString("end ")
+			default:
+				b.WriteString(fmt.Sprintf("end-%d ", -2-v))
+			}
+		case OPERAND_LVT1, OPERAND_LVT4:
+			b.WriteString(fmt.Sprintf("%%v%d ", v))
+			if v < len(locals) {
+				if locals[v].Flags&varTemporary != 0 {
+					suffix = fmt.Sprintf("temp var %d", v)
+				} else {
+					suffix = "var " + printSource(locals[v].Name, maxLiteralChars)
+				}
+			}
+		default:
+			b.WriteString(fmt.Sprintf("%d ", v))
+		}
+	}
+	if suffix != "" {
+		b.WriteString("\t# " + suffix)
+	}
+	return b.String()
+}
+
+// printSource quote s as PrintSourceToObj of Tcl, at most max chars if max >= 0
+func printSource(s string, max int) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	runes := []rune(s)
+	truncated := max >= 0 && len(runes) > max
+	if truncated {
+		runes = runes[:max]
+	}
+	for _, c := range runes {
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				b.WriteString(fmt.Sprintf(`\u%04x`, c))
+			} else {
+				b.WriteRune(c)
+			}
+		}
+	}
+	if truncated {
+		b.WriteString("...")
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package tbcload
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseTcl(t *testing.T) {
+	fs, err := os.Open("testdata/hello.tbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	var out bytes.Buffer
+	p := NewParser(fs, &out)
+	p.Format = FormatTcl
+	if err = p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"ByteCode 0x0, refCt 1, epoch 0, interp 0x0 (epoch 0)\n  Source \"\"\n  Cmds 2, src 27, inst 18, litObjs 5, aux 0, stkDepth 4, code/src 0.67\n",
+		"  Commands 2:\n      1: pc 0-9, src 0--1        2: pc 11-16, src 0--1\n  Command 1: \"\"\n    (0) push1 0 \t# \"proc\"\n",
+		"    (17) done \n",
+		"  Proc 0x0, refCt 1, args 1, compiled locals 1\n      slot 0, scalar, arg, \"name\"\n",
+		"    (4) loadScalar1 %v0 \t# var \"name\"\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in output:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestPrintSource(t *testing.T) {
+	for _, v := range []struct {
+		s      string
+		max    int
+		quoted string
+	}{
+		{"", 55, `""`},
+		{"a \"b\"\n\tc", 55, `"a \"b\"\n\tc"`},
+		{"héllo", 55, `"h\u00e9llo"`},
+		{"abcdef", 3, `"abc..."`},
+	} {
+		if s := printSource(v.s, v.max); s != v.quoted {
+			t.Errorf("%q: expected %s, got %s", v.s, v.quoted, s)
+		}
+	}
+}
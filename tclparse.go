@@ -0,0 +1,453 @@
+package tbcload
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrScriptSyntax means Tcl script can not be parsed
+var ErrScriptSyntax = errors.New("syntax error in Tcl script")
+
+// tclCommand is one command parsed from Tcl script
+type tclCommand struct {
+	words []*tclWord
+}
+
+// tclWord is one word of command, text and substitutions in order
+type tclWord struct {
+	parts  []wordPart
+	braced bool //{...} word, or expression operand
+}
+
+type partKind int
+
+const (
+	textPart   partKind = iota //text, backslash substituted
+	varPart                    //$name or $name(index)
+	scriptPart                 //[script]
+)
+
+// wordPart is text, variable or command substitution of word
+type wordPart struct {
+	kind  partKind
+	text  string   //text of textPart, name of varPart, script of scriptPart
+	index *tclWord //index of array element, nil for scalar
+}
+
+// literal return text of w, and false if w has substitutions
+func (w *tclWord) literal() (string, bool) {
+	var sb strings.Builder
+	for _, part := range w.parts {
+		if part.kind != textPart {
+			return "", false
+		}
+		sb.WriteString(part.text)
+	}
+	return sb.String(), true
+}
+
+func (w *tclWord) addText(s string) {
+	if n := len(w.parts); n > 0 && w.parts[n-1].kind == textPart {
+		w.parts[n-1].text += s
+		return
+	}
+	w.parts = append(w.parts, wordPart{kind: textPart, text: s})
+}
+
+// scriptParser parse Tcl script, following rules of Tcl(n)
+type scriptParser struct {
+	src string
+	pos int
+}
+
+// parseScript split src into commands
+func parseScript(src string) ([]*tclCommand, error) {
+	p := &scriptParser{src: src}
+	cmds, err := p.parseCommands(false)
+	if err == nil && p.pos < len(src) {
+		err = p.errorf("unexpected %q", src[p.pos])
+	}
+	return cmds, err
+}
+
+func (p *scriptParser) errorf(format string, a ...interface{}) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("%w: line %d: %s", ErrScriptSyntax, line, fmt.Sprintf(format, a...))
+}
+
+func (p *scriptParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\v' || c == '\f' || c == '\r'
+}
+
+// skipSpace skip white space and backslash-newline in command
+func (p *scriptParser) skipSpace() {
+	for p.pos < len(p.src) {
+		if isSpace(p.src[p.pos]) {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "\\\n") {
+			p.pos += 2
+		} else {
+			return
+		}
+	}
+}
+
+// parseCommands parse commands until end of src, or "]" if nested
+func (p *scriptParser) parseCommands(nested bool) (cmds []*tclCommand, err error) {
+	for {
+		//separators and comments between commands
+		for p.pos < len(p.src) {
+			p.skipSpace()
+			if c := p.peek(); c == '\n' || c == ';' {
+				p.pos++
+			} else if c == '#' {
+				p.skipComment()
+			} else {
+				break
+			}
+		}
+		if p.pos == len(p.src) || nested && p.peek() == ']' {
+			return
+		}
+		cmd := &tclCommand{}
+		for {
+			p.skipSpace()
+			c := p.peek()
+			if p.pos == len(p.src) || c == '\n' || c == ';' || nested && c == ']' {
+				break
+			}
+			var w *tclWord
+			if w, err = p.parseWord(nested); err != nil {
+				return
+			}
+			cmd.words = append(cmd.words, w)
+		}
+		if len(cmd.words) > 0 {
+			cmds = append(cmds, cmd)
+		}
+	}
+}
+
+func (p *scriptParser) skipComment() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '\n':
+			return
+		}
+		p.pos++
+	}
+	p.pos = len(p.src)
+}
+
+// atWordEnd return true if a word may end at pos
+func (p *scriptParser) atWordEnd(nested bool) bool {
+	c := p.peek()
+	return p.pos == len(p.src) || isSpace(c) || c == '\n' || c == ';' || nested && c == ']' ||
+		strings.HasPrefix(p.src[p.pos:], "\\\n")
+}
+
+func (p *scriptParser) parseWord(nested bool) (w *tclWord, err error) {
+	w = &tclWord{}
+	switch p.peek() {
+	case '{':
+		var s string
+		if s, err = p.parseBraced(); err != nil {
+			return
+		}
+		w.braced = true
+		w.addText(s)
+		if !p.atWordEnd(nested) {
+			return nil, p.errorf("extra characters after close-brace")
+		}
+	case '"':
+		p.pos++
+		if err = p.parseParts(w, func(c byte) bool { return c == '"' }); err != nil {
+			return
+		}
+		if p.peek() != '"' {
+			return nil, p.errorf("missing \"")
+		}
+		p.pos++
+		if !p.atWordEnd(nested) {
+			return nil, p.errorf("extra characters after close-quote")
+		}
+	default:
+		err = p.parseParts(w, func(c byte) bool {
+			return isSpace(c) || c == '\n' || c == ';' || nested && c == ']' ||
+				c == '\\' && strings.HasPrefix(p.src[p.pos:], "\\\n")
+		})
+	}
+	if err == nil && len(w.parts) == 0 {
+		w.addText("")
+	}
+	return
+}
+
+// parseBraced return text in {...} at pos, backslash-newline is replaced by space
+func (p *scriptParser) parseBraced() (string, error) {
+	var sb strings.Builder
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return sb.String(), nil
+			}
+		case '\\':
+			if strings.HasPrefix(p.src[p.pos:], "\\\n") {
+				p.pos += 2
+				for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+					p.pos++
+				}
+				sb.WriteByte(' ')
+				continue
+			}
+			if p.pos+1 < len(p.src) {
+				sb.WriteString(p.src[p.pos : p.pos+2])
+				p.pos += 2
+				continue
+			}
+		}
+		if depth > 1 || c != '{' || p.pos != start {
+			sb.WriteByte(c)
+		}
+		p.pos++
+	}
+	p.pos = start
+	return "", p.errorf("missing close-brace")
+}
+
+// parseParts parse text and substitutions until stop(c) or end of src
+func (p *scriptParser) parseParts(w *tclWord, stop func(c byte) bool) error {
+	for p.pos < len(p.src) && !stop(p.src[p.pos]) {
+		switch p.src[p.pos] {
+		case '\\':
+			w.addText(p.backslash())
+		case '$':
+			part, ok, err := p.parseVariable()
+			if err != nil {
+				return err
+			}
+			if ok {
+				w.parts = append(w.parts, part)
+			} else {
+				w.addText("$")
+			}
+		case '[':
+			script, err := p.parseBracketed()
+			if err != nil {
+				return err
+			}
+			w.parts = append(w.parts, wordPart{kind: scriptPart, text: script})
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && !stop(p.src[p.pos]) && !strings.ContainsRune(`\$[`, rune(p.src[p.pos])) {
+				p.pos++
+			}
+			w.addText(p.src[start:p.pos])
+		}
+	}
+	return nil
+}
+
+// parseBracketed return script in [...] at pos
+func (p *scriptParser) parseBracketed() (string, error) {
+	start := p.pos
+	p.pos++
+	if _, err := p.parseCommands(true); err != nil {
+		return "", err
+	}
+	if p.peek() != ']' {
+		p.pos = start
+		return "", p.errorf("missing close-bracket")
+	}
+	p.pos++
+	return p.src[start+1 : p.pos-1], nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseVariable parse $name, ${name} or $name(index) at pos,
+// return false if $ is not followed by variable name
+func (p *scriptParser) parseVariable() (part wordPart, ok bool, err error) {
+	start := p.pos
+	p.pos++
+	part.kind = varPart
+	if p.peek() == '{' {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			p.pos = start
+			return part, false, p.errorf("missing close-brace for variable name")
+		}
+		part.text = p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		return part, true, nil
+	}
+	nameStart := p.pos
+	for p.pos < len(p.src) {
+		if isNameChar(p.src[p.pos]) {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "::") {
+			p.pos += 2
+		} else {
+			break
+		}
+	}
+	part.text = p.src[nameStart:p.pos]
+	if p.peek() == '(' {
+		p.pos++
+		part.index = &tclWord{}
+		if err = p.parseParts(part.index, func(c byte) bool { return c == ')' }); err != nil {
+			return
+		}
+		if p.peek() != ')' {
+			p.pos = start
+			return part, false, p.errorf("missing )")
+		}
+		p.pos++
+		if len(part.index.parts) == 0 {
+			part.index.addText("")
+		}
+	}
+	if part.text == "" && part.index == nil {
+		p.pos = start + 1
+		return part, false, nil
+	}
+	return part, true, nil
+}
+
+// backslash return backslash substitution at pos
+func (p *scriptParser) backslash() string {
+	p.pos++
+	if p.pos == len(p.src) {
+		return "\\"
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'a':
+		return "\a"
+	case 'b':
+		return "\b"
+	case 'f':
+		return "\f"
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case 'v':
+		return "\v"
+	case '\n':
+		for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+			p.pos++
+		}
+		return " "
+	case 'x', 'u':
+		max := 2
+		if c == 'u' {
+			max = 4
+		}
+		digits := p.digits(max, 16)
+		if digits == "" {
+			return string(c)
+		}
+		n, _ := strconv.ParseUint(digits, 16, 32)
+		if c == 'x' {
+			return string([]byte{byte(n)})
+		}
+		return string(rune(n))
+	}
+	if c >= '0' && c <= '7' {
+		p.pos--
+		n, _ := strconv.ParseUint(p.digits(3, 8), 8, 32)
+		return string([]byte{byte(n)})
+	}
+	//other character, including multi-byte UTF-8
+	_, size := utf8.DecodeRuneInString(p.src[p.pos-1:])
+	p.pos += size - 1
+	return p.src[p.pos-size : p.pos]
+}
+
+// digits return at most max digits of base at pos
+func (p *scriptParser) digits(max, base int) string {
+	start := p.pos
+	for p.pos < len(p.src) && p.pos-start < max {
+		if _, err := strconv.ParseUint(p.src[p.pos:p.pos+1], base, 8); err != nil {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// splitList split Tcl list s into elements
+func splitList(s string) (elems []string, err error) {
+	p := &scriptParser{src: s}
+	for {
+		for p.pos < len(s) && (isSpace(s[p.pos]) || s[p.pos] == '\n') {
+			p.pos++
+		}
+		if p.pos == len(s) {
+			return
+		}
+		w := &tclWord{}
+		switch p.peek() {
+		case '{':
+			var e string
+			if e, err = p.parseBraced(); err != nil {
+				return nil, err
+			}
+			w.addText(e)
+		case '"':
+			p.pos++
+			p.listParts(w, func(c byte) bool { return c == '"' })
+			if p.peek() != '"' {
+				return nil, p.errorf("unmatched open quote in list")
+			}
+			p.pos++
+		default:
+			p.listParts(w, func(c byte) bool { return isSpace(c) || c == '\n' })
+		}
+		if p.pos < len(s) && !isSpace(s[p.pos]) && s[p.pos] != '\n' {
+			return nil, p.errorf("list element followed by %q instead of space", s[p.pos])
+		}
+		e, _ := w.literal()
+		elems = append(elems, e)
+	}
+}
+
+// listParts parse list element, only backslash is substituted
+func (p *scriptParser) listParts(w *tclWord, stop func(c byte) bool) {
+	w.addText("")
+	for p.pos < len(p.src) && !stop(p.src[p.pos]) {
+		if p.src[p.pos] == '\\' {
+			w.addText(p.backslash())
+			continue
+		}
+		w.addText(p.src[p.pos : p.pos+1])
+		p.pos++
+	}
+}
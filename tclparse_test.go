@@ -0,0 +1,89 @@
+package tbcload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// formatWord return w as "text$var(index)[script]" for test
+func formatWord(w *tclWord) string {
+	var sb strings.Builder
+	for _, part := range w.parts {
+		switch part.kind {
+		case textPart:
+			sb.WriteString(fmt.Sprintf("%q", part.text))
+		case varPart:
+			sb.WriteString("$" + part.text)
+			if part.index != nil {
+				sb.WriteString("(" + formatWord(part.index) + ")")
+			}
+		case scriptPart:
+			sb.WriteString("[" + part.text + "]")
+		}
+	}
+	return sb.String()
+}
+
+func TestParseScript(t *testing.T) {
+	for _, v := range []struct {
+		src   string
+		words string
+	}{
+		{"puts hello", `"puts" "hello"`},
+		{"puts {a {b} c}; set x", `"puts" "a {b} c"|"set" "x"`},
+		{"# comment \\\n still\nputs \"a $b\"", `"puts" "a "$b`},
+		{"set a($i,x) [f [g]]", `"set" "a("$i",x)" [f [g]]`},
+		{"puts ${a b}$c(d)", `"puts" $a b$c("d")`},
+		{"puts a\\\n  b \\x41\\u00e9\\101\\n", `"puts" "a" "b" "AéA\n"`},
+		{"puts $ a$", `"puts" "$" "a$"`},
+		{"puts {a\\\n   b}", `"puts" "a b"`},
+	} {
+		cmds, err := parseScript(v.src)
+		if err != nil {
+			t.Errorf("%q: %s", v.src, err)
+			continue
+		}
+		var texts []string
+		for _, cmd := range cmds {
+			var words []string
+			for _, w := range cmd.words {
+				words = append(words, formatWord(w))
+			}
+			texts = append(texts, strings.Join(words, " "))
+		}
+		if s := strings.Join(texts, "|"); s != v.words {
+			t.Errorf("%q: expected %s, got %s", v.src, v.words, s)
+		}
+	}
+}
+
+func TestParseScriptError(t *testing.T) {
+	for _, src := range []string{"puts {a", "puts \"a", "puts [a", "puts {a}b", "puts \"a\"b", "puts $a(b"} {
+		if _, err := parseScript(src); !errors.Is(err, ErrScriptSyntax) {
+			t.Errorf("%q: expected ErrScriptSyntax, got %v", src, err)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	for _, v := range []struct {
+		s     string
+		elems string
+	}{
+		{"a b  c", "a|b|c"},
+		{"{a 1} args", "a 1|args"},
+		{` "a b" c\ d {}`, "a b|c d|"},
+	} {
+		elems, err := splitList(v.s)
+		if err != nil {
+			t.Errorf("%q: %s", v.s, err)
+		} else if s := strings.Join(elems, "|"); s != v.elems {
+			t.Errorf("%q: expected %s, got %s", v.s, v.elems, s)
+		}
+	}
+	if _, err := splitList("{a}b"); err == nil {
+		t.Errorf("expected error of list {a}b")
+	}
+}
@@ -0,0 +1,1001 @@
+package tbcload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrVMUnsupported means instruction can not be executed by VM
+var ErrVMUnsupported = errors.New("instruction is not supported by VM")
+
+// ErrOperandRange means operand of instruction refer to missing literal, local or aux data
+var ErrOperandRange = errors.New("operand is out of range")
+
+// ErrStepLimit means VM executed more than MaxSteps instructions
+var ErrStepLimit = errors.New("step limit of VM is reached")
+
+// ReturnCode is completion code of Tcl command
+type ReturnCode int
+
+// Return codes of Tcl, TCL_OK etc.
+const (
+	CodeOK ReturnCode = iota
+	CodeError
+	CodeReturn
+	CodeBreak
+	CodeContinue
+)
+
+var returnCodeNames = []string{"ok", "error", "return", "break", "continue"}
+
+func (c ReturnCode) String() string {
+	if c >= 0 && int(c) < len(returnCodeNames) {
+		return returnCodeNames[c]
+	}
+	return strconv.Itoa(int(c))
+}
+
+// Exception is completion of command other than TCL_OK, which can be caught
+// by catch. CommandHandler return it to raise break, continue or return,
+// other errors of CommandHandler are raised as TCL_ERROR
+type Exception struct {
+	Code   ReturnCode
+	Result string //error message, or value of return
+
+	returnCode ReturnCode //return -code of CodeReturn
+}
+
+func (e *Exception) Error() string {
+	switch e.Code {
+	case CodeError:
+		return e.Result
+	case CodeBreak, CodeContinue:
+		return fmt.Sprintf("invoked %q outside of a loop", e.Code.String())
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Result)
+}
+
+// CommandHandler execute command invoked by bytecode, args[0] is name of command
+type CommandHandler interface {
+	Invoke(args []string) (string, error)
+}
+
+// CommandFunc is function as CommandHandler
+type CommandFunc func(args []string) (string, error)
+
+// Invoke call f
+func (f CommandFunc) Invoke(args []string) (string, error) {
+	return f(args)
+}
+
+// LogHandler write each command to W as a line of Tcl, and return empty result
+type LogHandler struct {
+	W io.Writer
+}
+
+// Invoke log args
+func (h *LogHandler) Invoke(args []string) (string, error) {
+	_, err := fmt.Fprintln(h.W, formatList(args))
+	return "", err
+}
+
+// defaultMaxSteps stop script of endless loop
+const defaultMaxSteps = 1000000
+
+// maxNestingLevel is max level of procedure calls, as interp recursionlimit
+const maxNestingLevel = 1000
+
+// VM execute ByteCode in memory without Tcl interpreter, for dry-running
+// tbc files. Every command invoked by bytecode is routed to Handler, unless
+// Builtins is true, then commands proc, return, error, break, continue,
+// global and procedures defined by script are run by VM
+type VM struct {
+	Handler    CommandHandler
+	TclVersion string //Tcl version of opcode table, by header of file if ""
+	MaxSteps   int    //max number of executed instructions, 0 for no limit
+	Builtins   bool   //run built-in commands and procedures in VM, instead of Handler
+
+	opTable []InstructionDesc
+	globals *vmFrame
+	procs   map[string]*Procedure
+	checked map[*ByteCode]error
+	steps   int
+	level   int
+}
+
+// NewVM create VM, which log commands to w
+func NewVM(w io.Writer) *VM {
+	vm := &VM{Handler: &LogHandler{W: w}, MaxSteps: defaultMaxSteps,
+		procs: map[string]*Procedure{}, checked: map[*ByteCode]error{}}
+	vm.globals = vm.newFrame(nil)
+	return vm
+}
+
+// Run execute blocks of f in order, bcproc block defines its procedure.
+// It returns result of last block
+func (vm *VM) Run(f *File) (res string, err error) {
+	for _, b := range f.Blocks() {
+		if vm.opTable, err = fileOpTable(b.Header, vm.TclVersion); err != nil {
+			return
+		}
+		if b.Command == BlockProc {
+			res, err = vm.defineProc([]string{"proc", b.Name, b.Args, ""}, b.Proc)
+		} else {
+			res, err = vm.execute(vm.globals, b.ByteCode)
+		}
+		var exc *Exception
+		if errors.As(err, &exc) && exc.Code == CodeReturn && exc.returnCode == CodeOK {
+			res, err = exc.Result, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Var return value of global variable name, which can be array element as "a(b)"
+func (vm *VM) Var(name string) (string, bool) {
+	v, elem, isElem := vm.globals.lookup(name)
+	if isElem {
+		s, ok := v.array[elem]
+		return s, ok
+	}
+	return v.value, v.exists && v.array == nil
+}
+
+// variable is scalar or array variable
+type variable struct {
+	value  string
+	array  map[string]string //non-nil if variable is array
+	exists bool
+}
+
+// vmFrame is variables of top level or procedure call
+type vmFrame struct {
+	locals     []*variable //compiled locals of procedure
+	localIndex map[string]int
+	names      map[string]*variable //variables which are not compiled locals
+}
+
+func (vm *VM) newFrame(proc *Procedure) *vmFrame {
+	fr := &vmFrame{localIndex: map[string]int{}, names: map[string]*variable{}}
+	if proc == nil {
+		return fr
+	}
+	for index, local := range proc.Locals {
+		fr.locals = append(fr.locals, &variable{})
+		if local.Flags&varTemporary == 0 && local.Name != "" {
+			fr.localIndex[local.Name] = index
+		}
+	}
+	return fr
+}
+
+// splitVarName split "a(b)" into "a" and "b"
+func splitVarName(name string) (array, elem string, isElem bool) {
+	if open := strings.IndexByte(name, '('); open > 0 && strings.HasSuffix(name, ")") {
+		return name[:open], name[open+1 : len(name)-1], true
+	}
+	return name, "", false
+}
+
+// variable return variable of name, created if it does not exist
+func (fr *vmFrame) variable(name string) *variable {
+	if index, ok := fr.localIndex[name]; ok {
+		return fr.locals[index]
+	}
+	v := fr.names[name]
+	if v == nil {
+		v = &variable{}
+		fr.names[name] = v
+	}
+	return v
+}
+
+// lookup return variable of name, and element if name is "a(b)"
+func (fr *vmFrame) lookup(name string) (v *variable, elem string, isElem bool) {
+	name, elem, isElem = splitVarName(name)
+	return fr.variable(name), elem, isElem
+}
+
+// link make name of fr refer to v, for global
+func (fr *vmFrame) link(name string, v *variable) {
+	if index, ok := fr.localIndex[name]; ok {
+		fr.locals[index] = v
+		return
+	}
+	fr.names[name] = v
+}
+
+func (v *variable) get(name string, elem string, isElem bool) (string, error) {
+	switch {
+	case !v.exists:
+		return "", exprError("can't read %q: no such variable", varName(name, elem, isElem))
+	case isElem && v.array == nil:
+		return "", exprError("can't read %q: variable isn't array", varName(name, elem, isElem))
+	case !isElem && v.array != nil:
+		return "", exprError("can't read %q: variable is array", name)
+	case isElem:
+		s, ok := v.array[elem]
+		if !ok {
+			return "", exprError("can't read %q: no such element in array", varName(name, elem, isElem))
+		}
+		return s, nil
+	}
+	return v.value, nil
+}
+
+func (v *variable) set(name string, elem string, isElem bool, value string) (string, error) {
+	switch {
+	case isElem && v.exists && v.array == nil:
+		return "", exprError("can't set %q: variable isn't array", varName(name, elem, isElem))
+	case !isElem && v.array != nil:
+		return "", exprError("can't set %q: variable is array", name)
+	case isElem:
+		if v.array == nil {
+			v.array = map[string]string{}
+		}
+		v.array[elem] = value
+	default:
+		v.value = value
+	}
+	v.exists = true
+	return value, nil
+}
+
+func varName(name, elem string, isElem bool) string {
+	if isElem {
+		return name + "(" + elem + ")"
+	}
+	return name
+}
+
+// vmValue is value on stack, proc is set for procedure literal
+type vmValue struct {
+	s    string
+	proc *Procedure
+}
+
+// execution is state of bytecode executed in frame
+type execution struct {
+	vm      *VM
+	fr      *vmFrame
+	bc      *ByteCode
+	locals  []*CompiledLocal
+	stack   []vmValue
+	catches []int //stack depth of beginCatch
+	expands []int //stack depth of expandStart
+	result  string
+	code    ReturnCode
+	fault   error //error of VM, which can not be caught
+}
+
+// execute bytecode bc in frame fr
+func (vm *VM) execute(fr *vmFrame, bc *ByteCode) (string, error) {
+	return vm.executeProc(fr, bc, nil)
+}
+
+func (vm *VM) executeProc(fr *vmFrame, bc *ByteCode, locals []*CompiledLocal) (string, error) {
+	if err := vm.check(bc); err != nil {
+		return "", err
+	}
+	code, err := disassemble(vm.opTable, bc.Code)
+	if err != nil {
+		return "", err
+	}
+	at := map[int]int{}
+	for index, ins := range code {
+		at[ins.PC] = index
+	}
+	x := &execution{vm: vm, fr: fr, bc: bc, locals: locals}
+	for pc := 0; ; {
+		index, ok := at[pc]
+		if !ok {
+			return "", fmt.Errorf("%w: pc %d is not an instruction", ErrVMUnsupported, pc)
+		}
+		if vm.MaxSteps > 0 && vm.steps >= vm.MaxSteps {
+			return "", fmt.Errorf("%w: %d instructions", ErrStepLimit, vm.steps)
+		}
+		vm.steps++
+		ins := &code[index]
+		if ins.Name == "done" {
+			return x.pop().s, x.fault
+		}
+		next, err := x.step(ins)
+		if x.fault != nil {
+			return "", fmt.Errorf("pc %d: %s: %w", ins.PC, ins.Name, x.fault)
+		}
+		var exc *Exception
+		if err != nil && !errors.As(err, &exc) {
+			return "", fmt.Errorf("pc %d: %s: %w", ins.PC, ins.Name, err)
+		}
+		if exc != nil {
+			if next, ok = x.raise(ins.PC, exc); !ok {
+				return "", exc
+			}
+		}
+		pc = next
+	}
+}
+
+// check verify stack depth of bc once, so stack does not underflow
+func (vm *VM) check(bc *ByteCode) error {
+	err, ok := vm.checked[bc]
+	if !ok {
+		var g *CFG
+		if g, err = newCFG(vm.opTable, bc, nil); err == nil {
+			_, err = g.StackDepth()
+		}
+		vm.checked[bc] = err
+	}
+	return err
+}
+
+// raise find exception range of exc at pc, return pc to continue
+func (x *execution) raise(pc int, exc *Exception) (int, bool) {
+	var inner *ExceptionRange
+	for _, r := range x.bc.ExceptionRanges {
+		if pc < r.CodeOffset || pc >= r.CodeOffset+r.NumCodeBytes {
+			continue
+		}
+		//loop ranges only handle break and continue
+		if r.Type == LoopExceptionRange && exc.Code != CodeBreak && exc.Code != CodeContinue {
+			continue
+		}
+		if inner == nil || r.NestingLevel > inner.NestingLevel {
+			inner = r
+		}
+	}
+	switch {
+	case inner == nil:
+		return 0, false
+	case inner.Type == LoopExceptionRange && exc.Code == CodeBreak:
+		return inner.BreakOffset, true
+	case inner.Type == LoopExceptionRange:
+		return inner.ContinueOffset, true
+	}
+	if len(x.catches) > 0 {
+		x.stack = x.stack[:x.catches[len(x.catches)-1]]
+	}
+	x.result, x.code = exc.Result, exc.Code
+	return inner.CatchOffset, true
+}
+
+func (x *execution) push(s string) {
+	x.stack = append(x.stack, vmValue{s: s})
+}
+
+func (x *execution) pop() vmValue {
+	if len(x.stack) == 0 {
+		x.fault = ErrStackDepth
+		return vmValue{}
+	}
+	v := x.stack[len(x.stack)-1]
+	x.stack = x.stack[:len(x.stack)-1]
+	return v
+}
+
+// popN pop n values, in order of push, nil if stack has not n values
+func (x *execution) popN(n int) (res []string) {
+	if n > len(x.stack) || n < 0 {
+		x.fault = ErrStackDepth
+		return nil
+	}
+	for _, v := range x.stack[len(x.stack)-n:] {
+		res = append(res, v.s)
+	}
+	x.stack = x.stack[:len(x.stack)-n]
+	return
+}
+
+func (x *execution) literal(index int) vmValue {
+	if index >= len(x.bc.Literals) {
+		x.fault = fmt.Errorf("literal %d: %w", index, ErrOperandRange)
+		return vmValue{}
+	}
+	lit := x.bc.Literals[index]
+	return vmValue{s: lit.Value, proc: lit.Proc}
+}
+
+// local return compiled local of index
+func (x *execution) local(index int) (*variable, string) {
+	if index >= len(x.fr.locals) || index >= len(x.locals) {
+		x.fault = fmt.Errorf("local %d: %w", index, ErrOperandRange)
+		return &variable{}, ""
+	}
+	return x.fr.locals[index], x.locals[index].Name
+}
+
+// varOperand return variable of instruction, from operand or stack
+func (x *execution) varOperand(ins *Instruction, name string) (v *variable, varName, elem string, isElem bool) {
+	isArray := strings.Contains(name, "Array")
+	if isArray {
+		elem, isElem = x.pop().s, true
+	}
+	switch {
+	case strings.HasSuffix(name, "Stk") && !isArray && !strings.Contains(name, "Scalar"):
+		//loadStk storeStk etc. name may be "a(b)"
+		varName = x.pop().s
+		v, elem, isElem = x.fr.lookup(varName)
+		varName, _, _ = splitVarName(varName)
+	case strings.HasSuffix(name, "Stk"):
+		varName = x.pop().s
+		v = x.fr.variable(varName)
+	default:
+		v, varName = x.local(ins.Operands[0])
+	}
+	return
+}
+
+// baseName return name of instruction without operand size, e.g. "push"
+func baseName(name string) string {
+	if strings.HasSuffix(name, "1Imm") {
+		return strings.TrimSuffix(name, "1Imm") + "Imm"
+	}
+	return strings.TrimRight(name, "14")
+}
+
+// step execute ins, return pc of next instruction
+func (x *execution) step(ins *Instruction) (next int, err error) {
+	next = ins.PC + ins.Size
+	name := baseName(ins.Name)
+	switch name {
+	case "push":
+		x.stack = append(x.stack, x.literal(ins.Operands[0]))
+	case "pop":
+		x.pop()
+	case "dup":
+		v := x.pop()
+		x.stack = append(x.stack, v, v)
+	case "over":
+		if n := len(x.stack) - 1 - ins.Operands[0]; n >= 0 {
+			x.stack = append(x.stack, x.stack[n])
+		} else {
+			x.fault = ErrStackDepth
+		}
+	case "reverse":
+		values := x.popN(ins.Operands[0])
+		for index := len(values) - 1; index >= 0; index-- {
+			x.push(values[index])
+		}
+	case "strcat", "concat":
+		x.push(strings.Join(x.popN(ins.Operands[0]), ""))
+	case "invokeStk":
+		values := x.stack[len(x.stack)-min(ins.Operands[0], len(x.stack)):]
+		var proc *Procedure
+		if len(values) == 4 {
+			proc = values[3].proc
+		}
+		err = x.invoke(x.popN(ins.Operands[0]), proc)
+	case "invokeExpanded":
+		if len(x.expands) == 0 {
+			return next, fmt.Errorf("%w: no expandStart", ErrStackDepth)
+		}
+		mark := x.expands[len(x.expands)-1]
+		x.expands = x.expands[:len(x.expands)-1]
+		err = x.invoke(x.popN(len(x.stack)-mark), nil)
+	case "expandStart":
+		x.expands = append(x.expands, len(x.stack))
+	case "expandStkTop":
+		var elems []string
+		if elems, err = listOf(x.pop().s); err == nil {
+			for _, e := range elems {
+				x.push(e)
+			}
+		}
+	case "evalStk", "exprStk":
+		src := x.pop().s
+		var bc *ByteCode
+		if bc, err = compileFragment(x.vm.opTable, src, name == "exprStk"); err != nil {
+			return next, &Exception{Code: CodeError, Result: err.Error()}
+		}
+		var res string
+		if res, err = x.vm.execute(x.fr, bc); err == nil {
+			x.push(res)
+		}
+	case "jump":
+		next = ins.PC + ins.Operands[0]
+	case "jumpTrue", "jumpFalse":
+		var b bool
+		if b, err = booleanOf(x.pop().s); err == nil && b == (name == "jumpTrue") {
+			next = ins.PC + ins.Operands[0]
+		}
+	case "jumpTable":
+		key := x.pop().s
+		if aux := x.auxData(ins.Operands[0], JumpTableAuxData); aux != nil {
+			for _, entry := range aux.JumpTable.Entries {
+				if entry.Key == key {
+					next = ins.PC + entry.Offset
+				}
+			}
+		}
+	case "break":
+		err = &Exception{Code: CodeBreak}
+	case "continue":
+		err = &Exception{Code: CodeContinue}
+	case "beginCatch":
+		x.catches = append(x.catches, len(x.stack))
+	case "endCatch":
+		if len(x.catches) > 0 {
+			x.catches = x.catches[:len(x.catches)-1]
+		}
+	case "pushResult":
+		x.push(x.result)
+	case "pushReturnCode":
+		x.push(strconv.Itoa(int(x.code)))
+	case "foreach_start":
+		if ins.Name == "foreach_start4" {
+			err = x.foreachStart(ins)
+		} else {
+			next = x.foreachStart86(ins, next)
+		}
+	case "foreach_step":
+		if ins.Name == "foreach_step4" {
+			err = x.foreachStep(ins)
+		} else {
+			next, err = x.foreachStep86(ins, next)
+		}
+	case "foreach_end":
+		x.foreachEnd86()
+	case "returnImm":
+		err = x.doReturn(ReturnCode(ins.Operands[0]), ins.Operands[1])
+	case "returnStk":
+		err = x.returnStk()
+	case "startCommand", "nop", "tryCvtToNumeric":
+	case "syntax":
+		err = &Exception{Code: CodeError, Result: x.pop().s}
+	default:
+		err = x.stepValue(ins, name)
+	}
+	return
+}
+
+// stepValue execute instruction of variables and expression
+func (x *execution) stepValue(ins *Instruction, name string) (err error) {
+	var res string
+	switch {
+	case binaryOperators[name] != exprOperator{} || name == "strcmp":
+		b := x.pop().s
+		res, err = binaryOp(name, x.pop().s, b)
+	case unaryOperators[name] != "":
+		res, err = unaryOp(name, x.pop().s)
+	case name == "callBuiltinFunc":
+		if ins.Operands[0] >= len(builtinFuncs) {
+			return fmt.Errorf("builtin function %d: %w", ins.Operands[0], ErrOperandRange)
+		}
+		f := builtinFuncs[ins.Operands[0]]
+		res, err = mathFunc(f.name, x.popN(f.numArgs))
+	case name == "callFunc":
+		//function name and arguments
+		if ins.Operands[0] < 1 {
+			return fmt.Errorf("function %d: %w", ins.Operands[0], ErrOperandRange)
+		}
+		args := x.popN(ins.Operands[0])
+		if x.fault != nil {
+			return nil
+		}
+		res, err = mathFunc(args[0], args[1:])
+	case name == "strlen":
+		res = strconv.Itoa(len([]rune(x.pop().s)))
+	case name == "strindex":
+		index := x.pop().s
+		res, err = stringIndex(x.pop().s, index)
+	case name == "strmatch":
+		s := x.pop().s
+		res = boolString(stringMatch(x.pop().s, s, ins.Operands[0] != 0))
+	case name == "list":
+		res = formatList(x.popN(ins.Operands[0]))
+	case name == "listIndex" || name == "listindex":
+		index := x.pop().s
+		res, err = listIndex(x.pop().s, index)
+	case name == "listLength" || name == "listlength":
+		var elems []string
+		elems, err = listOf(x.pop().s)
+		res = strconv.Itoa(len(elems))
+	case name == "listIndexImm":
+		var elems []string
+		if elems, err = listOf(x.pop().s); err == nil {
+			if i := immIndex(ins.Operands[0], len(elems)); i >= 0 && i < len(elems) {
+				res = elems[i]
+			}
+		}
+	default:
+		return x.stepVar(ins, name)
+	}
+	x.push(res)
+	return
+}
+
+// stepVar execute instruction of variable
+func (x *execution) stepVar(ins *Instruction, name string) (err error) {
+	var op string
+	for _, prefix := range []string{"load", "store", "incr", "append", "lappend", "exist", "unset"} {
+		if strings.HasPrefix(name, prefix) {
+			op = prefix
+		}
+	}
+	if op == "" || strings.HasPrefix(name, "unset") {
+		return fmt.Errorf("%w: %s", ErrVMUnsupported, ins.Name)
+	}
+	var value string
+	switch {
+	case op == "load" || op == "exist":
+	case strings.HasSuffix(name, "Imm"):
+		value = strconv.Itoa(ins.Operands[len(ins.Operands)-1])
+		name = strings.TrimSuffix(name, "Imm")
+	default:
+		value = x.pop().s
+	}
+	v, varName, elem, isElem := x.varOperand(ins, name)
+	var res string
+	switch op {
+	case "load":
+		res, err = v.get(varName, elem, isElem)
+	case "exist":
+		_, e := v.get(varName, elem, isElem)
+		res = boolString(e == nil)
+	case "store":
+		res, err = v.set(varName, elem, isElem, value)
+	case "incr":
+		res, err = x.incr(v, varName, elem, isElem, value)
+	case "append", "lappend":
+		old, e := v.get(varName, elem, isElem)
+		if e != nil {
+			old = ""
+		}
+		if op == "lappend" {
+			if _, err = listOf(old); err != nil {
+				return
+			}
+			if old != "" {
+				old += " "
+			}
+			value = quoteWord(value)
+		}
+		res, err = v.set(varName, elem, isElem, old+value)
+	}
+	x.push(res)
+	return
+}
+
+func (x *execution) incr(v *variable, varName, elem string, isElem bool, amount string) (string, error) {
+	old, err := v.get(varName, elem, isElem)
+	if err != nil {
+		old = "0"
+	}
+	a, err := integerOf(old)
+	if err != nil {
+		return "", exprError("expected integer but got %q", old)
+	}
+	b, err := integerOf(amount)
+	if err != nil {
+		return "", exprError("expected integer but got %q", amount)
+	}
+	return v.set(varName, elem, isElem, strconv.FormatInt(a+b, 10))
+}
+
+func (x *execution) auxData(index int, t AuxDataType) *AuxData {
+	if index >= len(x.bc.AuxData) || x.bc.AuxData[index].Type != t {
+		x.fault = fmt.Errorf("aux data %d: %w", index, ErrOperandRange)
+		return nil
+	}
+	return x.bc.AuxData[index]
+}
+
+// foreachStart set loop counter to -1, value lists are in temps
+func (x *execution) foreachStart(ins *Instruction) error {
+	aux := x.auxData(ins.Operands[0], ForeachAuxData)
+	if aux == nil {
+		return nil
+	}
+	v, _ := x.local(aux.Foreach.LoopCtTemp)
+	v.value, v.exists = "-1", true
+	return nil
+}
+
+// foreachStep assign loop variables of next iteration, push 0 at end of lists
+func (x *execution) foreachStep(ins *Instruction) error {
+	aux := x.auxData(ins.Operands[0], ForeachAuxData)
+	if aux == nil {
+		return nil
+	}
+	info := aux.Foreach
+	ct, _ := x.local(info.LoopCtTemp)
+	count, _ := strconv.Atoi(ct.value)
+	count++
+	ct.value = strconv.Itoa(count)
+	var lists []string
+	for index := range info.VarLists {
+		list, _ := x.local(info.FirstValueTemp + index)
+		lists = append(lists, list.value)
+	}
+	more, err := x.foreachAssign(info, count, lists)
+	if err == nil {
+		x.push(boolString(more))
+	}
+	return err
+}
+
+// foreachStart86 push iteration count and aux index over value lists,
+// and jump to foreach_step of Tcl 8.6, which is LoopCtTemp before body
+func (x *execution) foreachStart86(ins *Instruction, next int) int {
+	aux := x.auxData(ins.Operands[0], ForeachAuxData)
+	if aux == nil {
+		return next
+	}
+	if len(x.stack) < len(aux.Foreach.VarLists) {
+		x.fault = ErrStackDepth
+		return next
+	}
+	x.stack = append(x.stack, vmValue{s: "0"}, vmValue{s: strconv.Itoa(ins.Operands[0])})
+	return next - aux.Foreach.LoopCtTemp
+}
+
+// foreach86 return ForeachInfo and index of iteration count on stack,
+// pushed by foreach_start of Tcl 8.6
+func (x *execution) foreach86() (*ForeachInfo, int) {
+	top := len(x.stack) - 1
+	if top < 1 {
+		x.fault = ErrStackDepth
+		return nil, 0
+	}
+	index, err := strconv.Atoi(x.stack[top].s)
+	if err != nil {
+		x.fault = fmt.Errorf("foreach info %q: %w", x.stack[top].s, ErrOperandRange)
+		return nil, 0
+	}
+	aux := x.auxData(index, ForeachAuxData)
+	if aux == nil {
+		return nil, 0
+	}
+	if top-1 < len(aux.Foreach.VarLists) {
+		x.fault = ErrStackDepth
+		return nil, 0
+	}
+	return aux.Foreach, top - 1
+}
+
+// foreachStep86 assign loop variables of next iteration, and jump back
+// to body of Tcl 8.6 foreach, value lists are on stack
+func (x *execution) foreachStep86(ins *Instruction, next int) (int, error) {
+	info, ct := x.foreach86()
+	if info == nil {
+		return next, nil
+	}
+	count, _ := strconv.Atoi(x.stack[ct].s)
+	var lists []string
+	for _, v := range x.stack[ct-len(info.VarLists) : ct] {
+		lists = append(lists, v.s)
+	}
+	more, err := x.foreachAssign(info, count, lists)
+	if !more || err != nil {
+		return next, err
+	}
+	x.stack[ct].s = strconv.Itoa(count + 1)
+	return ins.PC + info.LoopCtTemp, nil
+}
+
+// foreachEnd86 drop value lists, iteration count and info of Tcl 8.6 foreach
+func (x *execution) foreachEnd86() {
+	if info, ct := x.foreach86(); info != nil {
+		x.stack = x.stack[:ct-len(info.VarLists)]
+	}
+}
+
+// foreachAssign assign loop variables of iteration count from value lists,
+// return false if lists are exhausted
+func (x *execution) foreachAssign(info *ForeachInfo, count int, lists []string) (bool, error) {
+	var elems [][]string
+	more := false
+	for index, vars := range info.VarLists {
+		list, err := listOf(lists[index])
+		if err != nil {
+			return false, err
+		}
+		elems = append(elems, list)
+		more = more || count*len(vars) < len(list)
+	}
+	if !more {
+		return false, nil
+	}
+	for index, vars := range info.VarLists {
+		for i, local := range vars {
+			var value string
+			if at := count*len(vars) + i; at < len(elems[index]) {
+				value = elems[index][at]
+			}
+			v, name := x.local(local)
+			if _, err := v.set(name, "", false, value); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// doReturn execute return of code and level
+func (x *execution) doReturn(code ReturnCode, level int) error {
+	res := x.pop().s
+	x.pop() //options
+	if level == 0 {
+		if code == CodeOK {
+			x.push(res)
+			return nil
+		}
+		return &Exception{Code: code, Result: res}
+	}
+	return &Exception{Code: CodeReturn, Result: res, returnCode: code}
+}
+
+// returnStk execute return with options on stack, as "-code error -level 1"
+func (x *execution) returnStk() error {
+	res := x.pop().s
+	opts, err := listOf(x.pop().s)
+	if err != nil {
+		return err
+	}
+	x.push("")
+	x.push(res)
+	code, level, err := returnOptions(opts)
+	if err != nil {
+		return err
+	}
+	return x.doReturn(code, level)
+}
+
+// returnOptions parse -code and -level of return
+func returnOptions(opts []string) (code ReturnCode, level int, err error) {
+	level = 1
+	for index := 0; index+1 < len(opts); index += 2 {
+		switch value := opts[index+1]; opts[index] {
+		case "-code":
+			code = -1
+			for c, name := range returnCodeNames {
+				if name == value {
+					code = ReturnCode(c)
+				}
+			}
+			if code < 0 {
+				var n int
+				if n, err = strconv.Atoi(value); err != nil {
+					return 0, 0, exprError("bad completion code %q", value)
+				}
+				code = ReturnCode(n)
+			}
+		case "-level":
+			if level, err = strconv.Atoi(value); err != nil || level < 0 {
+				return 0, 0, exprError("bad -level value: expected non-negative integer but got %q", value)
+			}
+		}
+	}
+	return
+}
+
+// invoke command of words, procedure literal proc is body of "proc"
+func (x *execution) invoke(words []string, proc *Procedure) (err error) {
+	if len(words) == 0 {
+		x.push("")
+		return
+	}
+	var res string
+	name := words[0]
+	if !x.vm.Builtins {
+		name = "" //all commands are invoked by Handler
+	}
+	switch name {
+	case "proc":
+		res, err = x.vm.defineProc(words, proc)
+	case "return":
+		opts, value := words[1:], ""
+		if len(opts)%2 == 1 {
+			opts, value = opts[:len(opts)-1], opts[len(opts)-1]
+		}
+		x.push(formatList(opts))
+		x.push(value)
+		return x.returnStk()
+	case "error":
+		if len(words) < 2 {
+			return exprError("wrong # args: should be \"error message ?errorInfo? ?errorCode?\"")
+		}
+		return &Exception{Code: CodeError, Result: words[1]}
+	case "break", "continue":
+		code := map[string]ReturnCode{"break": CodeBreak, "continue": CodeContinue}[words[0]]
+		return &Exception{Code: code}
+	case "global":
+		for _, name := range words[1:] {
+			x.fr.link(name, x.vm.globals.variable(name))
+		}
+	default:
+		if p := x.vm.procs[name]; p != nil {
+			res, err = x.vm.call(p, words)
+		} else if res, err = x.vm.Handler.Invoke(words); err != nil {
+			var exc *Exception
+			if !errors.As(err, &exc) {
+				err = &Exception{Code: CodeError, Result: err.Error()}
+			}
+		}
+	}
+	if err == nil {
+		x.result = res
+		x.push(res)
+	}
+	return
+}
+
+// defineProc define procedure of "proc name args body",
+// body which is not procedure literal is compiled
+func (vm *VM) defineProc(words []string, proc *Procedure) (string, error) {
+	if len(words) != 4 {
+		return "", exprError("wrong # args: should be \"proc name args body\"")
+	}
+	if proc == nil {
+		var err error
+		if proc, err = newProcedure(vm.opTable, words[2], words[3]); err != nil {
+			return "", &Exception{Code: CodeError, Result: err.Error()}
+		}
+		if proc == nil {
+			return "", exprError("invalid argument list %q of procedure %q", words[2], words[1])
+		}
+	}
+	vm.procs[strings.TrimPrefix(words[1], "::")] = proc
+	return "", nil
+}
+
+// call procedure with words as arguments
+func (vm *VM) call(proc *Procedure, words []string) (string, error) {
+	if vm.level >= maxNestingLevel {
+		return "", exprError("too many nested evaluations (infinite loop?)")
+	}
+	fr := vm.newFrame(proc)
+	args := words[1:]
+	for index := 0; index < proc.NumArgs && index < len(proc.Locals); index++ {
+		local := proc.Locals[index]
+		switch {
+		case index == proc.NumArgs-1 && local.Name == "args":
+			fr.locals[index].value = formatList(args)
+			args = nil
+		case len(args) > 0:
+			fr.locals[index].value = args[0]
+			args = args[1:]
+		case local.Default != nil:
+			fr.locals[index].value = local.Default.Value
+		default:
+			return "", vm.wrongArgs(proc, words[0])
+		}
+		fr.locals[index].exists = true
+	}
+	if len(args) > 0 {
+		return "", vm.wrongArgs(proc, words[0])
+	}
+	vm.level++
+	defer func() { vm.level-- }()
+	res, err := vm.executeProc(fr, proc.ByteCode, proc.Locals)
+	var exc *Exception
+	if errors.As(err, &exc) && exc.Code == CodeReturn {
+		if exc.returnCode != CodeOK {
+			return "", &Exception{Code: exc.returnCode, Result: exc.Result}
+		}
+		return exc.Result, nil
+	}
+	return res, err
+}
+
+func (vm *VM) wrongArgs(proc *Procedure, name string) error {
+	words := []string{name}
+	for index := 0; index < proc.NumArgs && index < len(proc.Locals); index++ {
+		local := proc.Locals[index]
+		switch {
+		case index == proc.NumArgs-1 && local.Name == "args":
+			words = append(words, "?arg ...?")
+		case local.Default != nil:
+			words = append(words, "?"+local.Name+"?")
+		default:
+			words = append(words, local.Name)
+		}
+	}
+	return exprError("wrong # args: should be %q", strings.Join(words, " "))
+}
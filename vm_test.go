@@ -0,0 +1,217 @@
+package tbcload
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// newBuiltinVM create VM running procedures and built-in commands
+func newBuiltinVM(w io.Writer) *VM {
+	vm := NewVM(w)
+	vm.Builtins = true
+	return vm
+}
+
+func TestVMRun(t *testing.T) {
+	var out bytes.Buffer
+	vm := newBuiltinVM(&out)
+	if _, err := vm.Run(readTestFile(t, "testdata/hello.tbc")); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != "puts {Hello world}\n" {
+		t.Errorf("expected puts {Hello world}, got %q", s)
+	}
+
+	//procedure of bcproc block is called by following block
+	out.Reset()
+	if _, err := newBuiltinVM(&out).Run(readTestFile(t, "testdata/blocks.tbc")); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != "puts {Hello world}\n" {
+		t.Errorf("expected puts {Hello world} of blocks, got %q", s)
+	}
+
+	out.Reset()
+	vm = newBuiltinVM(&out)
+	if _, err := vm.Run(readTestFile(t, "testdata/catch.tbc")); err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := vm.Var("i"); i != "3" || out.String() != "foo\n" {
+		t.Errorf("expected i=3 and foo called, got i=%s, %q", i, out.String())
+	}
+
+	//procedures defined by one file are called by next file
+	out.Reset()
+	if _, err := vm.Run(readTestFile(t, "testdata/foreach.tbc")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Compile("f {1 2 3} {x y}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vm.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != "puts 12x\nputs 3y\n" {
+		t.Errorf("expected puts 12x and 3y, got %q", s)
+	}
+}
+
+func TestVMScript(t *testing.T) {
+	for _, v := range []struct {
+		version string
+		script  string
+		vars    string //name=value, separated by space
+		log     string
+	}{
+		{"", "proc fib n {\n if {$n < 2} {return $n}\n return [expr {[fib [expr {$n - 1}]] + [fib [expr {$n - 2}]]}]\n}\nset r [fib 10]",
+			"r=55", ""},
+		{"", "set c [catch {error boom} msg]", "c=1 msg=boom", ""},
+		{"", "set c [catch {set x 1} msg]", "c=0 msg=1 x=1", ""},
+		{"", "proc sum args {\n set s 0\n foreach {a b} $args {incr s $a; incr s [expr {$b * 10}]}\n return $s\n}\nset r [sum 1 2 3 4]",
+			"r=64", ""},
+		{"", "set s 0\nfor {set i 0} {$i < 10} {incr i} {\n if {$i == 2} continue\n if {$i > 4} break\n incr s $i\n}",
+			"s=8 i=5", ""},
+		{"", "set a(x) 1\nincr a(x) 2\nset k x\nset r $a($k)", "r=3 a(x)=3", ""},
+		{"", "set e {1 + 2 * 3}\nset r [expr $e]", "r=7", ""},
+		{"", "set r [expr {7 / -2}]\nset m [expr {-7 % 3}]\nset d [expr {1.5 * 2}]",
+			"r=-4 m=2 d=3.0", ""},
+		{"8.4", "set b [expr {\"a\" eq \"a\" && 2 > 10}]\nset c [expr {1 < 2 ? \"x\" : \"y\"}]", "b=0 c=x", ""},
+		{"", "set r [expr {abs(-3) + round(2.6) + max(1)}]", "", ""},
+		{"8.4", "append s a\nappend s bc\nset t x\nappend t $s", "s=abc t=xabc", ""},
+		{"", "puts [list a {b c}]\nset r [open file w]", "r=", "list a {b c}\nputs {}\nopen file w\n"},
+		{"", "proc p {} {global g; set g 1}\np", "g=1", ""},
+	} {
+		var out bytes.Buffer
+		f, err := (&Compiler{TclVersion: v.version}).Compile(v.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm := newBuiltinVM(&out)
+		_, err = vm.Run(f)
+		if v.vars == "" {
+			if err == nil {
+				t.Errorf("%q: expected error", v.script)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", v.script, err)
+			continue
+		}
+		for _, nv := range strings.Split(v.vars, " ") {
+			name, value, _ := strings.Cut(nv, "=")
+			if s, _ := vm.Var(name); s != value {
+				t.Errorf("%q: expected %s=%s, got %q", v.script, name, value, s)
+			}
+		}
+		if v.log != "" && out.String() != v.log {
+			t.Errorf("%q: expected log %q, got %q", v.script, v.log, out.String())
+		}
+	}
+}
+
+func TestVMHandler(t *testing.T) {
+	f, err := Compile("set c [catch {exec rm -rf /} msg]\nset r [clock seconds]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(nil)
+	var calls []string
+	vm.Handler = CommandFunc(func(args []string) (string, error) {
+		calls = append(calls, args[0])
+		if args[0] == "exec" {
+			return "", errors.New("exec is not allowed")
+		}
+		return "42", nil
+	})
+	if _, err = vm.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := vm.Var("msg")
+	r, _ := vm.Var("r")
+	if msg != "exec is not allowed" || r != "42" || strings.Join(calls, " ") != "exec clock" {
+		t.Errorf("unexpected msg=%q r=%q calls=%v", msg, r, calls)
+	}
+}
+
+func TestVMStepLimit(t *testing.T) {
+	f, err := Compile("while 1 {}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := newBuiltinVM(nil)
+	vm.MaxSteps = 1000
+	if _, err = vm.Run(f); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("expected ErrStepLimit, got %v", err)
+	}
+	f, _ = Compile("proc f {} {f}\nf")
+	var exc *Exception
+	if _, err = newBuiltinVM(nil).Run(f); !errors.As(err, &exc) || !strings.Contains(exc.Result, "too many nested") {
+		t.Errorf("expected too many nested evaluations, got %v", err)
+	}
+}
+
+func TestVMForeach86(t *testing.T) {
+	//proc f {} {foreach {a b} {1 2 3} c {x y} {puts $a $b $c}}; f
+	body := &ByteCode{Literals: stringLiterals("1 2 3", "x y", "puts", ""), Code: assemble(tcl86OpTable,
+		"push1 0", "push1 1", "foreach_start 0",
+		"push1 2", "loadScalar1 0", "loadScalar1 1", "loadScalar1 2", "invokeStk1 4", "pop",
+		"foreach_step", "foreach_end", "push1 3", "done"),
+		AuxData: []*AuxData{{Type: ForeachAuxData, Foreach: &ForeachInfo{LoopCtTemp: -11, VarLists: [][]int{{0, 1}, {2}}}}},
+	}
+	proc := &Procedure{ByteCode: body, Locals: []*CompiledLocal{{Name: "a"}, {Name: "b", Index: 1}, {Name: "c", Index: 2}}}
+	lits := stringLiterals("proc", "f", "", "")
+	lits[3] = &Literal{Type: LiteralProc, Proc: proc}
+	code := assemble(tcl86OpTable, "push1 0", "push1 1", "push1 2", "push1 3", "invokeStk1 4", "pop",
+		"push1 1", "invokeStk1 1", "done")
+	f := &File{Block: Block{Header: header86, ByteCode: &ByteCode{Code: code, Literals: lits}}}
+	var out bytes.Buffer
+	if _, err := newBuiltinVM(&out).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != "puts 1 2 x\nputs 3 {} y\n" {
+		t.Errorf("expected puts 1 2 x and puts 3 {} y, got %q", s)
+	}
+}
+
+func TestVMOperandRange(t *testing.T) {
+	for _, v := range []struct {
+		code []byte
+		err  error
+	}{
+		{assemble(tcl84OpTable, "callFunc1 0", "done"), ErrOperandRange},
+		{assemble(tcl84OpTable, "push1 0", "callFunc1 2", "done"), ErrStackDepth},
+		{assemble(tcl84OpTable, "push1 0", "invokeStk4 2147483647", "done"), ErrStackDepth},
+	} {
+		f := &File{Block: Block{Header: header84, ByteCode: &ByteCode{Code: v.code, Literals: stringLiterals("abs")}}}
+		vm := NewVM(nil)
+		vm.checked[f.ByteCode] = nil //skip stack check, as file of tampered depth
+		if _, err := vm.Run(f); !errors.Is(err, v.err) {
+			t.Errorf("% x: expected %v, got %v", v.code, v.err, err)
+		}
+	}
+}
+
+func TestVMHandlerBuiltins(t *testing.T) {
+	//without Builtins, every command is routed to Handler
+	f, err := Compile("proc p {} {global g; set g 1}\np\nerror boom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	vm := NewVM(nil)
+	vm.Handler = CommandFunc(func(args []string) (string, error) {
+		calls = append(calls, args[0])
+		return "", nil
+	})
+	if _, err = vm.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(calls, " "); s != "proc p error" {
+		t.Errorf("expected proc p error routed to Handler, got %s", s)
+	}
+}
@@ -0,0 +1,521 @@
+package tbcload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// number is integer or double value of expression
+type number struct {
+	i       int64
+	f       float64
+	isFloat bool
+}
+
+func (n number) float() float64 {
+	if n.isFloat {
+		return n.f
+	}
+	return float64(n.i)
+}
+
+func (n number) String() string {
+	if !n.isFloat {
+		return strconv.FormatInt(n.i, 10)
+	}
+	return formatDouble(n.f)
+}
+
+// formatDouble format f as Tcl, integral value has ".0"
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// parseNumber parse s as Tcl integer or double, white space around is allowed
+func parseNumber(s string) (n number, ok bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsRune(s, '_') {
+		return n, false
+	}
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return number{i: i}, true
+	}
+	if u, err := strconv.ParseUint(strings.TrimPrefix(s, "+"), 0, 64); err == nil {
+		//hex beyond int64 wraps as Tcl wide
+		return number{i: int64(u)}, true
+	}
+	digits := strings.TrimLeft(s, "+-")
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		return n, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !strings.Contains(err.Error(), "range") {
+		return n, false
+	}
+	return number{f: f, isFloat: true}, true
+}
+
+// parseBoolean parse s as Tcl boolean
+func parseBoolean(s string) (bool, bool) {
+	if n, ok := parseNumber(s); ok {
+		return n.float() != 0, true
+	}
+	t := strings.ToLower(strings.TrimSpace(s))
+	if t == "" {
+		return false, false
+	}
+	for _, v := range []struct {
+		name  string
+		value bool
+		min   int //min length of prefix
+	}{
+		{"true", true, 1}, {"false", false, 1}, {"yes", true, 1}, {"no", false, 1},
+		{"on", true, 2}, {"off", false, 2},
+	} {
+		if len(t) >= v.min && strings.HasPrefix(v.name, t) {
+			return v.value, true
+		}
+	}
+	return false, false
+}
+
+func boolString(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// exprError is error message of expression, as Tcl
+func exprError(format string, a ...interface{}) error {
+	return &Exception{Code: CodeError, Result: fmt.Sprintf(format, a...)}
+}
+
+func numberOf(s string) (number, error) {
+	if n, ok := parseNumber(s); ok {
+		return n, nil
+	}
+	return number{}, exprError("can't use non-numeric string %q as operand", s)
+}
+
+func integerOf(s string) (int64, error) {
+	n, err := numberOf(s)
+	if err == nil && n.isFloat {
+		err = exprError("can't use floating-point value %q as operand", s)
+	}
+	return n.i, err
+}
+
+func booleanOf(s string) (bool, error) {
+	if b, ok := parseBoolean(s); ok {
+		return b, nil
+	}
+	return false, exprError("expected boolean value but got %q", s)
+}
+
+// compareValues compare a and b as numbers if both are numeric, or as strings
+func compareValues(a, b string) int {
+	x, okA := parseNumber(a)
+	y, okB := parseNumber(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+	if !x.isFloat && !y.isFloat {
+		switch {
+		case x.i < y.i:
+			return -1
+		case x.i > y.i:
+			return 1
+		}
+		return 0
+	}
+	switch f, g := x.float(), y.float(); {
+	case f < g:
+		return -1
+	case f > g:
+		return 1
+	}
+	return 0
+}
+
+// binaryOp compute binary operator of instruction name
+func binaryOp(name, a, b string) (string, error) {
+	switch name {
+	case "lor", "land":
+		x, err := booleanOf(a)
+		if err != nil {
+			return "", err
+		}
+		y, err := booleanOf(b)
+		if err != nil {
+			return "", err
+		}
+		if name == "lor" {
+			return boolString(x || y), nil
+		}
+		return boolString(x && y), nil
+	case "eq", "neq", "lt", "gt", "le", "ge":
+		c := compareValues(a, b)
+		return boolString(map[string]bool{
+			"eq": c == 0, "neq": c != 0, "lt": c < 0, "gt": c > 0, "le": c <= 0, "ge": c >= 0,
+		}[name]), nil
+	case "streq":
+		return boolString(a == b), nil
+	case "strneq":
+		return boolString(a != b), nil
+	case "strcmp":
+		return strconv.Itoa(strings.Compare(a, b)), nil
+	case "listIn", "listNotIn":
+		elems, err := listOf(b)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for _, e := range elems {
+			found = found || e == a
+		}
+		return boolString(found == (name == "listIn")), nil
+	case "bitor", "bitxor", "bitand", "lshift", "rshift", "mod":
+		return integerOp(name, a, b)
+	}
+	x, err := numberOf(a)
+	if err != nil {
+		return "", err
+	}
+	y, err := numberOf(b)
+	if err != nil {
+		return "", err
+	}
+	if !x.isFloat && !y.isFloat {
+		return intArith(name, x.i, y.i)
+	}
+	f, g := x.float(), y.float()
+	switch name {
+	case "add":
+		return formatDouble(f + g), nil
+	case "sub":
+		return formatDouble(f - g), nil
+	case "mult":
+		return formatDouble(f * g), nil
+	case "div":
+		if g == 0 {
+			return "", exprError("divide by zero")
+		}
+		return formatDouble(f / g), nil
+	case "expon":
+		return formatDouble(math.Pow(f, g)), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrVMUnsupported, name)
+}
+
+func intArith(name string, x, y int64) (string, error) {
+	var r int64
+	switch name {
+	case "add":
+		r = x + y
+	case "sub":
+		r = x - y
+	case "mult":
+		r = x * y
+	case "div":
+		if y == 0 {
+			return "", exprError("divide by zero")
+		}
+		//integer division rounds toward negative infinity
+		r = x / y
+		if (x%y != 0) && ((x < 0) != (y < 0)) {
+			r--
+		}
+	case "expon":
+		if y < 0 {
+			return formatDouble(math.Pow(float64(x), float64(y))), nil
+		}
+		r = 1
+		for ; y > 0; y-- {
+			r *= x
+		}
+	default:
+		return "", fmt.Errorf("%w: %s", ErrVMUnsupported, name)
+	}
+	return strconv.FormatInt(r, 10), nil
+}
+
+func integerOp(name, a, b string) (string, error) {
+	x, err := integerOf(a)
+	if err != nil {
+		return "", err
+	}
+	y, err := integerOf(b)
+	if err != nil {
+		return "", err
+	}
+	var r int64
+	switch name {
+	case "bitor":
+		r = x | y
+	case "bitxor":
+		r = x ^ y
+	case "bitand":
+		r = x & y
+	case "lshift", "rshift":
+		if y < 0 {
+			return "", exprError("negative shift argument")
+		}
+		if name == "lshift" {
+			r = x << uint64(y)
+		} else {
+			r = x >> uint64(y)
+		}
+	case "mod":
+		if y == 0 {
+			return "", exprError("divide by zero")
+		}
+		//remainder has sign of divisor
+		if r = x % y; r != 0 && (r < 0) != (y < 0) {
+			r += y
+		}
+	}
+	return strconv.FormatInt(r, 10), nil
+}
+
+// unaryOp compute unary operator of instruction name
+func unaryOp(name, a string) (string, error) {
+	if name == "not" {
+		b, err := booleanOf(a)
+		return boolString(!b), err
+	}
+	if name == "bitnot" {
+		i, err := integerOf(a)
+		return strconv.FormatInt(^i, 10), err
+	}
+	n, err := numberOf(a)
+	if err != nil {
+		return "", err
+	}
+	if name == "uminus" {
+		n.i, n.f = -n.i, -n.f
+	}
+	return n.String(), nil
+}
+
+// mathFunc call math function of expression
+func mathFunc(name string, args []string) (string, error) {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "::"), mathFuncPrefix)
+	numArgs := -1
+	for _, f := range builtinFuncs {
+		if f.name == name {
+			numArgs = f.numArgs
+		}
+	}
+	if numArgs < 0 {
+		return "", exprError("unknown math function %q", name)
+	}
+	if len(args) != numArgs {
+		return "", exprError("wrong # args for math function %q", name)
+	}
+	var ns []number
+	for _, arg := range args {
+		n, err := numberOf(arg)
+		if err != nil {
+			return "", err
+		}
+		ns = append(ns, n)
+	}
+	switch name {
+	case "abs":
+		if !ns[0].isFloat {
+			if ns[0].i < 0 {
+				ns[0].i = -ns[0].i
+			}
+			return ns[0].String(), nil
+		}
+		return formatDouble(math.Abs(ns[0].f)), nil
+	case "double":
+		return formatDouble(ns[0].float()), nil
+	case "int", "wide":
+		if ns[0].isFloat {
+			return strconv.FormatInt(int64(ns[0].f), 10), nil
+		}
+		return ns[0].String(), nil
+	case "round":
+		if ns[0].isFloat {
+			return strconv.FormatInt(int64(math.Round(ns[0].f)), 10), nil
+		}
+		return ns[0].String(), nil
+	case "rand":
+		return formatDouble(rand.Float64()), nil
+	case "srand":
+		return formatDouble(rand.New(rand.NewSource(ns[0].i)).Float64()), nil
+	}
+	f := map[string]func(float64) float64{
+		"acos": math.Acos, "asin": math.Asin, "atan": math.Atan, "ceil": math.Ceil,
+		"cos": math.Cos, "cosh": math.Cosh, "exp": math.Exp, "floor": math.Floor,
+		"log": math.Log, "log10": math.Log10, "sin": math.Sin, "sinh": math.Sinh,
+		"sqrt": math.Sqrt, "tan": math.Tan, "tanh": math.Tanh,
+	}[name]
+	if f != nil {
+		return formatDouble(f(ns[0].float())), nil
+	}
+	g := map[string]func(float64, float64) float64{
+		"atan2": math.Atan2, "fmod": math.Mod, "hypot": math.Hypot, "pow": math.Pow,
+	}[name]
+	return formatDouble(g(ns[0].float(), ns[1].float())), nil
+}
+
+// listOf split list s, error is Tcl error
+func listOf(s string) ([]string, error) {
+	elems, err := splitList(s)
+	if err != nil {
+		return nil, &Exception{Code: CodeError, Result: strings.TrimPrefix(err.Error(), ErrScriptSyntax.Error()+": ")}
+	}
+	return elems, nil
+}
+
+// formatList return items as canonical Tcl list
+func formatList(items []string) string {
+	var words []string
+	for _, s := range items {
+		words = append(words, quoteWord(s))
+	}
+	return strings.Join(words, " ")
+}
+
+// listIndex return element of list s at index, e.g. "2", "end", "end-1"
+func listIndex(s, index string) (string, error) {
+	elems, err := listOf(s)
+	if err != nil {
+		return "", err
+	}
+	i, err := indexOf(index, len(elems))
+	if err != nil || i < 0 || i >= len(elems) {
+		return "", err
+	}
+	return elems[i], nil
+}
+
+// indexOf parse index of Tcl, end is n-1
+func indexOf(index string, n int) (int, error) {
+	s := strings.TrimSpace(index)
+	base := 0
+	if strings.HasPrefix(s, "end") {
+		base, s = n-1, s[3:]
+		if s == "" {
+			return base, nil
+		}
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || base != 0 && s[0] != '-' && s[0] != '+' {
+		return 0, exprError("bad index %q: must be integer?[+-]integer? or end?[+-]integer?", index)
+	}
+	return base + i, nil
+}
+
+// immIndex return index encoded in operand of listIndexImm, -2 is end
+func immIndex(operand, n int) int {
+	if operand >= 0 {
+		return operand
+	}
+	return n - 1 + operand + 2
+}
+
+// stringIndex return character of s at index
+func stringIndex(s, index string) (string, error) {
+	runes := []rune(s)
+	i, err := indexOf(index, len(runes))
+	if err != nil || i < 0 || i >= len(runes) {
+		return "", err
+	}
+	return string(runes[i]), nil
+}
+
+// stringMatch match s by glob pattern of string match
+func stringMatch(pattern, s string, nocase bool) bool {
+	if nocase {
+		pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	}
+	for pattern != "" {
+		p, size := utf8.DecodeRuneInString(pattern)
+		switch p {
+		case '*':
+			for pattern = pattern[size:]; strings.HasPrefix(pattern, "*"); {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for index := range s {
+				if stringMatch(pattern, s[index:], false) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			_, n := utf8.DecodeRuneInString(s)
+			s, pattern = s[n:], pattern[size:]
+			continue
+		case '[':
+			if s == "" {
+				return false
+			}
+			c, n := utf8.DecodeRuneInString(s)
+			end := strings.IndexByte(pattern, ']')
+			if end < 0 {
+				return false
+			}
+			if !matchClass([]rune(pattern[1:end]), c) {
+				return false
+			}
+			s, pattern = s[n:], pattern[end+1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+				p, size = utf8.DecodeRuneInString(pattern)
+			}
+		}
+		c, n := utf8.DecodeRuneInString(s)
+		if s == "" || c != p {
+			return false
+		}
+		s, pattern = s[n:], pattern[size:]
+	}
+	return s == ""
+}
+
+// matchClass match c by chars of [...], e.g. a-z
+func matchClass(class []rune, c rune) bool {
+	for index := 0; index < len(class); index++ {
+		if index+2 < len(class) && class[index+1] == '-' {
+			lo, hi := class[index], class[index+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				return true
+			}
+			index += 2
+		} else if class[index] == c {
+			return true
+		}
+	}
+	return false
+}
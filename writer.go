@@ -0,0 +1,160 @@
+package tbcload
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Writer write File as TclPro .tbc file
+type Writer struct {
+	w       bufio.Writer
+	newLine string
+	header  Header //header of file being written
+	err     error  //first error of writing sections
+
+	//AuxDataExtension enable writing JumpTableAuxData and DictUpdateAuxData,
+	//in format of this package, which real tbcload can not read
+	AuxDataExtension bool
+}
+
+// NewWriter create Writer
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: *bufio.NewWriter(w), newLine: "\n"}
+}
+
+// tbcFilePrologue is written before header,
+// if File is not read from a tbc file
+const tbcFilePrologue = `if {[catch {package require tbcload 1.0} err] == 1} {
+    return -code error "[info script]: The TclPro ByteCode Loader is not available or does not support the correct version -- $err"
+}
+tbcload::bceval {
+`
+const tbcFileEpilogue = "}\n"
+
+// WriteFile write f into tbc file,
+// defaultHeader is used if Header of block is empty
+func (w *Writer) WriteFile(f *File) (err error) {
+	epilogue := f.Epilogue
+	if f.Header == (Header{}) {
+		epilogue = tbcFileEpilogue
+	}
+	if f.newLine != "" {
+		w.newLine = f.newLine
+	}
+	for index, b := range f.Blocks() {
+		prologue := b.Prologue
+		if w.header = b.Header; w.header == (Header{}) {
+			prologue, w.header = defaultPrologue(b, index == 0), defaultHeader
+		} else if index > 0 && prologue == "" {
+			prologue = defaultPrologue(b, false)
+		}
+		if err = w.header.check(); err != nil {
+			return
+		}
+		w.w.WriteString(prologue)
+		w.writeLine(w.header.String())
+		if b.Command == BlockProc && b.Proc != nil {
+			w.writeProcedure(b.Proc)
+		} else {
+			w.writeByteCode(b.ByteCode)
+		}
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.w.WriteString(epilogue)
+	return w.w.Flush()
+}
+
+// defaultPrologue return wrapper command of b, following end of previous
+// block, or loader check if b is the first block
+func defaultPrologue(b *Block, first bool) string {
+	prologue := "}\n"
+	if first {
+		prologue = strings.TrimSuffix(tbcFilePrologue, BlockEval+" {\n")
+	}
+	if b.Command == BlockProc {
+		return prologue + BlockProc + " " + formatList([]string{b.Name, b.Args}) + " {\n"
+	}
+	return prologue + BlockEval + " {\n"
+}
+
+func (w *Writer) writeLine(s string) {
+	w.w.WriteString(s)
+	w.w.WriteString(w.newLine)
+}
+func (w *Writer) writeInts(ints ...int) {
+	for index, i := range ints {
+		if index > 0 {
+			w.w.WriteByte(' ')
+		}
+		w.w.WriteString(fmt.Sprint(i))
+	}
+	w.w.WriteString(w.newLine)
+}
+
+// writeBytes write length line and ascii85 encoded src,
+// which is wrapped every maxCharsOneLine chars
+func (w *Writer) writeBytes(src []byte) {
+	w.writeInts(len(src))
+	enc := NewEncoder(&w.w)
+	enc.newLine = w.newLine
+	enc.Write(src)
+	enc.Close()
+}
+func (w *Writer) writeByteCode(bc *ByteCode) {
+	//1. procedure struct info
+	w.writeInts(bc.Info.ints()...)
+	//2. ByteCode
+	w.writeBytes(bc.Code)
+	//3. CodeDelta
+	w.writeBytes(bc.CodeDelta)
+	//4. CodeLength
+	w.writeBytes(bc.CodeLength)
+	//5. ObjectArray
+	w.writeInts(len(bc.Literals))
+	for _, lit := range bc.Literals {
+		w.writeObject(lit)
+	}
+	//6. ExcRangeArray
+	w.writeInts(len(bc.ExceptionRanges))
+	for _, r := range bc.ExceptionRanges {
+		w.writeLine(r.format())
+	}
+	//7. AuxDataArray
+	w.writeInts(len(bc.AuxData))
+	for _, aux := range bc.AuxData {
+		w.writeAuxData(aux)
+	}
+}
+func (w *Writer) writeObject(lit *Literal) {
+	w.writeLine(string(lit.Type))
+	switch lit.Type {
+	case LiteralXString:
+		w.writeBytes([]byte(w.header.toTclString(lit.Value)))
+	case LiteralProc:
+		w.writeProcedure(lit.Proc)
+	default:
+		w.writeLine(lit.Value)
+	}
+}
+func (w *Writer) writeProcedure(proc *Procedure) {
+	//1. ByteCode
+	w.writeByteCode(proc.ByteCode)
+	//2. numArgs numCompiledLocal
+	w.writeInts(proc.NumArgs, len(proc.Locals))
+	//3. for-loop {CompiledLocal}
+	for _, local := range proc.Locals {
+		hasDefault := 0
+		if local.Default != nil {
+			hasDefault = 1
+		}
+		w.writeBytes([]byte(w.header.toTclString(local.Name)))
+		w.writeInts(local.Index, hasDefault, local.Flags)
+		if local.Default != nil {
+			w.writeObject(local.Default)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tbcload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func testRoundTrip(t *testing.T, fileName string) {
+	src, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTripBytes(t, fileName, src)
+}
+
+// testRoundTripBytes check that src is written back byte-identical
+func testRoundTripBytes(t *testing.T, fileName string, src []byte) {
+	f, err := ReadFile(bytes.NewReader(src))
+	if err != nil {
+		t.Errorf("failed read %s;err=%s", fileName, err)
+		return
+	}
+	var dst bytes.Buffer
+	if err = NewWriter(&dst).WriteFile(f); err != nil {
+		t.Errorf("failed write %s;err=%s", fileName, err)
+		return
+	}
+	if !bytes.Equal(src, dst.Bytes()) {
+		t.Errorf("round trip of %s is not identical, output:\n%s", fileName, dst.Bytes())
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	testRoundTrip(t, "testdata/hello.tbc")
+	testRoundTrip(t, "testdata/catch.tbc")
+	testRoundTrip(t, "testdata/foreach.tbc")
+	testRoundTrip(t, "testdata/blocks.tbc")
+}
+
+// TestWriteFileCorpus round trip the teapot tbc10 corpus written by TclPro,
+// it is skipped if the corpus can not be downloaded
+func TestWriteFileCorpus(t *testing.T) {
+	for _, fileName := range fileNames {
+		r, err := http.Get(fmt.Sprintf("%s/%s", uriPath, fileName))
+		if err != nil {
+			t.Skipf("failed read uri:%s;err=%s", fileName, err)
+		}
+		src, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil || r.StatusCode != http.StatusOK {
+			t.Skipf("failed read uri:%s;status=%s;err=%v", fileName, r.Status, err)
+		}
+		testRoundTripBytes(t, fileName, src)
+	}
+}
+
+func TestWriteFileDefault(t *testing.T) {
+	f := &File{Block: Block{ByteCode: &ByteCode{
+		Info:       StructInfo{NumCommands: 1, NumCodeBytes: 3, NumLitObjects: 1, NumCmdLocBytes: 2, MaxStackDepth: 1},
+		Code:       []byte{1, 0, 0},
+		CodeDelta:  []byte{0},
+		CodeLength: []byte{2},
+		Literals:   []*Literal{{Type: LiteralXString, Value: "hello world"}},
+	}}}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteFile(f); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ReadFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Header != defaultHeader || g.Epilogue != tbcFileEpilogue {
+		t.Errorf("wrong header or epilogue: %q,%q", g.Header, g.Epilogue)
+	}
+	if !bytes.Equal(g.ByteCode.Code, f.ByteCode.Code) || g.ByteCode.Literals[0].Value != "hello world" {
+		t.Errorf("wrong bytecode read back: %+v", g.ByteCode)
+	}
+}
+
+func TestWriteFileAuxDataExtension(t *testing.T) {
+	code := assemble(tcl86OpTable, "loadScalar1 0", "jumpTable 0", "push1 0", "done")
+	f := &File{Block: Block{Header: header86, ByteCode: &ByteCode{Code: code, Literals: stringLiterals(""),
+		Info:    StructInfo{NumCodeBytes: len(code), NumLitObjects: 1, NumAuxDataItems: 1},
+		AuxData: []*AuxData{{Type: JumpTableAuxData, JumpTable: &JumpTableInfo{Entries: []JumpTableEntry{{"a", 7}}}}}}}}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteFile(f); !errors.Is(err, ErrAuxDataExtension) {
+		t.Errorf("expected ErrAuxDataExtension, got %v", err)
+	}
+	buf.Reset()
+	w := NewWriter(&buf)
+	w.AuxDataExtension = true
+	if err := w.WriteFile(f); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ReadFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aux := g.ByteCode.AuxData; len(aux) != 1 || aux[0].JumpTable == nil || aux[0].JumpTable.Entries[0].Key != "a" {
+		t.Errorf("unexpected aux data %+v", aux)
+	}
+}